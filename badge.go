@@ -0,0 +1,83 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/orijtech/apache2conform/conform"
+)
+
+// conformingCounts returns how many of the files in rep carry a license
+// and how many were considered, that is not skipped. Added headers
+// only count if they were written, as told by written.
+func conformingCounts(rep *conform.Report, written bool) (conforming, considered int) {
+	conforming = rep.Statuses[conform.StatusConforming]
+	if written {
+		conforming += rep.Statuses[conform.StatusAdded]
+	}
+	return conforming, len(rep.Files) - rep.Statuses[conform.StatusSkipped]
+}
+
+// conformingPercent is the share of the files that were not skipped
+// which carry a license, rounded down so that 100 means every one.
+func conformingPercent(conforming, considered int) int {
+	if considered == 0 {
+		return 100
+	}
+	return conforming * 100 / considered
+}
+
+func badgeColor(percent int) string {
+	switch {
+	case percent >= 100:
+		return "#4c1"
+	case percent >= 90:
+		return "#97ca00"
+	case percent >= 75:
+		return "#dfb317"
+	default:
+		return "#e05d44"
+	}
+}
+
+// badgeTemplate lays out a flat two part badge, the label on grey and
+// the value on a color saying how close the repo is to conforming.
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[2]s: %[3]s">
+  <title>%[2]s: %[3]s</title>
+  <rect width="%[4]d" height="20" fill="#555"/>
+  <rect x="%[4]d" width="%[5]d" height="20" fill="%[6]s"/>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="%[7]d" y="14">%[2]s</text>
+    <text x="%[8]d" y="14">%[3]s</text>
+  </g>
+</svg>
+`
+
+// badgeCharWidth approximates the width of a character in the font.
+const badgeCharWidth = 7
+
+// writeBadge writes an SVG badge such as "licenses: 98% conforming" to path.
+func writeBadge(path string, percent int) error {
+	label, value := "licenses", fmt.Sprintf("%d%% conforming", percent)
+	labelWidth := len(label)*badgeCharWidth + 10
+	valueWidth := len(value)*badgeCharWidth + 10
+	svg := fmt.Sprintf(badgeTemplate,
+		labelWidth+valueWidth, label, value,
+		labelWidth, valueWidth, badgeColor(percent),
+		labelWidth/2, labelWidth+valueWidth/2)
+	return ioutil.WriteFile(path, []byte(svg), 0644)
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteBadge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apache2conform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "badge.svg")
+
+	tests := []struct {
+		percent   int
+		wantColor string
+	}{
+		{percent: 98, wantColor: `fill="#97ca00"`},
+		{percent: 100, wantColor: `fill="#4c1"`},
+		{percent: 80, wantColor: `fill="#dfb317"`},
+		{percent: 0, wantColor: `fill="#e05d44"`},
+	}
+	for _, tt := range tests {
+		if err := writeBadge(path, tt.percent); err != nil {
+			t.Fatal(err)
+		}
+		blob, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svg, value := string(blob), fmt.Sprintf("%d%% conforming", tt.percent)
+		for _, want := range []string{">" + value + "</text>", tt.wantColor, ">licenses</text>"} {
+			if !strings.Contains(svg, want) {
+				t.Errorf("%d%%: got\n%s\nwant it to contain %q", tt.percent, svg, want)
+			}
+		}
+		var doc struct {
+			XMLName xml.Name `xml:"svg"`
+			Title   string   `xml:"title"`
+		}
+		if err := xml.Unmarshal(blob, &doc); err != nil {
+			t.Errorf("%d%%: got invalid SVG: %v", tt.percent, err)
+		}
+		if want := "licenses: " + value; doc.Title != want {
+			t.Errorf("%d%%: got title %q, want %q", tt.percent, doc.Title, want)
+		}
+	}
+}
+
+func TestConformingPercent(t *testing.T) {
+	tests := []struct {
+		conforming, considered, want int
+	}{
+		{conforming: 0, considered: 0, want: 100},
+		{conforming: 3, considered: 3, want: 100},
+		{conforming: 199, considered: 200, want: 99},
+		{conforming: 1, considered: 3, want: 33},
+		{conforming: 0, considered: 5, want: 0},
+	}
+	for _, tt := range tests {
+		if got := conformingPercent(tt.conforming, tt.considered); got != tt.want {
+			t.Errorf("conformingPercent(%d, %d): got %d, want %d", tt.conforming, tt.considered, got, tt.want)
+		}
+	}
+}
+
+func TestBadgeFlag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2016), map[string]string{
+		"a.go": renderTestHeader(t, "apache2.0", 2014, "ACME") + testSource,
+		"b.go": testSource,
+	})
+	path := filepath.Join(tr.dir, "badge.svg")
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: ">50% conforming</text>"},
+		{args: []string{"-fix", "-patch", filepath.Join(tr.dir, "fix.patch")}, want: ">50% conforming</text>"},
+		{args: []string{"-fix"}, want: ">100% conforming</text>"},
+	} {
+		if out, ok := tr.run(append(tt.args, "-badge", path)...); !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		if got := readFile(t, tr.dir, "badge.svg"); !strings.Contains(got, tt.want) {
+			t.Errorf("%q: got badge\n%s\nwant it to contain %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestReportPercentage(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2016), map[string]string{
+		"a.go":       renderTestHeader(t, "apache2.0", 2014, "ACME") + testSource,
+		"b.go":       testSource,
+		"c.go":       testSource,
+		"ignored.go": "// conform:ignore\n\n" + testSource,
+	})
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: "\nConforming: 33.3% (1 of 3 files)"},
+		{args: []string{"-fix"}, want: "\nConforming: 100.0% (3 of 3 files)"},
+	} {
+		out, ok := tr.run(append(tt.args, "-report-percentage")...)
+		if !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: got output\n%s\nwant it to contain %q", tt.args, out, tt.want)
+		}
+	}
+}
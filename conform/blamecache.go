@@ -0,0 +1,152 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// blameCache remembers the earliest year computed for each file, keyed
+// by its repo relative path and the hash of its contents, so that later
+// runs can skip blaming files that have not changed since.
+type blameCache struct {
+	path string
+
+	mu    sync.Mutex
+	data  blameCacheData
+	dirty bool
+	// seen holds the contents hashes of files
+	// that this run left in a settled state.
+	seen map[string]string
+}
+
+type blameCacheData struct {
+	// SkipMerges and Creation record the options that the years
+	// were computed with, a cache made with others is discarded.
+	SkipMerges bool                        `json:"skip_merges"`
+	Creation   bool                        `json:"creation"`
+	Files      map[string]*blameCacheEntry `json:"files"`
+	// Seen maps the files that the last successful run processed
+	// to the hash of the contents it left them with.
+	Seen map[string]string `json:"seen,omitempty"`
+}
+
+type blameCacheEntry struct {
+	Hash string `json:"hash"`
+	Year int    `json:"year"`
+}
+
+// openBlameCache loads the cache at path, starting an empty one if
+// the file does not exist yet or was made with different options.
+func openBlameCache(path string, skipMerges, creation bool) (*blameCache, error) {
+	bc := &blameCache{path: path, seen: make(map[string]string)}
+	blob, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(blob, &bc.data); err != nil {
+			return nil, err
+		}
+	}
+	if bc.data.Files == nil || bc.data.SkipMerges != skipMerges || bc.data.Creation != creation {
+		bc.data = blameCacheData{SkipMerges: skipMerges, Creation: creation, Files: make(map[string]*blameCacheEntry)}
+	}
+	if bc.data.Seen == nil {
+		bc.data.Seen = make(map[string]string)
+	}
+	return bc, nil
+}
+
+func contentHash(blob []byte) string {
+	sum := sha256.Sum256(blob)
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns the year cached for relPath if its contents still
+// hash to hash. A nil blameCache never has anything cached.
+func (bc *blameCache) lookup(relPath, hash string) (int, bool) {
+	if bc == nil {
+		return 0, false
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	entry, ok := bc.data.Files[relPath]
+	if !ok || entry.Hash != hash {
+		return 0, false
+	}
+	return entry.Year, true
+}
+
+func (bc *blameCache) store(relPath, hash string, year int) {
+	if bc == nil {
+		return
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.data.Files[relPath] = &blameCacheEntry{Hash: hash, Year: year}
+	bc.dirty = true
+}
+
+// unchangedSinceLastRun reports whether the last successful
+// run left relPath with contents that still hash to hash.
+func (bc *blameCache) unchangedSinceLastRun(relPath, hash string) bool {
+	if bc == nil {
+		return false
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.data.Seen[relPath] == hash
+}
+
+// markSeen records that this run left relPath with contents hashing to hash.
+func (bc *blameCache) markSeen(relPath, hash string) {
+	if bc == nil {
+		return
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.seen[relPath] = hash
+}
+
+// save writes the cache back to its file if anything was stored.
+// The files seen by this run are only kept if it was successful.
+func (bc *blameCache) save(successful bool) error {
+	if bc == nil {
+		return nil
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if successful && len(bc.seen) > 0 {
+		for relPath, hash := range bc.seen {
+			bc.data.Seen[relPath] = hash
+		}
+		bc.dirty = true
+	}
+	if !bc.dirty {
+		return nil
+	}
+	blob, err := json.MarshalIndent(&bc.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(bc.path, blob, 0644)
+}
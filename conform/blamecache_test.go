@@ -0,0 +1,288 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlameCacheLookup(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cache.json")
+
+	bc, err := openBlameCache(path, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.store("a.go", "hash1", 2017)
+	if err := bc.save(true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		skipMerges bool
+		creation   bool
+		hash       string
+		wantYear   int
+		wantOK     bool
+	}{
+		{name: "unchanged", hash: "hash1", wantYear: 2017, wantOK: true},
+		{name: "contents changed", hash: "hash2"},
+		{name: "other merge handling", skipMerges: true, hash: "hash1"},
+		{name: "dated by creation", creation: true, hash: "hash1"},
+	}
+	for _, tt := range tests {
+		bc, err := openBlameCache(path, tt.skipMerges, tt.creation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		year, ok := bc.lookup("a.go", tt.hash)
+		if year != tt.wantYear || ok != tt.wantOK {
+			t.Errorf("%s: got %d, %v, want %d, %v", tt.name, year, ok, tt.wantYear, tt.wantOK)
+		}
+	}
+
+	var nilCache *blameCache
+	if _, ok := nilCache.lookup("a.go", "hash1"); ok {
+		t.Error("got a year from a nil cache")
+	}
+}
+
+func TestBlameCacheSecondRun(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Jane Doe", inYear(2018), map[string]string{"a.go": testSource, "b.go": testSource})
+	cacheDir, cleanupCache := tempDir(t)
+	defer cleanupCache()
+	cacheFile := filepath.Join(cacheDir, "cache.json")
+
+	if _, err := tr.conform(Options{BlameCacheFile: cacheFile}); err != nil {
+		t.Fatal(err)
+	}
+	// Years that blame could not have come up with
+	// show that the second run took them from the cache.
+	var data blameCacheData
+	blob, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(blob, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Files) != 2 {
+		t.Fatalf("got %d files cached, want 2", len(data.Files))
+	}
+	for _, entry := range data.Files {
+		if entry.Year != 2018 {
+			t.Errorf("got year %d cached, want 2018", entry.Year)
+		}
+		entry.Year = 1999
+	}
+	if blob, err = json.Marshal(&data); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cacheFile, blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Changing b.go invalidates its entry, it is blamed again.
+	tr.commit("Jane Doe", inYear(2019), map[string]string{"b.go": testSource + "\nvar b = 1\n"})
+
+	if _, err := tr.conform(Options{Fix: true, BlameCacheFile: cacheFile}); err != nil {
+		t.Fatal(err)
+	}
+	for rel, want := range map[string]string{"a.go": "Copyright 1999 ", "b.go": "Copyright 2018 "} {
+		if got := tr.read(rel); !strings.Contains(got, want) {
+			t.Errorf("%s: got\n%s\nwant it to contain %q", rel, got, want)
+		}
+	}
+}
+
+func TestOnlyChangedSinceLastRun(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	cacheDir, cleanupCache := tempDir(t)
+	defer cleanupCache()
+	cacheFile := filepath.Join(cacheDir, "cache.json")
+	conforming := renderTestHeader(t, shortApache2Point0Templ, 2016, "ACME") + testSource
+
+	runs := []struct {
+		name  string
+		files map[string]string
+		want  map[string]string
+	}{
+		{
+			name:  "first run",
+			files: map[string]string{"a.go": testSource, "b.go": conforming},
+			want:  map[string]string{"a.go": StatusAdded, "b.go": StatusConforming},
+		},
+		{
+			name: "nothing changed",
+			want: map[string]string{"a.go": StatusSkipped, "b.go": StatusSkipped},
+		},
+		{
+			name:  "one changed and one new",
+			files: map[string]string{"b.go": conforming + "\nvar b = 1\n", "c.go": testSource},
+			want:  map[string]string{"a.go": StatusSkipped, "b.go": StatusConforming, "c.go": StatusAdded},
+		},
+	}
+	for i, run := range runs {
+		// Commit what the previous run stamped along with this run's changes.
+		files := make(map[string]string)
+		if i > 0 {
+			files["a.go"] = tr.read("a.go")
+		}
+		for rel, contents := range run.files {
+			files[rel] = contents
+		}
+		tr.commit("Alice", inYear(2018+i), files)
+		rep, err := tr.conform(Options{Fix: true, BlameCacheFile: cacheFile, OnlyChanged: true})
+		if err != nil {
+			t.Fatalf("%s: %v", run.name, err)
+		}
+		if got := tr.statuses(rep); !reflect.DeepEqual(got, run.want) {
+			t.Errorf("%s: got statuses %v, want %v", run.name, got, run.want)
+		}
+	}
+
+	if _, err := tr.conform(Options{OnlyChanged: true}); err == nil || !strings.Contains(err.Error(), "needs a blame cache file") {
+		t.Errorf("got error %v, want one asking for a blame cache file", err)
+	}
+}
+
+func TestFailOnZeroYear(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Jane Doe", inYear(2018), map[string]string{"a.go": testSource})
+	cacheDir, cleanupCache := tempDir(t)
+	defer cleanupCache()
+	cacheFile := filepath.Join(cacheDir, "cache.json")
+	if _, err := tr.conform(Options{BlameCacheFile: cacheFile}); err != nil {
+		t.Fatal(err)
+	}
+	// A cached year of 0 stands in for blame coming up with none.
+	var data blameCacheData
+	if err := json.Unmarshal([]byte(readFile(t, cacheDir, "cache.json")), &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range data.Files {
+		entry.Year = 0
+	}
+	blob, err := json.Marshal(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fail := range []bool{false, true} {
+		if err := ioutil.WriteFile(cacheFile, blob, 0644); err != nil {
+			t.Fatal(err)
+		}
+		rep, err := tr.conform(Options{Fix: true, BlameCacheFile: cacheFile, FailOnZeroYear: fail})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr := rep.Files[0]
+		if fail && fr.Err != errNoYear {
+			t.Errorf("got error %v, want %v", fr.Err, errNoYear)
+		}
+		if !fail && (fr.Err != nil || fr.Status != StatusMissing) {
+			t.Errorf("got status %q error %v without the option, want %q", fr.Status, fr.Err, StatusMissing)
+		}
+		if got := tr.read("a.go"); got != testSource {
+			t.Errorf("fail=%v: got\n%s\nwant it unstamped", fail, got)
+		}
+	}
+}
+
+func TestFailOnUndatedBlame(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	// Git reads a commit without a usable date as made at the epoch.
+	tr.commit("Jane Doe", time.Unix(0, 0), map[string]string{"a.go": testSource})
+	rep, err := tr.conform(Options{Fix: true, FailOnZeroYear: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr := rep.Files[0]; fr.Err != errNoYear {
+		t.Errorf("got status %q error %v, want %v", fr.Status, fr.Err, errNoYear)
+	}
+	if got := tr.read("a.go"); got != testSource {
+		t.Errorf("got\n%s\nwant it unstamped", got)
+	}
+}
+
+func TestOnlyChangedWithStagedWrites(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	cacheDir, cleanupCache := tempDir(t)
+	defer cleanupCache()
+	opts := Options{Fix: true, FixOnlyIfValid: true, BlameCacheFile: filepath.Join(cacheDir, "cache.json"), OnlyChanged: true}
+
+	// Nothing is written while a file does not parse, so
+	// nothing may be remembered as seen either.
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource, "bad.go": "package a\nfunc {\n"})
+	rep, err := tr.conform(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Unparsable) != 1 || tr.read("a.go") != testSource {
+		t.Fatalf("got unparsable %q and a.go\n%s", rep.Unparsable, tr.read("a.go"))
+	}
+
+	tr.commit("Alice", inYear(2019), map[string]string{"bad.go": "package a\n"})
+	for _, want := range []map[string]string{
+		{"a.go": StatusAdded, "bad.go": StatusAdded},
+		{"a.go": StatusSkipped, "bad.go": StatusSkipped},
+	} {
+		rep, err := tr.conform(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.statuses(rep); !reflect.DeepEqual(got, want) {
+			t.Errorf("got statuses %v, want %v", got, want)
+		}
+	}
+	if got := tr.read("a.go"); !strings.HasPrefix(got, "// Copyright 2018 ") {
+		t.Errorf("got a.go\n%s\nwant it stamped for 2018", got)
+	}
+}
+
+func TestOnlyChangedRetriesBlameTimeouts(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	cacheDir, cleanupCache := tempDir(t)
+	defer cleanupCache()
+	opts := Options{Fix: true, BlameCacheFile: filepath.Join(cacheDir, "cache.json"), OnlyChanged: true, BlameTimeout: time.Nanosecond}
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+
+	// A file skipped for its slow blame is looked at again next time.
+	for _, want := range []string{StatusSkipped, StatusAdded} {
+		rep, err := tr.conform(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.statuses(rep)["a.go"]; got != want {
+			t.Errorf("BlameTimeout=%v: got status %q, want %q", opts.BlameTimeout, got, want)
+		}
+		opts.BlameTimeout = 0
+	}
+}
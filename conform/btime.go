@@ -0,0 +1,34 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"os"
+	"time"
+)
+
+// fsCreationTime is when the file at path was created according to the
+// file system, for files that git has no history of. Where birth times
+// are not available, see birthTime, its modification time is used.
+func fsCreationTime(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return blankTime, err
+	}
+	if born, ok := birthTime(path, fi); ok {
+		return born, nil
+	}
+	return fi.ModTime(), nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBirthTime(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	before := time.Now().Add(-time.Second)
+	writeFiles(t, dir, map[string]string{"a.go": testSource})
+	path := filepath.Join(dir, "a.go")
+	// An older modification time must not be taken for the birth time.
+	old := time.Date(2016, time.June, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	born, ok := birthTime(path, fi)
+	if !ok {
+		t.Skipf("the file system of %s records no birth times", dir)
+	}
+	if born.Before(before) || born.After(time.Now()) {
+		t.Errorf("got birth time %v, want the time a.go was written", born)
+	}
+	if _, ok := birthTime(filepath.Join(dir, "missing.go"), fi); ok {
+		t.Error("got a birth time for a missing file")
+	}
+}
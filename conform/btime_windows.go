@@ -0,0 +1,33 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package conform
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthTime returns the creation time of the file that fi describes.
+func birthTime(path string, fi os.FileInfo) (time.Time, bool) {
+	attrs, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return blankTime, false
+	}
+	return time.Unix(0, attrs.CreationTime.Nanoseconds()), true
+}
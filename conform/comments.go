@@ -0,0 +1,361 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// CommentStyle describes how a family of source
+// files spells its comments, see CommentStyleNamed.
+type CommentStyle struct {
+	name string
+	// linePrefix starts every line of a comment.
+	linePrefix string
+	// blockStart and blockEnd if set enclose the whole header
+	// instead, for formats such as HTML that lack line comments.
+	blockStart, blockEnd string
+	// blockLine indents each line of text within a block.
+	blockLine string
+	// preamble if set matches leading lines, such as tool directives,
+	// that must stay above the license header.
+	preamble *regexp.Regexp
+}
+
+// slashComments are Go's, whose build constraints must stay above
+// the header. gofmt requires a blank line between them and the code.
+var slashComments = &CommentStyle{
+	name:       "//",
+	linePrefix: "//",
+	preamble:   regexp.MustCompile(`^//(?:go:build\s|\s*\+build\s)`),
+}
+
+// dashComments are SQL's, where migration tools such as sql-migrate
+// and goose read "-- +migrate Up" style directives from the top.
+var dashComments = &CommentStyle{
+	name:       "--",
+	linePrefix: "--",
+	preamble:   regexp.MustCompile(`^--\s*\+\w+`),
+}
+
+// hashComments suit R, Julia, Python, shell style formats and, for
+// want of any syntax of their own, plain text files.
+var hashComments = &CommentStyle{name: "#", linePrefix: "#"}
+
+// blockComments are C's, as used by C++ and Java too.
+var blockComments = &CommentStyle{name: "/* */", blockStart: "/*", blockLine: " * ", blockEnd: " */"}
+
+var htmlComments = &CommentStyle{name: "<!-- -->", blockStart: "<!--", blockLine: "  ", blockEnd: "-->"}
+
+// xmlComments differ from HTML's in that the XML
+// declaration, if any, must be the very first thing.
+var xmlComments = &CommentStyle{
+	name:       "<!-- -->",
+	blockStart: "<!--",
+	blockLine:  "  ",
+	blockEnd:   "-->",
+	preamble:   regexp.MustCompile(`^<\?xml\s`),
+}
+
+var commentStyles = []*CommentStyle{slashComments, dashComments, hashComments}
+
+// commentStylesByName are the styles that Options.StampExtensions
+// can assign.
+var commentStylesByName = map[string]*CommentStyle{
+	"slash": slashComments,
+	"dash":  dashComments,
+	"hash":  hashComments,
+	"c":     blockComments,
+	"html":  htmlComments,
+	"xml":   xmlComments,
+}
+
+// CommentStyleNamed returns the comment style called name, one
+// of slash, dash, hash, c, html or xml, ignoring case.
+func CommentStyleNamed(name string) (*CommentStyle, bool) {
+	style, ok := commentStylesByName[strings.ToLower(name)]
+	return style, ok
+}
+
+// blockDelimiters are the openings and closings of block comments.
+var blockDelimiters = [][2]string{
+	{"/*", "*/"},
+	{htmlComments.blockStart, htmlComments.blockEnd},
+}
+
+// blockCommentLen returns the length of the block comment that b
+// starts with, or len(b) if it is unterminated, and false if b does
+// not start with a block comment at all.
+func blockCommentLen(b []byte) (int, bool) {
+	for _, delims := range blockDelimiters {
+		if !bytes.HasPrefix(b, []byte(delims[0])) {
+			continue
+		}
+		end := bytes.Index(b, []byte(delims[1]))
+		if end < 0 {
+			return len(b), true
+		}
+		return end + len(delims[1]), true
+	}
+	return 0, false
+}
+
+// isLineComment reports whether line, stripped of leading
+// whitespace, is a line comment in style or any other known style.
+// A "#" only opens a comment in files that use hash comments since
+// in C and its kin it starts a preprocessor directive.
+func isLineComment(line []byte, style *CommentStyle) bool {
+	if style != nil && style.linePrefix != "" && bytes.HasPrefix(line, []byte(style.linePrefix)) {
+		return true
+	}
+	for _, known := range commentStyles {
+		if known != hashComments && bytes.HasPrefix(line, []byte(known.linePrefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// restyle rewrites the `//` line comments that the templates
+// are written in to this style's comments.
+func (cs *CommentStyle) restyle(header []byte) []byte {
+	if cs == slashComments {
+		return header
+	}
+	if cs.blockStart != "" {
+		return cs.enclose(header)
+	}
+	lines := bytes.SplitAfter(header, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(line, []byte(slashComments.linePrefix)) {
+			lines[i] = append([]byte(cs.linePrefix), line[len(slashComments.linePrefix):]...)
+		}
+	}
+	return bytes.Join(lines, nil)
+}
+
+// enclose strips the `//` markers from the comment lines of
+// header and wraps them between blockStart and blockEnd, keeping
+// the blank lines that follow so the code stays apart.
+func (cs *CommentStyle) enclose(header []byte) []byte {
+	body := bytes.TrimRight(header, "\n")
+	trailer := header[len(body):]
+	buf := new(bytes.Buffer)
+	buf.WriteString(cs.blockStart + "\n")
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimPrefix(line, []byte(slashComments.linePrefix))
+		line = bytes.TrimPrefix(line, []byte(" "))
+		if len(line) > 0 {
+			buf.WriteString(cs.blockLine)
+			buf.Write(line)
+		} else {
+			buf.WriteString(strings.TrimRight(cs.blockLine, " "))
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(cs.blockEnd)
+	buf.Write(trailer)
+	return buf.Bytes()
+}
+
+// regCodingLine matches Python's source encoding declaration, which
+// PEP 263 requires to be on the first or second line.
+var regCodingLine = regexp.MustCompile(`^[ \t\f]*#.*?coding[:=][ \t]*[-\w.]+`)
+
+// utf8BOM is the byte-order mark that some editors start UTF-8 files
+// with. It has to stay first, so headers go in right after it.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// bomLen returns the length of the byte-order mark that b starts
+// with, 0 if there is none.
+func bomLen(b []byte) int {
+	if bytes.HasPrefix(b, utf8BOM) {
+		return len(utf8BOM)
+	}
+	return 0
+}
+
+// shebangLen returns the length of the "#!" interpreter line that b
+// starts with, which must stay first for a script to run, together
+// with an encoding declaration right after it. It is 0 if there is none.
+func shebangLen(b []byte) int {
+	if !bytes.HasPrefix(b, []byte("#!")) {
+		return 0
+	}
+	n := len(b)
+	if nl := bytes.IndexByte(b, '\n'); nl >= 0 {
+		n = nl + 1
+	}
+	if next := b[n:]; regCodingLine.Match(next) {
+		if nl := bytes.IndexByte(next, '\n'); nl >= 0 {
+			return n + nl + 1
+		}
+		return len(b)
+	}
+	return n
+}
+
+// preambleLen returns the length of the run of
+// lines at the start of b that match the preamble.
+func (cs *CommentStyle) preambleLen(b []byte) int {
+	if cs.preamble == nil {
+		return 0
+	}
+	n := 0
+	for n < len(b) {
+		line := b[n:]
+		if nl := bytes.IndexByte(line, '\n'); nl >= 0 {
+			line = line[:nl+1]
+		}
+		if !cs.preamble.Match(line) {
+			break
+		}
+		n += len(line)
+	}
+	return n
+}
+
+// isComment reports whether line is entirely a comment in this style.
+// Block styles have no per line marker, see isEnclosed.
+func (cs *CommentStyle) isComment(line string) bool {
+	return cs.blockStart != "" || strings.HasPrefix(line, cs.linePrefix)
+}
+
+// isEnclosed reports whether header, short of its trailing blank
+// lines, is a single block comment when this is a block style.
+func (cs *CommentStyle) isEnclosed(header string) bool {
+	if cs.blockStart == "" {
+		return true
+	}
+	header = strings.TrimRight(header, "\n")
+	return strings.HasPrefix(header, cs.blockStart) && strings.HasSuffix(header, cs.blockEnd) &&
+		strings.Count(header, cs.blockEnd) == 1
+}
+
+// renderHeader executes tmpl for info in the comment style of a file.
+func renderHeader(tmpl *template.Template, info *copyright, style *CommentStyle) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, info); err != nil {
+		return nil, err
+	}
+	return style.restyle(buf.Bytes()), nil
+}
+
+// trimTrailingSpace strips the spaces and tabs that the lines of
+// header end in, e.g. one left after a comment marker.
+func trimTrailingSpace(header []byte) []byte {
+	trimmed := make([]byte, 0, len(header))
+	for _, line := range bytes.SplitAfter(header, []byte("\n")) {
+		body := bytes.TrimSuffix(line, []byte("\n"))
+		trimmed = append(trimmed, bytes.TrimRight(body, " \t")...)
+		trimmed = append(trimmed, line[len(body):]...)
+	}
+	return trimmed
+}
+
+// insertHeader returns original with header placed after its
+// byte-order mark, shebang and preamble, if any, or otherwise at the
+// top. The header goes right below a shebang, on the second line.
+func insertHeader(original, header []byte, style *CommentStyle) []byte {
+	if n := bomLen(original); n > 0 {
+		licensed := append([]byte(nil), original[:n]...)
+		return append(licensed, insertHeader(original[n:], header, style)...)
+	}
+	if n := shebangLen(original); n > 0 {
+		licensed := append([]byte(nil), original[:n]...)
+		if !bytes.HasSuffix(licensed, []byte("\n")) {
+			licensed = append(licensed, '\n')
+		}
+		return append(licensed, insertHeader(trimLeadingBlankLines(original[n:]), header, style)...)
+	}
+	pre := style.preambleLen(original)
+	licensed := make([]byte, 0, len(original)+len(header)+2)
+	licensed = append(licensed, original[:pre]...)
+	if pre > 0 {
+		if !bytes.HasSuffix(licensed, []byte("\n")) {
+			licensed = append(licensed, '\n')
+		}
+		licensed = append(licensed, '\n')
+	}
+	licensed = append(licensed, header...)
+	if pre > 0 {
+		// The header already ends in the blank line that
+		// separated the preamble from what follows it.
+		return append(licensed, trimLeadingBlankLines(original[pre:])...)
+	}
+	return append(licensed, original[pre:]...)
+}
+
+// validateTemplates renders the template picked for each stamped file
+// extension with a sample copyright, returning a description of every
+// header that would not be a valid comment for files of that extension
+// or that would not be recognized as a license on a later run.
+func validateTemplates(et *extensionTable, templateFor func(path string) *template.Template, contains func([]byte) bool) []string {
+	exts := make([]string, 0, len(et.styles))
+	for ext := range et.styles {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	sample := newCopyright(time.Now().Year(), []string{"Sample Holder"}, func(holder string) string { return holder })
+	var problems []string
+	for _, ext := range exts {
+		style := et.styles[ext]
+		tmpl := templateFor("sample" + ext)
+		header, err := renderHeader(tmpl, sample, style)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %q failed to render: %v", ext, tmpl.Name(), err))
+			continue
+		}
+		for i, line := range strings.Split(strings.TrimRight(string(header), "\n"), "\n") {
+			if line != "" && !style.isComment(line) {
+				problems = append(problems, fmt.Sprintf("%s: %q line %d is not a %s comment: %q", ext, tmpl.Name(), i+1, style.name, line))
+			}
+		}
+		if !style.isEnclosed(string(header)) {
+			problems = append(problems, fmt.Sprintf("%s: %q does not render as a single %s comment", ext, tmpl.Name(), style.name))
+		}
+		if !bytes.HasSuffix(header, []byte("\n\n")) {
+			problems = append(problems, fmt.Sprintf("%s: %q must end with a blank line to keep it apart from the code", ext, tmpl.Name()))
+		}
+		if !contains(header) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not detected as a license so every run would stamp it again", ext, tmpl.Name()))
+		}
+	}
+	return problems
+}
+
+// largestHeader returns the size of the largest header that the templates
+// render with a sample copyright, along with the extension its files have.
+func largestHeader(et *extensionTable, templateFor func(path string) *template.Template) (size int, ext string, err error) {
+	sample := newCopyright(time.Now().Year(), []string{"Sample Holder"}, func(holder string) string { return holder })
+	for e, style := range et.styles {
+		tmpl := templateFor("sample" + e)
+		header, err := renderHeader(tmpl, sample, style)
+		if err != nil {
+			return 0, "", fmt.Errorf("%s: %q failed to render: %v", e, tmpl.Name(), err)
+		}
+		if len(header) > size || (len(header) == size && e < ext) {
+			size, ext = len(header), e
+		}
+	}
+	return size, ext, nil
+}
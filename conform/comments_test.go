@@ -0,0 +1,581 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestValidateTemplates(t *testing.T) {
+	tests := []struct {
+		name string
+		tmpl string
+		// want are substrings of the problems reported for each of
+		// the stamped extensions, none if the template is valid.
+		want []string
+		// lineOnly if set expects problems only where comments are
+		// line comments, since a block encloses any text.
+		lineOnly bool
+	}{
+		{name: "apache2.0", tmpl: shortApache2Point0},
+		{name: "BSD", tmpl: shortBSD},
+		{name: "not a comment", tmpl: "Copyright {{.Year}} {{.Holder}}. All rights reserved.\n\n", want: []string{"line 1 is not a "}, lineOnly: true},
+		{name: "no blank line", tmpl: "// Copyright {{.Year}} {{.Holder}}. All rights reserved.\n", want: []string{"must end with a blank line"}},
+		{name: "not a license", tmpl: "// Written by {{.Holder}}.\n\n", want: []string{"is not detected as a license"}},
+		{name: "missing field", tmpl: "// Copyright {{.Year}} {{.Owner}}. All rights reserved.\n\n", want: []string{"failed to render"}},
+	}
+	et := testExtensions(t)
+	for _, tt := range tests {
+		tmpl := template.Must(template.New(tt.name).Parse(tt.tmpl))
+		problems := validateTemplates(et, func(string) *template.Template { return tmpl }, containsALicense)
+		exts := 0
+		for _, style := range et.styles {
+			if !tt.lineOnly || style.blockStart == "" {
+				exts++
+			}
+		}
+		if len(problems) != len(tt.want)*exts {
+			t.Errorf("%s: got problems %q, want %d", tt.name, problems, len(tt.want)*exts)
+			continue
+		}
+		for i, problem := range problems {
+			if want := tt.want[i%len(tt.want)]; !strings.Contains(problem, want) {
+				t.Errorf("%s: got problem %q, want it to mention %q", tt.name, problem, want)
+			}
+		}
+	}
+}
+
+func TestInsertHeaderPreamble(t *testing.T) {
+	info := newCopyright(2018, []string{"ACME"}, chainHolderFilters())
+	header, err := renderHeader(shortApache2Point0Templ, info, dashComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(header), "-- Copyright 2018 ACME.") {
+		t.Fatalf("got header\n%s\nwant it in -- comments", header)
+	}
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{
+			name:     "no directive",
+			contents: "CREATE TABLE a (id INT);\n",
+			want:     string(header) + "CREATE TABLE a (id INT);\n",
+		},
+		{
+			name:     "sql-migrate",
+			contents: "-- +migrate Up\nCREATE TABLE a (id INT);\n",
+			want:     "-- +migrate Up\n\n" + string(header) + "CREATE TABLE a (id INT);\n",
+		},
+		{
+			name:     "goose",
+			contents: "-- +goose Up\n-- +goose StatementBegin\nCREATE TABLE a (id INT);\n",
+			want:     "-- +goose Up\n-- +goose StatementBegin\n\n" + string(header) + "CREATE TABLE a (id INT);\n",
+		},
+		{
+			name:     "directive in a plain comment",
+			contents: "-- Creates a, see +migrate.\nCREATE TABLE a (id INT);\n",
+			want:     string(header) + "-- Creates a, see +migrate.\nCREATE TABLE a (id INT);\n",
+		},
+		{
+			name:     "directive only",
+			contents: "-- +migrate Down",
+			want:     "-- +migrate Down\n\n" + string(header),
+		},
+	}
+	for _, tt := range tests {
+		if got := string(insertHeader([]byte(tt.contents), header, dashComments)); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSQLMigrationDirectives(t *testing.T) {
+	schema := "CREATE TABLE a (\n\tid INT PRIMARY KEY,\n\tname TEXT NOT NULL\n);\n"
+	contents := "-- +migrate Up\n" + strings.Repeat(schema, 10)
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"1_init.sql": contents})
+	for i := 0; i < 2; i++ {
+		if _, err := tr.conform(Options{Fix: true, Languages: allLanguages()}); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+		got := tr.read("1_init.sql")
+		if want := "-- +migrate Up\n\n-- Copyright 2018 ACME."; !strings.HasPrefix(got, want) {
+			t.Errorf("run %d: got\n%s\nwant it to start with %q", i+1, got, want)
+		}
+		if !strings.HasSuffix(got, "limitations under the License.\n\n"+strings.Repeat(schema, 10)) {
+			t.Errorf("run %d: got\n%s\nwant the schema after the header", i+1, got)
+		}
+		tr.commit("Alice", inYear(2019), map[string]string{"1_init.sql": got})
+	}
+}
+
+func TestEnclose(t *testing.T) {
+	header := "// Copyright 2018 ACME.\n//\n// Licensed under the License.\n\n"
+	want := "<!--\n  Copyright 2018 ACME.\n\n  Licensed under the License.\n-->\n\n"
+	if got := string(htmlComments.restyle([]byte(header))); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !htmlComments.isEnclosed(want) {
+		t.Errorf("%q: got not enclosed, want enclosed", want)
+	}
+	if twice := want + want; htmlComments.isEnclosed(twice) {
+		t.Errorf("%q: got enclosed, want two comments", twice)
+	}
+}
+
+func TestStampExtensions(t *testing.T) {
+	html := "<html>\n<body>\n" + strings.Repeat("<p>The quick brown fox jumps over the lazy dog.</p>\n", 15) + "</body>\n</html>\n"
+	notes := strings.Repeat("Notes on the quick brown fox that jumps over the lazy dog.\n", 15)
+	files := map[string]string{"a.go": testSource, "index.html": html, "notes.txt": notes, "notes.md": notes}
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), files)
+
+	info := newCopyright(2018, []string{"ACME"}, chainHolderFilters())
+	want := map[string]string{"a.go": renderTestHeader(t, shortApache2Point0Templ, 2018, "ACME") + testSource, "notes.md": notes}
+	for rel, style := range map[string]*CommentStyle{"index.html": htmlComments, "notes.txt": hashComments} {
+		header, err := renderHeader(shortApache2Point0Templ, info, style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[rel] = string(header) + files[rel]
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := tr.conform(Options{Fix: true, StampExtensions: map[string]string{"html": "HTML", ".txt": "hash"}}); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+		for rel, contents := range want {
+			if got := tr.read(rel); got != contents {
+				t.Errorf("run %d: %s: got\n%s\nwant\n%s", i+1, rel, got, contents)
+			}
+		}
+		tr.commit("Alice", inYear(2019), want)
+	}
+
+	if _, err := tr.conform(Options{StampExtensions: map[string]string{".html": "markdown"}}); err == nil || !strings.Contains(err.Error(), `unknown comment style "markdown"`) {
+		t.Errorf("got error %v, want an unknown comment style", err)
+	}
+}
+
+func TestBuildConstraintOnlyFiles(t *testing.T) {
+	header := renderTestHeader(t, shortApache2Point0Templ, 2018, "ACME")
+	source := strings.TrimSuffix(testSource, "\n")
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{
+			name:     "go:build",
+			contents: "//go:build ignore\n\n" + testSource,
+			want:     "//go:build ignore\n\n" + header + testSource,
+		},
+		{
+			name:     "no blank line before the package clause",
+			contents: "//go:build ignore\n" + testSource,
+			want:     "//go:build ignore\n\n" + header + testSource,
+		},
+		{
+			name:     "no trailing newline",
+			contents: "//go:build ignore\n\n" + source,
+			want:     "//go:build ignore\n\n" + header + source,
+		},
+		{
+			name:     "+build",
+			contents: "// +build ignore\n\n" + testSource,
+			want:     "// +build ignore\n\n" + header + testSource,
+		},
+		{
+			name:     "go:build and +build",
+			contents: "//go:build ignore\n// +build ignore\n\n" + testSource,
+			want:     "//go:build ignore\n// +build ignore\n\n" + header + testSource,
+		},
+		{
+			name:     "several +build lines",
+			contents: "// +build ignore\n// +build linux\n" + testSource,
+			want:     "// +build ignore\n// +build linux\n\n" + header + testSource,
+		},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2018), map[string]string{"a.go": tt.contents})
+		if _, err := tr.conform(Options{Fix: true}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := tr.read("a.go"); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		// The constraint must still be in effect.
+		if match, err := build.Default.MatchFile(tr.dir, "a.go"); err != nil || match {
+			t.Errorf("%s: got match %v, %v, want the file ignored", tt.name, match, err)
+		}
+	}
+}
+
+func TestRAndJulia(t *testing.T) {
+	info := newCopyright(2018, []string{"ACME"}, chainHolderFilters())
+	rendered, err := renderHeader(shortApache2Point0Templ, info, hashComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := string(rendered)
+	plots := strings.Repeat("plot(1:10, main = \"The quick brown fox jumps over the lazy dog\")\n", 10)
+	prints := strings.Repeat("println(\"The quick brown fox jumps over the lazy dog\")\n", 12)
+	tests := []struct {
+		name     string
+		path     string
+		contents string
+		want     string
+	}{
+		{
+			name:     "R script",
+			path:     "plot.R",
+			contents: "#!/usr/bin/env Rscript\n" + plots,
+			want:     "#!/usr/bin/env Rscript\n" + header + plots,
+		},
+		{
+			name:     "roxygen",
+			path:     "R/add.r",
+			contents: "#' Adds two numbers.\n#' @export\nadd <- function(x, y) x + y\n" + plots,
+			want:     header + "#' Adds two numbers.\n#' @export\nadd <- function(x, y) x + y\n" + plots,
+		},
+		{
+			name:     "Julia",
+			path:     "src/A.jl",
+			contents: "module A\n" + prints + "end\n",
+			want:     header + "module A\n" + prints + "end\n",
+		},
+		{
+			name:     "Julia script",
+			path:     "run.jl",
+			contents: "#!/usr/bin/env julia\n" + prints,
+			want:     "#!/usr/bin/env julia\n" + header + prints,
+		},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2018), map[string]string{tt.path: tt.contents})
+		for i := 0; i < 2; i++ {
+			if _, err := tr.conform(Options{Fix: true, Languages: allLanguages()}); err != nil {
+				t.Fatalf("%s: run %d: %v", tt.name, i+1, err)
+			}
+			got := tr.read(tt.path)
+			if got != tt.want {
+				t.Errorf("%s: run %d: got\n%s\nwant\n%s", tt.name, i+1, got, tt.want)
+			}
+			tr.commit("Alice", inYear(2019), map[string]string{tt.path: got})
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	info := newCopyright(2018, []string{"ACME"}, chainHolderFilters())
+	rendered, err := renderHeader(shortApache2Point0Templ, info, hashComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := string(rendered)
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{
+			name:     "no shebang",
+			contents: "echo hi\n",
+			want:     header + "echo hi\n",
+		},
+		{
+			name:     "shebang",
+			contents: "#!/bin/sh\necho hi\n",
+			want:     "#!/bin/sh\n" + header + "echo hi\n",
+		},
+		{
+			name:     "blank lines after shebang",
+			contents: "#!/bin/sh\n\n\necho hi\n",
+			want:     "#!/bin/sh\n" + header + "echo hi\n",
+		},
+		{
+			name:     "encoding declaration",
+			contents: "#!/usr/bin/env python\n# -*- coding: utf-8 -*-\nprint('hi')\n",
+			want:     "#!/usr/bin/env python\n# -*- coding: utf-8 -*-\n" + header + "print('hi')\n",
+		},
+		{
+			name:     "comment after shebang",
+			contents: "#!/usr/bin/env python\n# Prints hi.\nprint('hi')\n",
+			want:     "#!/usr/bin/env python\n" + header + "# Prints hi.\nprint('hi')\n",
+		},
+		{
+			name:     "shebang only",
+			contents: "#!/bin/sh",
+			want:     "#!/bin/sh\n" + header,
+		},
+	}
+	for _, tt := range tests {
+		if got := string(insertHeader([]byte(tt.contents), rendered, hashComments)); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	script := "#!/usr/bin/env python\n# coding=utf-8\n\n" + strings.Repeat("print('The quick brown fox jumps over the lazy dog')\n", 14)
+	tr.commit("Alice", inYear(2018), map[string]string{"run.py": script})
+	if _, err := tr.conform(Options{Fix: true, Languages: []string{"python"}}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := "#!/usr/bin/env python\n# coding=utf-8\n" + header + strings.TrimPrefix(script, "#!/usr/bin/env python\n# coding=utf-8\n\n")
+	if got := tr.read("run.py"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRoundTripEveryLanguage(t *testing.T) {
+	code := strings.Repeat("x = 1 + 2 + 3 + 4 + 5 + 6 + 7 + 8 + 9 + 10 + 11 + 12 + 13\n", 12)
+	files := make(map[string]string)
+	for _, lang := range sourceLanguages {
+		for _, ext := range lang.exts {
+			files[lang.name+"/a"+ext] = code
+		}
+	}
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), files)
+
+	et := testExtensions(t)
+	info := newCopyright(2018, []string{"ACME"}, chainHolderFilters())
+	want := make(map[string]string)
+	for rel, contents := range files {
+		header, err := renderHeader(shortApache2Point0Templ, info, et.styleFor(rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[rel] = string(header) + contents
+	}
+	for i, wantStatus := range []string{StatusAdded, StatusConforming} {
+		rep, err := tr.conform(Options{Fix: true, Languages: allLanguages()})
+		if err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+		statuses := tr.statuses(rep)
+		for rel, contents := range want {
+			if got := tr.read(rel); statuses[rel] != wantStatus || got != contents {
+				t.Errorf("run %d: %s: got status %q and\n%s\nwant %q and\n%s", i+1, rel, statuses[rel], got, wantStatus, contents)
+			}
+		}
+		tr.commit("Alice", inYear(2019+i), want)
+	}
+}
+
+func TestXMLDeclaration(t *testing.T) {
+	body := "<config>\n" + strings.Repeat("  <item>The quick brown fox jumps over the lazy dog.</item>\n", 12) + "</config>\n"
+	decl := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"
+	header, err := renderHeader(shortApache2Point0Templ, newCopyright(2018, []string{"ACME"}, chainHolderFilters()), xmlComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<!--\n  Copyright 2018 ACME. All Rights Reserved.\n\n  Licensed under"; !strings.HasPrefix(string(header), want) {
+		t.Fatalf("got header\n%s\nwant it to start with\n%s", header, want)
+	}
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.xml": decl + body})
+	if _, err := tr.conform(Options{Fix: true, Languages: allLanguages()}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := tr.read("a.xml"), decl+"\n"+string(header)+body; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	if _, err := tr.conform(Options{Languages: []string{"go", "cobol"}}); err == nil || !strings.Contains(err.Error(), `unknown language "cobol"`) {
+		t.Errorf("got error %v, want an unknown language", err)
+	}
+	langs := Languages()
+	if len(langs) != len(sourceLanguages) || langs[0].Name != "go" || !reflect.DeepEqual(langs[0].Extensions, []string{".go"}) {
+		t.Errorf("got languages %v", langs)
+	}
+}
+
+func TestCommentStyleFor(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource, "b.go": testSource, "notes.md": "# Notes\n"})
+	block, ok := CommentStyleNamed("C")
+	if !ok {
+		t.Fatal(`got no comment style named "C"`)
+	}
+	if _, ok := CommentStyleNamed("markdown"); ok {
+		t.Error(`got a comment style named "markdown"`)
+	}
+	styleFor := func(path string) *CommentStyle {
+		if filepath.Base(path) == "b.go" || filepath.Ext(path) == ".md" {
+			return block
+		}
+		return nil
+	}
+	if _, err := tr.conform(Options{Fix: true, CommentStyleFor: styleFor}); err != nil {
+		t.Fatal(err)
+	}
+	info := newCopyright(2018, []string{"ACME"}, chainHolderFilters())
+	for rel, style := range map[string]*CommentStyle{"a.go": slashComments, "b.go": blockComments} {
+		header, err := renderHeader(shortApache2Point0Templ, info, style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := tr.read(rel), string(header)+testSource; got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", rel, got, want)
+		}
+	}
+	// The style does not make a file get stamped.
+	if got := tr.read("notes.md"); got != "# Notes\n" {
+		t.Errorf("notes.md: got\n%s\nwant it untouched", got)
+	}
+}
+
+func TestStampTerraform(t *testing.T) {
+	tf := "terraform {\n  required_version = \">= 1.0\"\n}\n\n" + strings.Repeat("resource \"null_resource\" \"a\" {}\n", 20)
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"main.tf": tf, "vars.hcl": tf})
+	if _, err := tr.conform(Options{Fix: true, Languages: []string{"terraform"}}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := renderHeader(shortApache2Point0Templ, newCopyright(2018, []string{"ACME"}, chainHolderFilters()), hashComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"main.tf", "vars.hcl"} {
+		if got, want := tr.read(rel), string(header)+tf; got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", rel, got, want)
+		}
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	const bom = "\xef\xbb\xbf"
+	render := func(year int, style *CommentStyle) string {
+		header, err := renderHeader(shortApache2Point0Templ, newCopyright(year, []string{"Initech"}, chainHolderFilters()), style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(header)
+	}
+	header := render(2018, slashComments)
+	hashHeader := render(2016, hashComments)
+	tests := []struct {
+		name     string
+		path     string
+		contents string
+		want     string
+		opts     Options
+	}{
+		{name: "unstamped", path: "a.go", contents: bom + "package a\n", want: bom + header + "package a\n"},
+		{name: "doc comment", path: "a.go", contents: bom + "// Package a does things.\npackage a\n", want: bom + header + "// Package a does things.\npackage a\n"},
+		{name: "stamped", path: "a.go", contents: bom + header + "package a\n", want: bom + header + "package a\n"},
+		{name: "shebang", path: "a.sh", contents: bom + "#!/bin/sh\necho a\n", want: bom + "#!/bin/sh\n" + render(2018, hashComments) + "echo a\n"},
+		{name: "stamped script", path: "a.sh", contents: bom + hashHeader + "echo a\n", want: bom + hashHeader + "echo a\n"},
+		// Rewriting a script's header keeps its shebang.
+		{name: "rewritten script", path: "a.sh", contents: "#!/bin/sh\n" + hashHeader + "echo a\n", want: "#!/bin/sh\n" + hashHeader + "echo a\n", opts: Options{RewriteAll: true}},
+	}
+	for _, tt := range tests {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		writeFiles(t, dir, map[string]string{tt.path: tt.contents})
+		opts := tt.opts
+		opts.RepoPath, opts.NoGit, opts.Year, opts.Fix = dir, true, 2018, true
+		opts.Holders, opts.Languages = []string{"Initech"}, []string{"go", "shell"}
+		for run := 1; run <= 2; run++ {
+			if _, err := Conform(opts); err != nil {
+				t.Fatal(err)
+			}
+			got := readFile(t, dir, tt.path)
+			if got != tt.want {
+				t.Errorf("%s, run %d: got\n%q\nwant\n%q", tt.name, run, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestTrimHeaderSpace(t *testing.T) {
+	unitTests := []struct {
+		header, want string
+	}{
+		{header: "// a  \n//\t\n\n", want: "// a\n//\n\n"},
+		{header: "// a\n// b", want: "// a\n// b"},
+		{header: "// a \t", want: "// a"},
+		{header: "", want: ""},
+	}
+	for _, tt := range unitTests {
+		if got := string(trimTrailingSpace([]byte(tt.header))); got != tt.want {
+			t.Errorf("trimTrailingSpace(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+
+	tmpl := template.Must(template.New("spaced").Parse("// Copyright {{.Year}} {{.Holder}}.  \n// \n// Licensed under the Apache License, Version 2.0 (the \"License\");\t\n\n"))
+	body := "package a\n\nvar x = 1 \n"
+	tests := []struct {
+		trim bool
+		want string
+	}{
+		{trim: true, want: "// Copyright 2018 Initech.\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n\n" + body},
+		{trim: false, want: "// Copyright 2018 Initech.  \n// \n// Licensed under the Apache License, Version 2.0 (the \"License\");\t\n\n" + body},
+	}
+	for _, tt := range tests {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		writeFiles(t, dir, map[string]string{"a.go": body})
+		if _, err := Conform(Options{RepoPath: dir, NoGit: true, Year: 2018, Fix: true, Holders: []string{"Initech"}, Template: tmpl, TrimHeaderSpace: tt.trim}); err != nil {
+			t.Fatal(err)
+		}
+		// The code below the header is left as it was.
+		if got := readFile(t, dir, "a.go"); got != tt.want {
+			t.Errorf("TrimHeaderSpace=%v: got\n%q\nwant\n%q", tt.trim, got, tt.want)
+		}
+	}
+
+	// Restyled built-in headers carry no trailing whitespace either.
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	files := map[string]string{"a.sh": "echo a\n", "a.sql": "SELECT 1;\n", "a.c": "int a;\n", "a.html": "<p></p>\n"}
+	writeFiles(t, dir, files)
+	opts := Options{RepoPath: dir, NoGit: true, Year: 2018, Fix: true, TrimHeaderSpace: true, Languages: []string{"shell", "sql", "c", "html"}}
+	if _, err := Conform(opts); err != nil {
+		t.Fatal(err)
+	}
+	for path := range files {
+		for i, line := range strings.Split(readFile(t, dir, path), "\n") {
+			if strings.TrimRight(line, " \t") != line {
+				t.Errorf("%s:%d: %q has trailing whitespace", path, i+1, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,849 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conform adds license headers to the source files of a git
+// repo, dating each by the earliest commit that its lines survive from.
+//
+// The apache2conform command is a thin wrapper over Conform.
+package conform
+
+import (
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/odeke-em/semalim"
+)
+
+// The statuses that a file can end up in.
+const (
+	StatusAdded      = "added"
+	StatusConforming = "conforming"
+	StatusMissing    = "missing"
+	StatusSkipped    = "skipped"
+	StatusError      = "error"
+)
+
+// The encodings that headers can be rendered in for files
+// that are not valid UTF-8, see Options.Encoding.
+const (
+	EncodingUTF8   = encodingUTF8
+	EncodingLatin1 = encodingLatin1
+)
+
+// The ways that Options.NoticeLine can treat the line of an
+// Apache header that refers to the NOTICE file.
+const (
+	NoticeLineKeep   = noticeLineKeep
+	NoticeLineAdd    = noticeLineAdd
+	NoticeLineRemove = noticeLineRemove
+)
+
+// Options configures a Conform run. The zero value checks, without
+// changing anything, that every source file of the repo at RepoPath
+// carries an Apache 2.0 header.
+type Options struct {
+	// RepoPath is the root directory of the git repo.
+	RepoPath string
+	// Holders are credited on stacked copyright lines, "ACME" if empty.
+	Holders []string
+	// HolderFromGit if set and Holders is empty credits the user.name
+	// of the repo's git config, or else the first entry of its AUTHORS
+	// or CONTRIBUTORS file, before falling back to "ACME".
+	HolderFromGit bool
+	// HolderFilters are applied in order to every holder before it
+	// is rendered, e.g. SanitizeHolder or CollapseSpaces.
+	HolderFilters []func(string) string
+	// HolderSuffix is a legal suffix such as ", Inc." or ", LLC" that
+	// rendered headers append to Holders as given, and that holders are
+	// compared without. Its period is not doubled by templates such as
+	// Apache 2.0's that end the holder with one of their own.
+	HolderSuffix string
+	// Template is the license header, Apache 2.0 if nil.
+	Template *template.Template
+	// ExtTemplates override Template for files by extension
+	// unless nil.
+	ExtTemplates map[string]*template.Template
+	// TemplateFor if set picks the license header of the file at
+	// path. Files for which it returns nil get ExtTemplates or
+	// Template as usual, so use Exclude to leave files out.
+	TemplateFor func(path string) *template.Template
+	// Concurrency bounds how many files are processed
+	// at once, DefaultConcurrency if 0.
+	Concurrency uint
+
+	// Fix if set adds the missing headers.
+	Fix bool
+	// Check if set only tells which files lack a license, without
+	// blaming them, so missing files are reported with no year. It
+	// cannot be combined with Fix.
+	Check bool
+	// DryRun if set computes changes as if fixing but never writes them.
+	DryRun bool
+	// Patch if set records a unified diff of every change.
+	Patch bool
+	// MinimalDiff if set only shows changed lines in patches.
+	MinimalDiff bool
+	// DiffPrefix is put in front of the repo relative paths that
+	// patches name files by, e.g. to tell several repos apart.
+	DiffPrefix string
+	// FixOnlyIfValid holds every write back until all changed Go
+	// files are known to still parse, changing nothing if any would not.
+	FixOnlyIfValid bool
+	// RenameSafeWrite writes each file to a temporary sibling that is
+	// then renamed over it, after checking that its directory lists it
+	// in the exact case given so that on case-insensitive filesystems
+	// a sibling whose name differs only in case is never replaced.
+	RenameSafeWrite bool
+	// VerifyClean refuses to write while tracked
+	// files have uncommitted changes.
+	VerifyClean bool
+
+	// Languages limits the stamped files to those of the
+	// named languages, see Languages, or to Go if empty.
+	Languages []string
+	// StampExtensions maps extensions of extra files to stamp to the
+	// name of their comment style: slash, dash, hash, c, html or xml.
+	StampExtensions map[string]string
+	// SidecarExtensions are the extensions of files that cannot carry
+	// comments, such as .png, to write REUSE <file>.license sidecars for.
+	SidecarExtensions []string
+	// CommentStyleFor if set picks the comment style of the file at
+	// path, e.g. one of CommentStyleNamed. Files for which it returns
+	// nil get that of their extension. It does not change which files
+	// get stamped, only how their headers are spelled.
+	CommentStyleFor func(path string) *CommentStyle
+
+	// NormalizeWhitespace collapses whitespace when detecting existing
+	// licenses so that reflowed headers still match.
+	NormalizeWhitespace bool
+	// NoRecurse only processes files directly inside RepoPath.
+	NoRecurse bool
+	// SkipHidden does not descend into hidden directories.
+	SkipHidden bool
+	// SkipIgnored passes over the paths that the repo's .gitignore
+	// files, nested ones included, exclude.
+	SkipIgnored bool
+	// SinceTag if set only processes files added or
+	// modified between this git tag and HEAD.
+	SinceTag string
+	// Include if set only selects files whose path relative to RepoPath
+	// matches any of these globs, in which "**" matches any number of
+	// directories and patterns without a slash match base names.
+	Include []string
+	// Exclude passes over files matching any of these globs, even
+	// if they match Include too.
+	Exclude []string
+	// SkipMarkers exempt a file from stamping if
+	// any of them appears in its leading comment.
+	SkipMarkers []string
+
+	// YearFromCreation also consults the commit that added each file.
+	YearFromCreation bool
+	// Year if non-zero dates every added header instead of blame,
+	// which is then not run at all.
+	Year int
+	// NoGit processes RepoPath as a plain directory, such as an
+	// exported tree, dating added headers by Year, by when each file
+	// was created on disk with YearFromFSCreation, or else this year.
+	// The options that need git history cannot be combined with it
+	// and VerifyClean does not apply.
+	NoGit bool
+	// YearFromFSCreation dates files that git has no history
+	// of, such as untracked ones, by their birth time where the
+	// OS reports one and by their modification time elsewhere.
+	// Linux reports one from 4.11 on, on file systems such as
+	// ext4, XFS and Btrfs that record it.
+	YearFromFSCreation bool
+	// SkipMerges ignores lines attributed to merge commits.
+	SkipMerges bool
+	// FlagPlaceholders reports headers naming a placeholder holder as errors.
+	FlagPlaceholders bool
+	// FailOnZeroYear fails the files that no copyright year is found
+	// for, rather than leaving them as missing a license.
+	FailOnZeroYear bool
+	// FlagConflicts warns through Logf about headers with several
+	// licenses, which still count as conforming.
+	FlagConflicts bool
+	// RestampHolder replaces the holder of existing headers
+	// that name someone other than Holders[0].
+	RestampHolder bool
+	// IgnoreCaseHolder makes holders that only differ in case the same.
+	IgnoreCaseHolder bool
+	// RewriteAll replaces every existing header with
+	// a freshly rendered one of the same year.
+	RewriteAll bool
+	// ExtendYearRanges makes the years of existing headers run
+	// up to the current one, e.g. 2017 becomes 2017-2020.
+	ExtendYearRanges bool
+	// UpdateYear rewrites the years of existing headers to the
+	// latest one in blame, e.g. 2015 becomes 2020 for a file
+	// last changed in 2020.
+	UpdateYear bool
+	// NoticeLine is one of NoticeLineKeep, NoticeLineAdd or
+	// NoticeLineRemove. Adding puts a reference to the NOTICE file
+	// below the copyright lines of Apache headers, both new and
+	// existing, and removing takes it out of existing ones.
+	NoticeLine string
+	// UpdateBody replaces the license text of every existing
+	// header, keeping its copyright lines as they are.
+	UpdateBody bool
+	// DedupeBlanks drops the blank lines that a file
+	// started with from below an added header.
+	DedupeBlanks bool
+	// TrimHeaderSpace strips the trailing whitespace that a
+	// template leaves on the lines of the headers it renders.
+	TrimHeaderSpace bool
+	// PerAuthorSpans credits the authors found in blame,
+	// each spanning the years of their commits.
+	PerAuthorSpans bool
+	// PerYearHolders credits the authors found in blame by year.
+	PerYearHolders bool
+	// HolderWrapWidth if non-zero splits the copyright lines that list
+	// several holders, such as those of PerYearHolders, into lines of
+	// the same year that each fit within this many columns.
+	HolderWrapWidth uint
+	// YearRange makes headers span from the earliest to the latest
+	// year in a file's blame, e.g. 2017-2023, or a single year if those
+	// are the same. Custom templates get them as .StartYear and .EndYear.
+	YearRange bool
+	// HolderFromFirstAuthor credits the author of the commit that
+	// added each file instead of Holders, unless the file's directory
+	// names a holder of its own.
+	HolderFromFirstAuthor bool
+	// HolderFromBlame credits the author of the earliest line in each
+	// file's blame instead of Holders, unless the file's directory names
+	// a holder of its own or authors tie for the earliest line.
+	HolderFromBlame bool
+	// Mailmap makes the authors credited by PerAuthorSpans,
+	// PerYearHolders, HolderFromFirstAuthor and HolderFromBlame
+	// go by the names that the repo's .mailmap gives them.
+	Mailmap bool
+	// Transform if set rewrites the rest of a file
+	// below the header that is being added to it.
+	Transform func(path string, body []byte) []byte
+
+	// BlameCacheFile if set remembers each file's earliest year across
+	// runs, files whose contents are unchanged are not blamed again.
+	BlameCacheFile string
+	// OnlyChanged skips files left unchanged since
+	// the last run of BlameCacheFile that had no errors.
+	OnlyChanged bool
+	// BlameTimeout if non-zero skips files whose blame takes longer.
+	BlameTimeout time.Duration
+	// Encoding is what headers are rendered in for files that
+	// are not valid UTF-8, EncodingUTF8 skips such files.
+	Encoding string
+	// SniffBytes if non-zero is how much of each file is read in search
+	// of a license, it must fit the largest header that would be added.
+	SniffBytes uint
+	// MaxErrors if non-zero stops queueing files once this many failed.
+	MaxErrors uint
+	// Metrics if set measures how busy the workers were.
+	Metrics bool
+
+	// OnResult if set is called with the result of every file as
+	// soon as it is known, one at a time.
+	OnResult func(*FileResult)
+	// Logf if set is given problems that do not fail a file.
+	Logf func(format string, args ...interface{})
+}
+
+// FileResult is the outcome of a single file.
+type FileResult struct {
+	// Path is that of the file under Options.RepoPath.
+	Path string
+	// Status is one of the Status* constants.
+	Status string
+	// Year is the first copyright year of the
+	// file's header, or that it would be given.
+	Year int
+	// Added is set if the file was given a header, or
+	// would have been in a dry run.
+	Added bool
+	// Apache is set if the file has or was given an Apache 2.0 header.
+	Apache bool
+	// Diff is the unified diff of the change, set only if Options.Patch is.
+	Diff []byte
+	// Err is why the file could not be processed.
+	Err error
+}
+
+// Report is the outcome of a Conform run.
+type Report struct {
+	// Files are in the order that they finished in.
+	Files []*FileResult
+	// Statuses counts Files by status.
+	Statuses map[string]int
+	// Added, Conforming and Errors count the files that were given a
+	// header, those that needed none and those that failed.
+	Added, Conforming, Errors int
+	// Apache counts the files with Apache 2.0 headers.
+	Apache int
+	// Aborted is set if Options.MaxErrors stopped the run early.
+	Aborted bool
+	// Unparsable are the parse errors that kept an
+	// Options.FixOnlyIfValid run from changing any file.
+	Unparsable []string
+
+	metrics     *workerMetrics
+	concurrency uint
+	elapsed     time.Duration
+}
+
+// UncommittedError is returned by Conform when Options.VerifyClean
+// finds tracked files in the repo with uncommitted changes.
+type UncommittedError struct {
+	RepoPath string
+	Files    []string
+}
+
+func (ue *UncommittedError) Error() string {
+	return fmt.Sprintf("refusing to write: %d tracked files in %q have uncommitted changes e.g. %q",
+		len(ue.Files), ue.RepoPath, ue.Files[0])
+}
+
+// WritePatch writes the diffs of the report ordered by
+// path to a single patch file suitable for `git apply`.
+func (r *Report) WritePatch(patchPath string) error {
+	return writePatch(patchPath, r.diffs())
+}
+
+// WriteDiffs writes the diffs of the report to w ordered by path,
+// so that the output can be piped into `git apply` or `patch -p1`.
+func (r *Report) WriteDiffs(w io.Writer) error {
+	return writeDiffs(w, r.diffs())
+}
+
+func (r *Report) diffs() map[string][]byte {
+	diffs := make(map[string][]byte)
+	for _, fr := range r.Files {
+		if fr.Diff != nil {
+			diffs[fr.Path] = fr.Diff
+		}
+	}
+	return diffs
+}
+
+// Merge adds the files and counts of other, the report of a run over
+// another repo, to r so that runs over several repos sum up as one.
+func (r *Report) Merge(other *Report) {
+	r.Files = append(r.Files, other.Files...)
+	if r.Statuses == nil {
+		r.Statuses = make(map[string]int)
+	}
+	for status, n := range other.Statuses {
+		r.Statuses[status] += n
+	}
+	r.Added += other.Added
+	r.Conforming += other.Conforming
+	r.Errors += other.Errors
+	r.Apache += other.Apache
+	r.Aborted = r.Aborted || other.Aborted
+	r.Unparsable = append(r.Unparsable, other.Unparsable...)
+	if other.metrics != nil {
+		if r.metrics == nil {
+			r.metrics = new(workerMetrics)
+		}
+		r.metrics.busyNanos += other.metrics.busyNanos
+		r.metrics.jobs += other.metrics.jobs
+	}
+	if other.concurrency > r.concurrency {
+		r.concurrency = other.concurrency
+	}
+	r.elapsed += other.elapsed
+}
+
+// WriteMetrics summarizes how busy the workers were, if Options.Metrics
+// was set, returning false without writing anything otherwise.
+func (r *Report) WriteMetrics(w io.Writer) bool {
+	if r.metrics == nil {
+		return false
+	}
+	r.metrics.report(w, r.concurrency, r.elapsed)
+	return true
+}
+
+// ValidateTemplates renders the template picked for each stamped file
+// extension with a sample copyright, returning how many extensions
+// were checked along with a description of every header that would not
+// be a valid comment or that would not be recognized as a license.
+func ValidateTemplates(opts Options) (checked int, problems []string, err error) {
+	et, err := newExtensionTable(&opts)
+	if err != nil {
+		return 0, nil, err
+	}
+	templateFor, err := templateChooser(&opts, et)
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(et.styles), validateTemplates(et, templateFor, licenseDetector(&opts)), nil
+}
+
+// templateChooser returns the func that picks the
+// license template of a path, which is never nil.
+func templateChooser(opts *Options, et *extensionTable) (func(path string) *template.Template, error) {
+	tmpl := opts.Template
+	if tmpl == nil {
+		tmpl = shortApache2Point0Templ
+	}
+	extTemplates := make(map[string]*template.Template)
+	for ext, extTmpl := range opts.ExtTemplates {
+		ext = normalizeExt(ext)
+		if et.styles[ext] == nil {
+			return nil, fmt.Errorf("template map: files with extension %q are not stamped", ext)
+		}
+		if extTmpl != nil {
+			extTemplates[ext] = extTmpl
+		}
+	}
+	return func(path string) *template.Template {
+		if opts.TemplateFor != nil {
+			if pathTmpl := opts.TemplateFor(path); pathTmpl != nil {
+				return pathTmpl
+			}
+		}
+		if extTmpl := extTemplates[filepath.Ext(path)]; extTmpl != nil {
+			return extTmpl
+		}
+		return tmpl
+	}, nil
+}
+
+func licenseDetector(opts *Options) func([]byte) bool {
+	if opts.NormalizeWhitespace {
+		// Collapsing whitespace joins the lines that SPDX
+		// identifiers are anchored to, so look for those in b.
+		return func(b []byte) bool { return containsALicense(collapseWhitespace(b)) || regSPDXHeaderLine.Match(b) }
+	}
+	return containsALicense
+}
+
+// maxDefaultConcurrency caps DefaultConcurrency on machines with many
+// CPUs, past which workers mostly wait on the disk and the object store.
+const maxDefaultConcurrency = 32
+
+// DefaultConcurrency is how many files are processed at once unless
+// told otherwise, one per CPU up to a cap.
+func DefaultConcurrency() uint {
+	n := runtime.NumCPU()
+	if n > maxDefaultConcurrency {
+		n = maxDefaultConcurrency
+	}
+	return uint(n)
+}
+
+// checkYearSources rejects the options that need blame, or any git
+// history at all, when opts.Year or opts.NoGit does without them.
+func checkYearSources(opts *Options) error {
+	needBlame := []struct {
+		set  bool
+		what string
+	}{
+		{opts.PerAuthorSpans, "per author year spans"},
+		{opts.PerYearHolders, "per year holders"},
+		{opts.YearRange, "year ranges"},
+		{opts.HolderFromBlame, "holders from blame"},
+		{opts.UpdateYear, "updating years"},
+	}
+	needHistory := []struct {
+		set  bool
+		what string
+	}{
+		{opts.YearFromCreation, "years from creation"},
+		{opts.HolderFromFirstAuthor, "holders from the first author"},
+		{opts.SinceTag != "", "since tag"},
+	}
+	for _, need := range needBlame {
+		if need.set && opts.NoGit {
+			return fmt.Errorf("%s needs git blame, which no git does without", need.what)
+		}
+		if need.set && opts.Year != 0 {
+			return fmt.Errorf("%s needs git blame and cannot be combined with a fixed year", need.what)
+		}
+	}
+	for _, need := range needHistory {
+		if need.set && opts.NoGit {
+			return fmt.Errorf("%s needs git history, which no git does without", need.what)
+		}
+	}
+	// A fixed year dates every file, leaving nothing to date by
+	// when it was created.
+	if opts.Year != 0 && (opts.YearFromCreation || opts.YearFromFSCreation) {
+		return errors.New("years from creation cannot be combined with a fixed year")
+	}
+	return nil
+}
+
+// Conform checks, and if opts.Fix is set fixes, the license
+// headers of the source files of the repo at opts.RepoPath.
+func Conform(opts Options) (*Report, error) {
+	if opts.Check && opts.Fix {
+		return nil, errors.New("check and fix cannot be combined")
+	}
+	if opts.PerAuthorSpans && opts.PerYearHolders {
+		return nil, errors.New("per author year spans and per year holders cannot be combined")
+	}
+	if opts.OnlyChanged && opts.BlameCacheFile == "" {
+		return nil, errors.New("only changed since last run needs a blame cache file to remember the last run in")
+	}
+	if err := checkYearSources(&opts); err != nil {
+		return nil, err
+	}
+	et, err := newExtensionTable(&opts)
+	if err != nil {
+		return nil, err
+	}
+	templateFor, err := templateChooser(&opts, et)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(opts.NoticeLine) == noticeLineAdd {
+		templateFor = withNoticeLine(templateFor)
+	}
+	encoding, err := parseEncoding(opts.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	noticeLine, err := parseNoticeLine(opts.NoticeLine)
+	if err != nil {
+		return nil, err
+	}
+	sniffSize := approxShortHeaderSize
+	if opts.SniffBytes != 0 {
+		size, ext, err := largestHeader(et, templateFor)
+		if err != nil {
+			return nil, err
+		}
+		if opts.SniffBytes < uint(size) {
+			return nil, fmt.Errorf("sniff size of %d bytes is smaller than the %d byte header of %s files", opts.SniffBytes, size, ext)
+		}
+		sniffSize = int(opts.SniffBytes)
+	}
+	contains := licenseDetector(&opts)
+	if opts.Fix {
+		if problems := validateTemplates(et, templateFor, contains); len(problems) > 0 {
+			return nil, fmt.Errorf("%d template problems found:\n\t%s", len(problems), strings.Join(problems, "\n\t"))
+		}
+	}
+
+	// Cleaning the path makes it spelled like those that
+	// the walk derives from it, with the OS's separators.
+	dirPath := filepath.Clean(opts.RepoPath)
+	var repo *git.Repository
+	if !opts.NoGit {
+		if repo, err = git.PlainOpen(dirPath); err != nil {
+			return nil, err
+		}
+	}
+
+	staged := opts.Fix && !opts.DryRun && opts.FixOnlyIfValid
+	if opts.Fix && !opts.DryRun && opts.VerifyClean && repo != nil {
+		dirty, err := uncommittedFiles(repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get worktree status: %v", err)
+		}
+		if len(dirty) > 0 {
+			return nil, &UncommittedError{RepoPath: dirPath, Files: dirty}
+		}
+	}
+
+	var headCommit *object.Commit
+	if repo != nil {
+		head, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+		// First step here is to find the head hash
+		refHash := head.Hash()
+		// Start sifting through all the files
+		headCommit, err = object.GetCommit(repo.Storer, refHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get headCommit: %v", err)
+		}
+	}
+	fixedYear := opts.Year
+	if fixedYear == 0 && opts.NoGit && !opts.YearFromFSCreation {
+		fixedYear = time.Now().Year()
+	}
+
+	match := et.isSourceFile
+	if opts.SinceTag != "" {
+		changed, err := filesChangedSinceTag(repo, opts.SinceTag, headCommit)
+		if err != nil {
+			return nil, err
+		}
+		match = func(path string, fi os.FileInfo) bool {
+			relPath, err := repoRelPath(dirPath, path)
+			return err == nil && changed[relPath] && et.isSourceFile(path, fi)
+		}
+	}
+
+	// Version control metadata never holds source files of
+	// interest, so it is pruned even if hidden dirs are walked.
+	dirSkippers := []func(string, os.FileInfo) bool{vcsDir}
+	if opts.NoRecurse {
+		dirSkippers = append(dirSkippers, func(string, os.FileInfo) bool { return true })
+	}
+	if opts.SkipHidden {
+		dirSkippers = append(dirSkippers, hiddenDir)
+	}
+	if len(opts.Include) > 0 || len(opts.Exclude) > 0 {
+		filter, err := newPathFilter(opts.Include, opts.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		matchSource := match
+		match = func(path string, fi os.FileInfo) bool {
+			relPath, err := repoRelPath(dirPath, path)
+			return err == nil && filter.selects(relPath) && matchSource(path, fi)
+		}
+	}
+	if opts.SkipIgnored {
+		ignored, err := gitignoredPaths(dirPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .gitignore files: %v", err)
+		}
+		dirSkippers = append(dirSkippers, ignored)
+		matchSource := match
+		match = func(path string, fi os.FileInfo) bool {
+			return matchSource(path, fi) && !ignored(path, fi)
+		}
+	}
+	skipDir := anyDirSkipper(dirSkippers...)
+
+	var skipMarkers [][]byte
+	for _, marker := range opts.SkipMarkers {
+		if marker != "" {
+			skipMarkers = append(skipMarkers, []byte(marker))
+		}
+	}
+
+	copyrightHolders := opts.Holders
+	if len(copyrightHolders) == 0 && opts.HolderFromGit {
+		holder, err := gitHolder(repo, dirPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to infer the copyright holder: %v", err)
+		}
+		if holder != "" {
+			copyrightHolders = []string{holder}
+		}
+	}
+	if len(copyrightHolders) == 0 {
+		copyrightHolders = []string{"ACME"}
+	}
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = DefaultConcurrency()
+	}
+	logf := opts.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	holders := &holderResolver{root: dirPath}
+	var metrics *workerMetrics
+	if opts.Metrics {
+		metrics = new(workerMetrics)
+	}
+
+	var cache *blameCache
+	if opts.BlameCacheFile != "" {
+		if cache, err = openBlameCache(opts.BlameCacheFile, opts.SkipMerges, opts.YearFromCreation); err != nil {
+			return nil, fmt.Errorf("blame cache: %v", err)
+		}
+	}
+
+	var mm *mailmap
+	if opts.Mailmap {
+		if mm, err = readMailmap(dirPath); err != nil {
+			return nil, fmt.Errorf("failed to read .mailmap: %v", err)
+		}
+	}
+
+	// stop is closed once MaxErrors is hit so that no more jobs
+	// are queued, the ones in flight still run to completion.
+	stop := make(chan bool)
+	jobsChan := make(chan semalim.Job)
+	go func() {
+		defer close(jobsChan)
+		goFiles := siftThroughFiles(dirPath, match, skipDir, stop)
+		for goFile := range goFiles {
+			var job semalim.Job = &licenseConformer{
+				dirPath:       dirPath,
+				holders:       copyrightHolders,
+				holderConf:    holders,
+				normHolder:    chainHolderFilters(opts.HolderFilters...),
+				fixIt:         opts.Fix,
+				dryRun:        opts.DryRun,
+				staged:        staged,
+				patch:         opts.Patch,
+				minimalDiff:   opts.MinimalDiff,
+				diffPrefix:    opts.DiffPrefix,
+				filePath:      goFile,
+				headCommit:    headCommit,
+				fixedYear:     fixedYear,
+				repo:          repo,
+				creation:      opts.YearFromCreation,
+				fsCreation:    opts.YearFromFSCreation,
+				skipMerges:    opts.SkipMerges,
+				templateFor:   templateFor,
+				contains:      contains,
+				skipMarkers:   skipMarkers,
+				placeholder:   opts.FlagPlaceholders,
+				failZeroYear:  opts.FailOnZeroYear,
+				flagConflicts: opts.FlagConflicts,
+				restampHolder: opts.RestampHolder,
+				ignoreCase:    opts.IgnoreCaseHolder,
+				rewriteAll:    opts.RewriteAll,
+				updateBody:    opts.UpdateBody,
+				extendYears:   opts.ExtendYearRanges,
+				updateYear:    opts.UpdateYear,
+				noticeLine:    noticeLine,
+				check:         opts.Check,
+				renameSafe:    opts.RenameSafeWrite,
+				entitySuffix:  opts.HolderSuffix,
+				transform:     opts.Transform,
+				dedupeBlanks:  opts.DedupeBlanks,
+				trimSpace:     opts.TrimHeaderSpace,
+				perAuthor:     opts.PerAuthorSpans,
+				perYear:       opts.PerYearHolders,
+				wrapWidth:     int(opts.HolderWrapWidth),
+				firstAuthor:   opts.HolderFromFirstAuthor,
+				blameAuthor:   opts.HolderFromBlame,
+				mailmap:       mm,
+				yearRange:     opts.YearRange,
+				blameCache:    cache,
+				onlyChanged:   opts.OnlyChanged,
+				blameTimeout:  opts.BlameTimeout,
+				encoding:      encoding,
+				sniffSize:     sniffSize,
+				exts:          et,
+				logf:          logf,
+			}
+			if metrics != nil {
+				job = metrics.wrap(job)
+			}
+			select {
+			case jobsChan <- job:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	runStart := time.Now()
+	rep := &Report{Statuses: make(map[string]int), metrics: metrics, concurrency: concurrency}
+	var pending []*stagedWrite
+	for res := range semalim.Run(jobsChan, uint64(concurrency)) {
+		cr, _ := res.Value().(*conformResult)
+		fr := newFileResult(res.Id().(string), cr, res.Err())
+		rep.Files = append(rep.Files, fr)
+		rep.Statuses[fr.Status] += 1
+		if fr.Apache {
+			rep.Apache += 1
+		}
+		if fr.Added {
+			rep.Added += 1
+			if cr.staged != nil {
+				pending = append(pending, cr.staged)
+			}
+		} else if fr.Err != nil {
+			rep.Errors += 1
+			if rep.Errors == int(opts.MaxErrors) {
+				rep.Aborted = true
+				close(stop)
+			}
+		} else if fr.Status == StatusConforming {
+			rep.Conforming += 1
+		}
+		if opts.OnResult != nil {
+			opts.OnResult(fr)
+		}
+	}
+	rep.elapsed = time.Since(runStart)
+
+	var writeErr error
+	if staged {
+		if rep.Unparsable = unparsableGoFiles(pending); len(rep.Unparsable) == 0 {
+			writeErr = writeStaged(dirPath, pending, cache, &opts)
+		}
+	}
+	// The cache is saved last so that files only count as seen
+	// once what was staged for them has been written.
+	if err := cache.save(rep.Errors == 0 && len(rep.Unparsable) == 0 && writeErr == nil); err != nil {
+		logf("failed to save blame cache: %v", err)
+	}
+	return rep, writeErr
+}
+
+// writeStaged writes the files held back by Options.FixOnlyIfValid,
+// marking each as seen for Options.OnlyChanged once it is written.
+func writeStaged(dirPath string, pending []*stagedWrite, cache *blameCache, opts *Options) error {
+	for _, sw := range pending {
+		if err := writeFile(sw.path, sw.contents, opts.RenameSafeWrite); err != nil {
+			return fmt.Errorf("failed to write %q: %v", sw.path, err)
+		}
+		if !opts.OnlyChanged {
+			continue
+		}
+		if relPath, err := repoRelPath(dirPath, sw.path); err == nil {
+			cache.markSeen(relPath, contentHash(sw.contents))
+		}
+	}
+	return nil
+}
+
+func newFileResult(path string, cr *conformResult, err error) *FileResult {
+	fr := &FileResult{Path: path, Err: err}
+	switch {
+	case err != nil:
+		fr.Status = StatusError
+	case cr != nil:
+		fr.Status, fr.Year = cr.status, cr.year
+	default:
+		fr.Status = StatusSkipped
+	}
+	if cr != nil {
+		fr.Added, fr.Apache, fr.Diff = cr.added, cr.apache, cr.diff
+	}
+	return fr
+}
+
+// unparsableGoFiles returns the parse errors of
+// the Go files among pending, as they would be written.
+func unparsableGoFiles(pending []*stagedWrite) []string {
+	var problems []string
+	fset := token.NewFileSet()
+	for _, sw := range pending {
+		if filepath.Ext(sw.path) != ".go" {
+			continue
+		}
+		if _, err := parser.ParseFile(fset, sw.path, sw.contents, parser.ParseComments); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
@@ -0,0 +1,373 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// tempDir returns a new empty directory and the func that removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "apache2conform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeFiles writes files, keyed by their slash separated
+// paths relative to dir, creating directories as needed.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for relPath, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, dir, relPath string) string {
+	blob, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(relPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(blob)
+}
+
+// testRepo is a temporary git repo that tests commit their fixtures to.
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+func newTestRepo(t *testing.T) (*testRepo, func()) {
+	dir, cleanup := tempDir(t)
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, dir: dir, repo: repo}, cleanup
+}
+
+// commit writes files and commits them as author at when.
+func (tr *testRepo) commit(author string, when time.Time, files map[string]string) plumbing.Hash {
+	return tr.merge(author, when, files)
+}
+
+// merge is commit with the given parents instead of HEAD.
+func (tr *testRepo) merge(author string, when time.Time, files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+	writeFiles(tr.t, tr.dir, files)
+	wt, err := tr.repo.Worktree()
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	for relPath := range files {
+		if _, err := wt.Add(relPath); err != nil {
+			tr.t.Fatal(err)
+		}
+	}
+	sig := &object.Signature{Name: author, Email: strings.ToLower(strings.Replace(author, " ", ".", -1)) + "@example.com", When: when}
+	hash, err := wt.Commit("Update "+author, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents})
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return hash
+}
+
+func (tr *testRepo) read(relPath string) string { return readFile(tr.t, tr.dir, relPath) }
+
+func (tr *testRepo) head() *object.Commit {
+	ref, err := tr.repo.Head()
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	commit, err := tr.repo.CommitObject(ref.Hash())
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return commit
+}
+
+// conform runs Conform over the repo with opts.
+func (tr *testRepo) conform(opts Options) (*Report, error) {
+	opts.RepoPath = tr.dir
+	return Conform(opts)
+}
+
+// statuses maps the paths of the files of rep, relative
+// to the repo and slash separated, to their statuses.
+func (tr *testRepo) statuses(rep *Report) map[string]string {
+	statuses := make(map[string]string)
+	for _, fr := range rep.Files {
+		relPath, err := filepath.Rel(tr.dir, fr.Path)
+		if err != nil {
+			tr.t.Fatal(err)
+		}
+		statuses[filepath.ToSlash(relPath)] = fr.Status
+	}
+	return statuses
+}
+
+// inYear is a time during year.
+func inYear(year int) time.Time { return time.Date(year, time.June, 1, 12, 0, 0, 0, time.UTC) }
+
+// testSource is the source of an unlicensed Go file.
+const testSource = `package a
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Greeter greets people by name.
+type Greeter struct {
+	// Greeting is said before each name, "Hello" if empty.
+	Greeting string
+	// Shout makes the whole greeting upper case.
+	Shout bool
+}
+
+// Greet returns the greeting for name.
+func (g *Greeter) Greet(name string) string {
+	greeting := g.Greeting
+	if greeting == "" {
+		greeting = "Hello"
+	}
+	msg := fmt.Sprintf("%s, %s!", greeting, name)
+	if g.Shout {
+		msg = strings.ToUpper(msg)
+	}
+	return msg
+}
+
+// GreetAll greets every one of names on a line of its own.
+func (g *Greeter) GreetAll(names ...string) string {
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, g.Greet(name))
+	}
+	return strings.Join(lines, "\n")
+}
+`
+
+// renderTestHeader renders tmpl for year and holder.
+func renderTestHeader(t *testing.T, tmpl *template.Template, year int, holder string) string {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, newCopyright(year, []string{holder}, chainHolderFilters())); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// allLanguages names every language so that tests can opt into them all.
+func allLanguages() []string {
+	var names []string
+	for _, lang := range Languages() {
+		names = append(names, lang.Name)
+	}
+	return names
+}
+
+// testExtensions is the extension table of a run stamping every language.
+func testExtensions(t *testing.T) *extensionTable {
+	et, err := newExtensionTable(&Options{Languages: allLanguages()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return et
+}
+
+func TestConform(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	licensed := renderTestHeader(t, shortApache2Point0Templ, 2014, "ACME") + testSource
+	tr.commit("Alice", inYear(2016), map[string]string{"a.go": licensed, "sub/b.go": testSource, "gen.go": "// DO NOT EDIT!\n\n" + testSource})
+
+	var seen []string
+	opts := Options{OnResult: func(fr *FileResult) { seen = append(seen, fr.Path) }}
+	rep, err := tr.conform(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a.go": StatusConforming, "sub/b.go": StatusMissing, "gen.go": StatusSkipped}; !reflect.DeepEqual(tr.statuses(rep), want) {
+		t.Errorf("got statuses %v, want %v", tr.statuses(rep), want)
+	}
+	// Only the file that needs no header counts as conforming.
+	if rep.Conforming != 1 || rep.Statuses[StatusConforming] != 1 || rep.Statuses[StatusMissing] != 1 || rep.Added != 0 || rep.Errors != 0 || rep.Apache != 1 {
+		t.Errorf("got report %+v", rep)
+	}
+	if len(seen) != len(rep.Files) {
+		t.Errorf("got OnResult called for %q, want every one of %d files", seen, len(rep.Files))
+	}
+	if got := tr.read("sub/b.go"); got != testSource {
+		t.Errorf("got\n%s\nwant it untouched without Fix", got)
+	}
+
+	writeFiles(t, tr.dir, map[string]string{"a.go": licensed + "\nvar y = 2\n"})
+	_, err = tr.conform(Options{Fix: true, VerifyClean: true})
+	if ue, ok := err.(*UncommittedError); !ok || !reflect.DeepEqual(ue.Files, []string{"a.go"}) {
+		t.Fatalf("got error %v, want the uncommitted a.go reported", err)
+	}
+	if got := tr.read("sub/b.go"); got != testSource {
+		t.Errorf("got\n%s\nwant it untouched in a dirty repo", got)
+	}
+
+	rep, err = tr.conform(Options{Fix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Added != 1 || rep.Conforming != 1 || rep.Statuses[StatusAdded] != 1 || rep.Apache != 2 {
+		t.Errorf("got report %+v", rep)
+	}
+	if got, want := tr.read("sub/b.go"), renderTestHeader(t, shortApache2Point0Templ, 2016, "ACME")+testSource; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNoGit(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	writeFiles(t, dir, map[string]string{
+		"a.go":       testSource,
+		"build/b.go": testSource,
+		".gitignore": "build/\n",
+		"AUTHORS":    "Alice Anderson <alice@example.com>\n",
+	})
+	rep, err := Conform(Options{RepoPath: dir, NoGit: true, Fix: true, HolderFromGit: true, SkipIgnored: true, VerifyClean: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Added != 1 {
+		t.Errorf("got %d added, want 1", rep.Added)
+	}
+	if got, want := readFile(t, dir, "a.go"), renderTestHeader(t, shortApache2Point0Templ, time.Now().Year(), "Alice Anderson")+testSource; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := readFile(t, dir, "build/b.go"); got != testSource {
+		t.Errorf("stamped the ignored build/b.go:\n%s", got)
+	}
+
+	for _, opts := range []Options{{PerYearHolders: true}, {YearRange: true}, {SinceTag: "v1"}, {HolderFromFirstAuthor: true}} {
+		opts.RepoPath, opts.NoGit = dir, true
+		if _, err := Conform(opts); err == nil || !strings.Contains(err.Error(), "which no git does without") {
+			t.Errorf("%+v: got error %v", opts, err)
+		}
+	}
+}
+
+func TestOptionsTemplateFor(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	writeFiles(t, dir, map[string]string{
+		"a.go":         "package a\n",
+		"mit/b.go":     "package mit\n",
+		"bsd/c.go":     "package bsd\n",
+		"bsd/c.sh":     "echo c\n",
+		"bsd/gpl/d.sh": "echo d\n",
+		"e.py":         "print(1)\n",
+	})
+	opts := Options{
+		RepoPath: dir, NoGit: true, Year: 2018, Fix: true, Languages: []string{"go", "shell", "python"},
+		ExtTemplates: map[string]*template.Template{".sh": shortGPL3Templ, ".py": nil},
+		TemplateFor: func(path string) *template.Template {
+			switch filepath.Base(filepath.Dir(path)) {
+			case "mit":
+				return shortMITTempl
+			case "bsd":
+				return shortBSDTempl
+			}
+			// Defer to ExtTemplates and Template.
+			return nil
+		},
+	}
+	if _, err := Conform(opts); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		license string
+	}{
+		{path: "a.go", license: "Apache-2.0"},
+		{path: "mit/b.go", license: "MIT"},
+		{path: "bsd/c.go", license: "BSD"},
+		// TemplateFor takes precedence over ExtTemplates.
+		{path: "bsd/c.sh", license: "BSD"},
+		{path: "bsd/gpl/d.sh", license: "GPL"},
+		// Nil templates are no templates, rather than a way to skip files.
+		{path: "e.py", license: "Apache-2.0"},
+	}
+	for _, tt := range tests {
+		got := detectLicenses([]byte(readFile(t, dir, tt.path)))
+		if want := []string{tt.license}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got licenses %q, want %q", tt.path, got, want)
+		}
+	}
+}
+
+func TestConformClosesFiles(t *testing.T) {
+	fds := func() int {
+		entries, err := ioutil.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skipf("cannot count open files: %v", err)
+		}
+		return len(entries)
+	}
+	// Skip before making anything where files cannot be counted.
+	fds()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	truncated := "// Copyright 2016 ACME\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n\n" + testSource
+	files := map[string]string{"skip.go": "// DO NOT LICENSE\n\n" + testSource}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("a%d.go", i)] = truncated
+		files[fmt.Sprintf("b%d.go", i)] = testSource
+	}
+	writeFiles(t, dir, files)
+	// Rendering fails only for the year of the files, so only
+	// once they are open and not while validating the template.
+	broken := template.Must(template.New("broken").Parse(shortApache2Point0 + "{{if eq .Year 2018}}{{.NoSuchField}}{{end}}"))
+	before := fds()
+	for _, opts := range []Options{
+		{RepoPath: dir, NoGit: true, Year: 2018, Check: true},
+		{RepoPath: dir, NoGit: true, Year: 2018, DryRun: true, SkipMarkers: []string{"DO NOT LICENSE"}},
+		{RepoPath: dir, NoGit: true, Year: 2018, Fix: true, Template: broken},
+	} {
+		// The broken template fails the files, not the run.
+		if _, err := Conform(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := fds(); after > before {
+		t.Errorf("got %d files open after conforming, want at most the %d before", after, before)
+	}
+}
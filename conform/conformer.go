@@ -0,0 +1,1404 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/odeke-em/semalim"
+)
+
+var blankTime time.Time
+
+// hasDate reports whether t is a real commit time. Git reads
+// commits that carry no usable date as made at the Unix epoch.
+func hasDate(t time.Time) bool { return t.Unix() > 0 }
+
+// errNoYear is what files fail with under Options.FailOnZeroYear
+// when neither blame nor its fallbacks come up with a year.
+var errNoYear = errors.New("no copyright year could be determined, the header would carry a placeholder one")
+
+type licenseConformer struct {
+	holders    []string
+	holderConf *holderResolver
+	normHolder func(string) string
+	dirPath    string
+	filePath   string
+	fixIt      bool
+	dryRun     bool
+	patch      bool
+	// staged if set holds writes back in the result instead.
+	staged bool
+	// minimalDiff if set only shows changed lines in patches.
+	minimalDiff bool
+	// diffPrefix goes in front of the paths in patches.
+	diffPrefix string
+	headCommit *object.Commit
+	repo       *git.Repository
+	// fixedYear if non-zero dates files instead of blame,
+	// which is all there is when repo is nil.
+	fixedYear int
+	// creation if set makes the earliest year also
+	// account for lines that no longer survive in blame.
+	creation bool
+	// fsCreation if set dates files that are not in the head commit
+	// by when the file system says they were created.
+	fsCreation bool
+	// skipMerges makes merge commits not count towards the year.
+	skipMerges bool
+	// templateFor picks the license template for a path, see
+	// templateChooser, and never returns nil.
+	templateFor func(path string) *template.Template
+	contains    func([]byte) bool
+	// skipMarkers exempt a file from stamping if
+	// any of them appears in its leading comment.
+	skipMarkers [][]byte
+	// failZeroYear if set fails files that no year is found for
+	// instead of leaving them without a header.
+	failZeroYear bool
+	// placeholder if set reports already licensed files
+	// whose holder was never customized as errors.
+	placeholder bool
+	// flagConflicts warns about headers with several licenses.
+	flagConflicts bool
+	// restampHolder if set replaces the holder of existing
+	// headers that name someone other than the configured one.
+	restampHolder bool
+	// ignoreCase makes holders that only differ in case the same.
+	ignoreCase bool
+	// rewriteAll if set replaces every existing header
+	// with a freshly rendered one of the same year.
+	rewriteAll bool
+	// entitySuffix if set is appended to the configured holders
+	// when rendering, such as ", Inc", but not when comparing them.
+	entitySuffix string
+	// renameSafe if set writes files through writeFileAtomically.
+	renameSafe bool
+	// check if set only tells whether files carry a license,
+	// without blaming them for the year they would be given.
+	check bool
+	// extendYears if set makes the years of existing
+	// headers run up to the current one.
+	extendYears bool
+	// updateYear if set rewrites the years of existing headers
+	// to the latest one that blame finds in the file.
+	updateYear bool
+	// noticeLine says whether existing Apache headers get
+	// their reference to the NOTICE file added or removed.
+	noticeLine string
+	// updateBody if set replaces the license text of every
+	// existing header, keeping its copyright lines as they are.
+	updateBody bool
+	// transform if set rewrites the rest of a file
+	// below the header that is being added to it.
+	transform func(path string, body []byte) []byte
+	// dedupeBlanks drops the blank lines that a file started
+	// with, the header already ends in one of its own.
+	dedupeBlanks bool
+	// trimSpace if set strips the trailing whitespace
+	// of each line of a rendered header.
+	trimSpace bool
+	// perAuthor if set credits the authors found in
+	// blame rather than the configured holders.
+	perAuthor bool
+	// perYear if set credits the authors found in blame by year.
+	perYear bool
+	// wrapWidth if non-zero is how wide the copyright lines listing
+	// several holders may get before they are split in two.
+	wrapWidth int
+	// yearRange if set makes headers span from the earliest to
+	// the latest year that the lines of a file were committed in.
+	yearRange bool
+	// firstAuthor if set credits the author of the commit that added
+	// a file, unless its directory names a holder of its own.
+	firstAuthor bool
+	// blameAuthor if set credits the author of the earliest line
+	// in blame, unless its directory names a holder of its own.
+	blameAuthor bool
+	// mailmap if set gives the canonical names of the
+	// authors credited from blame or the file's creation.
+	mailmap *mailmap
+	// blameCache if set is consulted for the year before blaming.
+	blameCache *blameCache
+	// onlyChanged skips files that blameCache says
+	// are as the last successful run left them.
+	onlyChanged bool
+	// blameTimeout if non-zero bounds how long blaming a file may take.
+	blameTimeout time.Duration
+	// sniffSize is how many bytes are first read in search of a license.
+	sniffSize int
+	// encoding is what headers are rendered in for files
+	// that are not UTF-8, see parseEncoding.
+	encoding string
+	// exts tells the stamped files and comment styles apart.
+	exts *extensionTable
+	// logf reports problems that do not fail the file.
+	logf func(format string, args ...interface{})
+}
+
+var _ semalim.Job = (*licenseConformer)(nil)
+
+// conformResult is the value that licenseConformer.Do produces.
+type conformResult struct {
+	added bool
+	// status is one of the status* constants.
+	status string
+	// year is the first copyright year of the
+	// file's header, or that it would be given.
+	year int
+	// apache is set if the file has or was given an Apache 2.0 header.
+	apache bool
+	// diff is the unified diff of the change, set only in patch mode.
+	diff []byte
+	// staged is the write that was held back, set only if staged.
+	staged *stagedWrite
+}
+
+// stagedWrite is a change to a file that is only
+// made once every change of the run checks out.
+type stagedWrite struct {
+	path     string
+	contents []byte
+}
+
+func (lc *licenseConformer) Id() interface{} { return lc.filePath }
+
+func (lc *licenseConformer) Do() (res interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := make([]byte, 1024*4)
+			runtime.Stack(stack, true)
+			err = fmt.Errorf("%s", stack)
+		}
+	}()
+	goFile := lc.filePath
+	fixIt := lc.fixIt
+	copyrightHolders := lc.holders
+	holder, hasDirHolder := lc.holderConf.holderFor(goFile)
+	if hasDirHolder {
+		copyrightHolders = []string{holder}
+	}
+	dirPath := lc.dirPath
+
+	if lc.onlyChanged {
+		relPath, err := repoRelPath(dirPath, goFile)
+		if err != nil {
+			return nil, err
+		}
+		blob, err := ioutil.ReadFile(goFile)
+		if err != nil {
+			return nil, err
+		}
+		if lc.blameCache.unchangedSinceLastRun(relPath, contentHash(blob)) {
+			lc.blameCache.markSeen(relPath, contentHash(blob))
+			return &conformResult{status: StatusSkipped}, nil
+		}
+		defer func() {
+			if cr, _ := res.(*conformResult); err != nil || cr == nil || cr.status == StatusMissing ||
+				cr.status == StatusSkipped || (cr.added && lc.dryRun) || cr.staged != nil {
+				// Still to be fixed, so look at it again next time.
+				// Staged files are marked once they are written.
+				return
+			}
+			if blob, rerr := ioutil.ReadFile(goFile); rerr == nil {
+				lc.blameCache.markSeen(relPath, contentHash(blob))
+			}
+		}()
+	}
+
+	if lc.exts.sidecars[filepath.Ext(goFile)] {
+		return lc.stampSidecar(goFile, copyrightHolders)
+	}
+
+	style := lc.exts.styleFor(goFile)
+	sniff, f, potentiallyConformsToLicense, err := sniffIfHasLicense(goFile, lc.sniffSize, style, lc.contains)
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+	// f is swapped for the rest of a truncated header below, so
+	// close whichever one it is by the time Do returns.
+	defer func() { f.Close() }()
+	optedOut := containsAny(leadingComment(sniff, style), lc.skipMarkers)
+
+	if potentiallyConformsToLicense || autoGenerated(sniff) {
+		// Headers of opted out files stay as they are.
+		rewrite := (fixIt || lc.dryRun) && !autoGenerated(sniff) && !optedOut
+		// Only the header names the holder, not copyright
+		// lines further down such as in vendored snippets.
+		holder, hasHolder := existingHolder(leadingComment(sniff, style))
+		if hasHolder && lc.placeholder && isPlaceholderHolder(holder) {
+			return nil, fmt.Errorf("placeholder copyright holder %q", holder)
+		}
+		if want := copyrightHolders[0]; hasHolder && lc.restampHolder && rewrite && !lc.sameHolder(holder, want) {
+			return lc.replaceHolder(goFile, sniff, f, want)
+		}
+		if lc.extendYears && potentiallyConformsToLicense && rewrite {
+			return lc.extendYearRanges(goFile, sniff, f, time.Now().Year())
+		}
+		if lc.updateYear && potentiallyConformsToLicense && rewrite {
+			return lc.refreshYears(goFile, sniff, f)
+		}
+		if (lc.rewriteAll || lc.updateBody) && potentiallyConformsToLicense && rewrite {
+			return lc.rewriteHeader(goFile, sniff, f, copyrightHolders, lc.updateBody)
+		}
+		if comment, tmpl := leadingComment(sniff, style), lc.templateFor(goFile); potentiallyConformsToLicense &&
+			!autoGenerated(sniff) && !optedOut && truncatedHeader(comment, tmpl, style) {
+			// A header that runs to the end of the sniff may only
+			// be cut off by it, so read the rest before deciding.
+			if len(comment) == len(sniff) {
+				rest, err := ioutil.ReadAll(f)
+				f.Close()
+				if err != nil {
+					return nil, err
+				}
+				sniff, f = append(sniff, rest...), ioutil.NopCloser(bytes.NewReader(nil))
+			}
+			if truncatedHeader(leadingComment(sniff, style), tmpl, style) {
+				if !fixIt && !lc.dryRun {
+					return &conformResult{status: StatusMissing, year: headerYear(sniff), apache: isApacheHeader(sniff)}, nil
+				}
+				return lc.rewriteHeader(goFile, sniff, f, copyrightHolders, true)
+			}
+		}
+		if lc.noticeLine != noticeLineKeep && potentiallyConformsToLicense && rewrite && isApacheHeader(sniff) {
+			return lc.normalizeNoticeLine(goFile, sniff, f)
+		}
+		if licenses := detectLicenses(leadingComment(sniff, style)); lc.flagConflicts && len(licenses) > 1 {
+			relPath, _ := repoRelPath(dirPath, goFile)
+			lc.logf("warning: %q: conflicting licenses in header: %s", relPath, strings.Join(licenses, ", "))
+		}
+		// Well good, move onto the next one
+		res := &conformResult{status: StatusConforming, year: noticeYear(sniff), apache: isApacheHeader(sniff)}
+		if !potentiallyConformsToLicense {
+			res.status = StatusSkipped
+		}
+		return res, nil
+	}
+	if sidecar, ok := licenseSidecar(goFile, spdxIdentifiers[lc.templateFor(goFile)]); ok {
+		return &conformResult{status: StatusConforming, year: sidecarYear(sidecar)}, nil
+	}
+	if optedOut {
+		return &conformResult{status: StatusSkipped}, nil
+	}
+	if lc.check {
+		return &conformResult{status: StatusMissing}, nil
+	}
+
+	relToRootPath, err := repoRelPath(dirPath, goFile)
+	if err != nil {
+		return nil, err
+	}
+	// Files whose year is cached can skip blame, unless their
+	// authors are to be credited or their latest year is needed.
+	var hash string
+	var earliestTime time.Time
+	if lc.blameCache != nil && lc.fixedYear == 0 && !lc.perAuthor && !lc.perYear && !lc.yearRange && !lc.blameAuthor {
+		blob, err := ioutil.ReadFile(goFile)
+		if err != nil {
+			return nil, err
+		}
+		hash = contentHash(blob)
+	}
+	var blameLines []*git.Line
+	if lc.fixedYear != 0 {
+		earliestTime = time.Date(lc.fixedYear, time.January, 1, 0, 0, 0, 0, time.UTC)
+	} else if year, ok := lc.blameCache.lookup(relToRootPath, hash); ok {
+		earliestTime = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	} else {
+		earliestTime, blameLines, err = lc.earliestCommitTimeWithin(relToRootPath)
+		if err == context.DeadlineExceeded {
+			lc.logf("skipping %q: blame took longer than %v", relToRootPath, lc.blameTimeout)
+			return &conformResult{status: StatusSkipped}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hash != "" && earliestTime.After(blankTime) {
+			lc.blameCache.store(relToRootPath, hash, earliestTime.Year())
+		}
+	}
+	canEdit := (fixIt || lc.dryRun) && earliestTime.After(blankTime)
+	if !canEdit && (fixIt || lc.dryRun) && lc.failZeroYear {
+		return nil, errNoYear
+	}
+	if !canEdit {
+		return &conformResult{status: StatusMissing, year: earliestTime.Year()}, nil
+	}
+	tmpl := lc.templateFor(goFile)
+	if lc.firstAuthor && !hasDirHolder {
+		created, err := fileCreationCommit(lc.repo, lc.headCommit.Hash, relToRootPath, lc.skipMerges)
+		if err != nil {
+			return nil, err
+		}
+		if created != nil {
+			if name := lc.mailmap.name(created.Author.Name, created.Author.Email); name != "" {
+				copyrightHolders = []string{name}
+			}
+		}
+	}
+	if lc.blameAuthor && !hasDirHolder {
+		merges := make(map[plumbing.Hash]bool)
+		isMerge := func(hash plumbing.Hash) bool { return lc.skipMerges && isMergeCommit(lc.repo, hash, merges) }
+		author, err := earliestBlameAuthor(lc.repo, blameLines, isMerge, lc.mailmap)
+		if err != nil {
+			return nil, err
+		}
+		if author != "" {
+			copyrightHolders = []string{author}
+		}
+	}
+	info := newCopyright(earliestTime.Year(), copyrightHolders, lc.renderHolder)
+	if lc.perAuthor || lc.perYear || lc.yearRange {
+		merges := make(map[plumbing.Hash]bool)
+		isMerge := func(hash plumbing.Hash) bool { return lc.skipMerges && isMergeCommit(lc.repo, hash, merges) }
+		credits, err := blameCredits(lc.repo, blameLines, isMerge, lc.mailmap)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(credits) == 0:
+		case lc.perAuthor:
+			info = newAuthorCopyright(authorSpans(credits), lc.normHolder)
+		case lc.perYear:
+			info = newYearCopyright(authorsByYear(credits), lc.normHolder)
+		default:
+			info.extendTo(latestYear(credits))
+		}
+	}
+	if lc.wrapWidth > 0 {
+		if info, err = wrapCopyrightLines(info, tmpl, style, lc.wrapWidth); err != nil {
+			return nil, err
+		}
+	}
+	header, err := renderHeader(tmpl, info, style)
+	if err != nil {
+		return nil, err
+	}
+	header = lc.collapseSuffixPeriod(header)
+	if lc.trimSpace {
+		header = trimTrailingSpace(header)
+	}
+	original, err := ioutil.ReadAll(io.MultiReader(bytes.NewReader(sniff), f))
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(original) {
+		if header, err = encodeHeader(header, lc.encoding); err == errNotUTF8 {
+			return lc.skipNotUTF8(goFile), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Next step is to concatenate the (preamble, license, rest)
+	pre := bomLen(original)
+	pre += shebangLen(original[pre:])
+	pre += style.preambleLen(original[pre:])
+	body := lc.transformBody(goFile, original[pre:])
+	if lc.dedupeBlanks {
+		body = trimLeadingBlankLines(body)
+	}
+	original = append(original[:pre:pre], body...)
+	return lc.save(goFile, insertHeader(original, header, style))
+}
+
+// earliestCommitTimeWithin is earliestCommitTime bounded by
+// lc.blameTimeout, past which it gives up with the context's error.
+// Blame cannot be interrupted, so the goroutine running it outlives
+// the timeout until blame returns and its result is dropped. A repo
+// where many files time out has that many blames still running.
+func (lc *licenseConformer) earliestCommitTimeWithin(relPath string) (time.Time, []*git.Line, error) {
+	if lc.blameTimeout <= 0 {
+		return lc.earliestCommitTime(relPath)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lc.blameTimeout)
+	defer cancel()
+
+	type blamed struct {
+		earliest time.Time
+		lines    []*git.Line
+		err      error
+	}
+	done := make(chan *blamed, 1)
+	go func() {
+		earliest, lines, err := lc.earliestCommitTime(relPath)
+		done <- &blamed{earliest: earliest, lines: lines, err: err}
+	}()
+	select {
+	case b := <-done:
+		return b.earliest, b.lines, b.err
+	case <-ctx.Done():
+		return blankTime, nil, ctx.Err()
+	}
+}
+
+// earliestCommitTime runs blame on relPath, returning the earliest
+// time among the commits that its lines and, if lc.creation is set, the
+// file itself were added in, together with the lines of the blame. With
+// lc.fsCreation, files that git has no history of get fsCreationTime.
+func (lc *licenseConformer) earliestCommitTime(relPath string) (time.Time, []*git.Line, error) {
+	if lc.fsCreation {
+		// Without git, no file has any history.
+		untracked := lc.headCommit == nil
+		if !untracked {
+			_, err := lc.headCommit.File(relPath)
+			untracked = err == object.ErrFileNotFound
+		}
+		if untracked {
+			created, err := fsCreationTime(filepath.Join(lc.dirPath, filepath.FromSlash(relPath)))
+			return created, nil, err
+		}
+	}
+	blame, err := git.Blame(lc.headCommit, relPath)
+	if err != nil {
+		return blankTime, nil, err
+	}
+	// Next step is to run gitBlame and figure out
+	// the earliest date of addition of the file. It stays blank if
+	// no line has a date, leaving the file without a year.
+	earliestTime := blankTime
+	merges := make(map[plumbing.Hash]bool)
+	for _, line := range blame.Lines {
+		if lc.skipMerges && isMergeCommit(lc.repo, line.Hash, merges) {
+			continue
+		}
+		if commitTime := line.Date; hasDate(commitTime) && (earliestTime.IsZero() || commitTime.Before(earliestTime)) {
+			earliestTime = commitTime
+		}
+	}
+	// Empty files have no lines to blame, so only the
+	// commit that added them can tell their year.
+	if lc.creation || len(blame.Lines) == 0 {
+		created, err := fileCreationTime(lc.repo, lc.headCommit.Hash, relPath, lc.skipMerges)
+		if err != nil {
+			return blankTime, nil, err
+		}
+		if hasDate(created) && (earliestTime.IsZero() || created.Before(earliestTime)) {
+			earliestTime = created
+		}
+	}
+	return earliestTime, blame.Lines, nil
+}
+
+// save writes the properly licensed file to disk. In a dry run the
+// working tree stays untouched and in patch mode the change is diffed.
+func (lc *licenseConformer) save(goFile string, licensed []byte) (*conformResult, error) {
+	header := leadingComment(licensed, lc.exts.styleFor(goFile))
+	res := &conformResult{added: true, status: StatusAdded, year: noticeYear(header), apache: isApacheHeader(header)}
+	if lc.dryRun && !lc.patch {
+		return res, nil
+	}
+	if lc.staged {
+		res.staged = &stagedWrite{path: goFile, contents: licensed}
+		return res, nil
+	}
+	if lc.patch {
+		original, err := ioutil.ReadFile(goFile)
+		if err != nil {
+			return nil, err
+		}
+		relToRootPath, err := repoRelPath(lc.dirPath, goFile)
+		if err != nil {
+			return nil, err
+		}
+		res.diff = unifiedDiff(lc.diffPrefix+relToRootPath, original, licensed, lc.minimalDiff)
+		return res, nil
+	}
+	if err := writeFile(goFile, licensed, lc.renameSafe); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (lc *licenseConformer) sameHolder(a, b string) bool {
+	a, b = lc.trimEntitySuffix(lc.normHolder(a)), lc.trimEntitySuffix(lc.normHolder(b))
+	if lc.ignoreCase {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// renderHolder is how holder is spelled in headers, which unlike
+// in comparisons includes lc.entitySuffix.
+func (lc *licenseConformer) renderHolder(holder string) string {
+	return lc.trimEntitySuffix(lc.normHolder(holder)) + lc.entitySuffix
+}
+
+func (lc *licenseConformer) trimEntitySuffix(holder string) string {
+	if lc.entitySuffix == "" {
+		return holder
+	}
+	if trimmed := strings.TrimSuffix(holder, lc.entitySuffix); trimmed != holder {
+		return trimmed
+	}
+	// Holders read from headers lose the period ending their line,
+	// which may be the one the suffix ends in.
+	return strings.TrimSuffix(holder, strings.TrimRight(lc.entitySuffix, "."))
+}
+
+// collapseSuffixPeriod turns the ".." left in header by templates that
+// put a period after the holder, such as Apache 2.0's, into the single
+// period that lc.entitySuffix ends in, e.g. ", Inc.".
+func (lc *licenseConformer) collapseSuffixPeriod(header []byte) []byte {
+	if !strings.HasSuffix(lc.entitySuffix, ".") {
+		return header
+	}
+	return bytes.Replace(header, []byte(lc.entitySuffix+"."), []byte(lc.entitySuffix), -1)
+}
+
+// replaceHolder rewrites the holder named on the first copyright line
+// of the leading comment of sniff to holder, keeping the year and
+// wording as they are.
+func (lc *licenseConformer) replaceHolder(goFile string, sniff []byte, f io.ReadCloser, holder string) (*conformResult, error) {
+	rest, err := ioutil.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	loc := regCopyrightLine.FindSubmatchIndex(leadingComment(sniff, lc.exts.styleFor(goFile)))
+	restamped := new(bytes.Buffer)
+	restamped.Write(sniff[:loc[4]])
+	rendered := lc.renderHolder(holder)
+	restamped.WriteString(rendered)
+	after := sniff[loc[5]:]
+	if strings.HasSuffix(rendered, ".") {
+		after = bytes.TrimPrefix(after, []byte("."))
+	}
+	restamped.Write(after)
+	restamped.Write(rest)
+	return lc.save(goFile, restamped.Bytes())
+}
+
+// extendYearRanges makes the years of every copyright line in the
+// leading comment of the file run up to thisYear, so "Copyright 2017"
+// becomes "Copyright 2017-2020" and "2017-2019" becomes "2017-2020".
+func (lc *licenseConformer) extendYearRanges(goFile string, sniff []byte, f io.ReadCloser, thisYear int) (*conformResult, error) {
+	rest, err := ioutil.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	conforming := &conformResult{status: StatusConforming, year: headerYear(sniff), apache: isApacheHeader(sniff)}
+	comment := leadingComment(sniff, lc.exts.styleFor(goFile))
+	extended := new(bytes.Buffer)
+	last := 0
+	for _, loc := range regCopyrightLine.FindAllSubmatchIndex(comment, -1) {
+		first, _ := strconv.Atoi(string(comment[loc[2] : loc[2]+4]))
+		if first >= thisYear {
+			continue
+		}
+		extended.Write(sniff[last:loc[2]])
+		fmt.Fprintf(extended, "%d-%d", first, thisYear)
+		last = loc[3]
+	}
+	if last == 0 {
+		return conforming, nil
+	}
+	extended.Write(sniff[last:])
+	if bytes.Equal(extended.Bytes(), sniff) {
+		return conforming, nil
+	}
+	extended.Write(rest)
+	return lc.save(goFile, extended.Bytes())
+}
+
+// refreshYears replaces the year of every copyright line in the leading
+// comment of the file with the latest year that its lines were committed
+// in, so a "Copyright 2015" header of a file last changed in 2020 becomes
+// "Copyright 2020". Ranges keep their first year, "2015-2018" becomes
+// "2015-2020". The rest of the header is left as it is.
+func (lc *licenseConformer) refreshYears(goFile string, sniff []byte, f io.ReadCloser) (*conformResult, error) {
+	rest, err := ioutil.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	conforming := &conformResult{status: StatusConforming, year: headerYear(sniff), apache: isApacheHeader(sniff)}
+	relToRootPath, err := repoRelPath(lc.dirPath, goFile)
+	if err != nil {
+		return nil, err
+	}
+	_, blameLines, err := lc.earliestCommitTimeWithin(relToRootPath)
+	if err == context.DeadlineExceeded {
+		lc.logf("skipping %q: blame took longer than %v", relToRootPath, lc.blameTimeout)
+		return &conformResult{status: StatusSkipped}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	merges := make(map[plumbing.Hash]bool)
+	isMerge := func(hash plumbing.Hash) bool { return lc.skipMerges && isMergeCommit(lc.repo, hash, merges) }
+	credits, err := blameCredits(lc.repo, blameLines, isMerge, lc.mailmap)
+	if err != nil {
+		return nil, err
+	}
+	latest := latestYear(credits)
+	if latest == 0 {
+		return conforming, nil
+	}
+	comment := leadingComment(sniff, lc.exts.styleFor(goFile))
+	refreshed := new(bytes.Buffer)
+	last := 0
+	for _, loc := range regCopyrightLine.FindAllSubmatchIndex(comment, -1) {
+		years := string(comment[loc[2]:loc[3]])
+		first, _ := strconv.Atoi(years[:4])
+		want := strconv.Itoa(latest)
+		if strings.Contains(years, "-") && first < latest {
+			want = fmt.Sprintf("%d-%d", first, latest)
+		}
+		if years == want {
+			continue
+		}
+		refreshed.Write(sniff[last:loc[2]])
+		refreshed.WriteString(want)
+		last = loc[3]
+	}
+	if last == 0 {
+		return conforming, nil
+	}
+	refreshed.Write(sniff[last:])
+	refreshed.Write(rest)
+	return lc.save(goFile, refreshed.Bytes())
+}
+
+// transformBody applies lc.transform, if any, to body,
+// the part of goFile that ends up below its header.
+func (lc *licenseConformer) transformBody(goFile string, body []byte) []byte {
+	if lc.transform == nil {
+		return body
+	}
+	return lc.transform(goFile, body)
+}
+
+// trimLeadingBlankLines returns b without the
+// whitespace only lines that it starts with.
+func trimLeadingBlankLines(b []byte) []byte {
+	for len(b) > 0 {
+		nl := bytes.IndexByte(b, '\n')
+		if nl < 0 || len(bytes.TrimSpace(b[:nl])) > 0 {
+			break
+		}
+		b = b[nl+1:]
+	}
+	return b
+}
+
+// rewriteHeader replaces the license notice in the leading comment of
+// the file with the canonical rendering of its template, keeping the
+// year that the notice already carried. If keepCopyright is set the
+// existing copyright lines are kept verbatim instead of rendered.
+func (lc *licenseConformer) rewriteHeader(goFile string, sniff []byte, f io.ReadCloser, holders []string, keepCopyright bool) (*conformResult, error) {
+	rest, err := ioutil.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	original := append(sniff, rest...)
+	conforming := &conformResult{status: StatusConforming, year: headerYear(sniff), apache: isApacheHeader(sniff)}
+	tmpl := lc.templateFor(goFile)
+	style := lc.exts.styleFor(goFile)
+	start, end, ok := licenseBlock(original, style, lc.contains)
+	if !ok || conforming.year == 0 {
+		return conforming, nil
+	}
+	header, err := renderHeader(tmpl, newCopyright(conforming.year, holders, lc.renderHolder), style)
+	if err != nil {
+		return nil, err
+	}
+	header = lc.collapseSuffixPeriod(header)
+	if lc.trimSpace {
+		header = trimTrailingSpace(header)
+	}
+	if !utf8.Valid(original) {
+		if header, err = encodeHeader(header, lc.encoding); err == errNotUTF8 {
+			return lc.skipNotUTF8(goFile), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if keepCopyright {
+		header = spliceCopyrightLines(header, original[start:end])
+	}
+	// Blank lines above the notice would otherwise end up below it.
+	keep := bomLen(original)
+	keep += shebangLen(original[keep:])
+	if len(trimLeadingBlankLines(original[keep:start])) == 0 {
+		start = keep
+	}
+	stripped := append(original[:start:start], lc.transformBody(goFile, original[end:])...)
+	licensed := insertHeader(stripped, header, style)
+	if bytes.Equal(licensed, original) {
+		return conforming, nil
+	}
+	return lc.save(goFile, licensed)
+}
+
+// spliceCopyrightLines replaces the first run of copyright lines in
+// header with those of the existing notice block, leaving header as is
+// if either has none.
+func spliceCopyrightLines(header, block []byte) []byte {
+	var existing [][]byte
+	for _, line := range bytes.SplitAfter(block, []byte("\n")) {
+		if regCopyrightLine.Match(line) {
+			existing = append(existing, line)
+		}
+	}
+	lines := bytes.SplitAfter(header, []byte("\n"))
+	from := 0
+	for from < len(lines) && !regCopyrightLine.Match(lines[from]) {
+		from++
+	}
+	to := from
+	for to < len(lines) && regCopyrightLine.Match(lines[to]) {
+		to++
+	}
+	if len(existing) == 0 || from == to {
+		return header
+	}
+	spliced := make([][]byte, 0, len(lines)-(to-from)+len(existing))
+	spliced = append(spliced, lines[:from]...)
+	spliced = append(spliced, existing...)
+	spliced = append(spliced, lines[to:]...)
+	return bytes.Join(spliced, nil)
+}
+
+// holderConfigFile when present in a directory sets the
+// copyright holder for every file in that directory's subtree.
+const holderConfigFile = ".conform-holder"
+
+// holderResolver finds the nearest holderConfigFile between
+// a file's directory and root, caching lookups per directory.
+type holderResolver struct {
+	root string
+
+	mu    sync.Mutex
+	cache map[string]*string
+}
+
+func (hr *holderResolver) holderFor(path string) (string, bool) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if hr.cache == nil {
+		hr.cache = make(map[string]*string)
+	}
+	holder := hr.lookupLocked(filepath.Dir(path))
+	if holder == nil {
+		return "", false
+	}
+	return *holder, true
+}
+
+func (hr *holderResolver) lookupLocked(dir string) *string {
+	if holder, ok := hr.cache[dir]; ok {
+		return holder
+	}
+	var holder *string
+	if blob, err := ioutil.ReadFile(filepath.Join(dir, holderConfigFile)); err == nil {
+		if trimmed := strings.TrimSpace(string(blob)); trimmed != "" {
+			holder = &trimmed
+		}
+	}
+	if parent := filepath.Dir(dir); holder == nil && dir != hr.root && parent != dir {
+		holder = hr.lookupLocked(parent)
+	}
+	hr.cache[dir] = holder
+	return holder
+}
+
+var regCopyrightLine = regexp.MustCompile(`(?im)^\W*Copyright\s+(?:\(c\)\s+)?(\d{4}(?:\s*-\s*\d{4})?),?\s+(.*?)\.?(?:\s+All rights reserved\.?)?\s*$`)
+
+// headerYear returns the first year of the first copyright line in b.
+func headerYear(b []byte) int {
+	match := regCopyrightLine.FindSubmatch(b)
+	if match == nil {
+		return 0
+	}
+	year, _ := strconv.Atoi(string(match[1][:4]))
+	return year
+}
+
+// noticeYear is headerYear, falling back to the year of the first
+// SPDX-FileCopyrightText line for headers in the short SPDX form.
+func noticeYear(b []byte) int {
+	if year := headerYear(b); year != 0 {
+		return year
+	}
+	return sidecarYear(b)
+}
+
+// existingHolder returns the holder named by the
+// first copyright line in b if there is one.
+func existingHolder(b []byte) (string, bool) {
+	match := regCopyrightLine.FindSubmatch(b)
+	if match == nil || len(match[2]) == 0 {
+		return "", false
+	}
+	return string(match[2]), true
+}
+
+// placeholderHolders are holder names that only ever
+// appear because nobody replaced the default or a stub.
+var placeholderHolders = []string{
+	"ACME",
+	"YOUR_COMPANY",
+	"YOUR COMPANY",
+	"COPYRIGHT HOLDER",
+	"<COPYRIGHT HOLDER>",
+	"TODO",
+}
+
+func isPlaceholderHolder(holder string) bool {
+	holder = strings.TrimSpace(holder)
+	for _, placeholder := range placeholderHolders {
+		if strings.EqualFold(holder, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// chainHolderFilters returns a func that applies filters
+// to a copyright holder in order.
+func chainHolderFilters(filters ...func(string) string) func(string) string {
+	return func(holder string) string {
+		for _, filter := range filters {
+			holder = filter(holder)
+		}
+		return holder
+	}
+}
+
+// CanonicalHolders returns a holder filter that replaces each of the
+// aliases, the keys of which are matched ignoring case and surrounding
+// whitespace, with its canonical spelling. Other holders pass through.
+func CanonicalHolders(aliases map[string]string) func(string) string {
+	canonical := make(map[string]string, len(aliases))
+	for alias, holder := range aliases {
+		canonical[strings.ToLower(strings.TrimSpace(alias))] = holder
+	}
+	return func(holder string) string {
+		if c, ok := canonical[strings.ToLower(strings.TrimSpace(holder))]; ok {
+			return c
+		}
+		return holder
+	}
+}
+
+// CollapseSpaces replaces each run of whitespace in holder with a single space.
+func CollapseSpaces(holder string) string { return strings.Join(strings.Fields(holder), " ") }
+
+var regIncSuffix = regexp.MustCompile(`(?i)\binc$`)
+
+// SanitizeHolder strips trailing punctuation from holder, which the
+// templates already follow with a period, and spells the "Inc" suffix
+// consistently so that "ACME inc." and "ACME Inc" compare equal.
+func SanitizeHolder(holder string) string {
+	holder = strings.TrimRight(strings.TrimSpace(holder), ".,;: ")
+	return regIncSuffix.ReplaceAllString(holder, "Inc")
+}
+
+type copyright struct {
+	Year int
+
+	Holder string
+
+	// StartYear and EndYear are the first and last years of the first
+	// copyright line, the same unless that line spans several years.
+	StartYear, EndYear int
+
+	// Lines has one entry per stacked copyright line,
+	// the first of which repeats Year and Holder.
+	Lines []*copyrightLine
+}
+
+type copyrightLine struct {
+	Year yearSpan
+
+	Holder string
+
+	// holders are those that Holder lists, if several.
+	holders []string
+}
+
+// yearSpan renders as "2017" or as "2017-2019" if Last is after First.
+type yearSpan struct {
+	First, Last int
+}
+
+func (ys yearSpan) String() string {
+	if ys.Last > ys.First {
+		return fmt.Sprintf("%d-%d", ys.First, ys.Last)
+	}
+	return strconv.Itoa(ys.First)
+}
+
+func newCopyright(year int, holders []string, normHolder func(string) string) *copyright {
+	info := &copyright{Year: year, StartYear: year, EndYear: year}
+	for _, holder := range holders {
+		info.Lines = append(info.Lines, &copyrightLine{Year: yearSpan{First: year}, Holder: normHolder(holder)})
+	}
+	if len(info.Lines) > 0 {
+		info.Holder = info.Lines[0].Holder
+	}
+	return info
+}
+
+// extendTo makes every copyright line span from its first year up to last.
+func (info *copyright) extendTo(last int) {
+	for _, line := range info.Lines {
+		if last > line.Year.First {
+			line.Year.Last = last
+		}
+	}
+	info.firstLineYears()
+}
+
+// firstLineYears sets the years and holder of info to those of its first line.
+func (info *copyright) firstLineYears() {
+	if len(info.Lines) == 0 {
+		return
+	}
+	first := info.Lines[0]
+	info.Year, info.Holder = first.Year.First, first.Holder
+	info.StartYear, info.EndYear = first.Year.First, first.Year.First
+	if first.Year.Last > first.Year.First {
+		info.EndYear = first.Year.Last
+	}
+}
+
+// newYearCopyright stacks one copyright line per year,
+// each crediting the authors that were active that year.
+func newYearCopyright(years []*yearAuthors, normHolder func(string) string) *copyright {
+	info := new(copyright)
+	for _, ya := range years {
+		authors := make([]string, len(ya.authors))
+		for i, author := range ya.authors {
+			authors[i] = normHolder(author)
+		}
+		info.Lines = append(info.Lines, &copyrightLine{Year: yearSpan{First: ya.year}, Holder: strings.Join(authors, ", "), holders: authors})
+	}
+	info.firstLineYears()
+	return info
+}
+
+// newAuthorCopyright stacks one copyright line per
+// author, each spanning the years of their commits.
+func newAuthorCopyright(spans []*authorSpan, normHolder func(string) string) *copyright {
+	info := new(copyright)
+	for _, span := range spans {
+		info.Lines = append(info.Lines, &copyrightLine{Year: span.years, Holder: normHolder(span.author)})
+	}
+	info.firstLineYears()
+	return info
+}
+
+// wrapCopyrightLines splits the copyright lines of info that list several
+// holders into lines of the same year, each listing as many of them as
+// fit within width columns once rendered by tmpl in style. A holder
+// too long to fit still gets a line of its own.
+func wrapCopyrightLines(info *copyright, tmpl *template.Template, style *CommentStyle, width int) (*copyright, error) {
+	// The columns around the holder are found by rendering a marker.
+	const marker = "\x00"
+	probe := newCopyright(info.Year, []string{marker}, func(holder string) string { return holder })
+	header, err := renderHeader(tmpl, probe, style)
+	if err != nil {
+		return nil, err
+	}
+	overhead := -1
+	for _, line := range strings.Split(string(header), "\n") {
+		if strings.Contains(line, marker) {
+			overhead = utf8.RuneCountInString(line) - len(marker)
+			break
+		}
+	}
+	if overhead < 0 {
+		return info, nil
+	}
+
+	wrapped := *info
+	wrapped.Lines = nil
+	for _, line := range info.Lines {
+		if len(line.holders) < 2 {
+			wrapped.Lines = append(wrapped.Lines, line)
+			continue
+		}
+		var chunk []string
+		flush := func() {
+			wrapped.Lines = append(wrapped.Lines, &copyrightLine{Year: line.Year, Holder: strings.Join(chunk, ", "), holders: chunk})
+			chunk = nil
+		}
+		for _, holder := range line.holders {
+			// Only the year of the line differs from that of the probe.
+			cols := overhead + len(line.Year.String()) - len(strconv.Itoa(info.Year)) +
+				utf8.RuneCountInString(strings.Join(append(chunk, holder), ", "))
+			if len(chunk) > 0 && cols > width {
+				flush()
+			}
+			chunk = append(chunk, holder)
+		}
+		flush()
+	}
+	wrapped.firstLineYears()
+	return &wrapped, nil
+}
+
+var apacheLicenseURL = []byte("http://www.apache.org/licenses/LICENSE-2.0")
+var doNotEdit = []byte("DO NOT EDIT!")
+var allRightsReservedLower = []byte("all rights reserved")
+
+// mitPermissionLower opens the MIT permission notice, which
+// unlike the others does not reserve any rights.
+var mitPermissionLower = []byte("permission is hereby granted, free of charge")
+
+// gplTermsLower and mplTermsLower are from the notices that the GPL
+// and the MPL recommend, neither of which reserves any rights.
+var gplTermsLower = []byte("under the terms of the gnu general public license")
+var mplTermsLower = []byte("subject to the terms of the mozilla public")
+
+// apacheBoilerplateLower is what Apache 2.0 headers say even when
+// they leave out the line with apacheLicenseURL.
+var apacheBoilerplateLower = []byte("licensed under the apache license, version 2.0")
+
+var apacheSPDXIdentifier = []byte("SPDX-License-Identifier: Apache-2.0")
+
+func containsALicense(b []byte) bool {
+	lower := bytes.ToLower(b)
+	return bytes.Contains(lower, allRightsReservedLower) || isApacheHeader(b) ||
+		bytes.Contains(lower, mitPermissionLower) || bytes.Contains(lower, gplTermsLower) ||
+		bytes.Contains(lower, mplTermsLower) || regSPDXHeaderLine.Match(b)
+}
+
+// licenseSignature identifies a license by phrases that
+// only its notices contain, matched case insensitively.
+type licenseSignature struct {
+	name    string
+	phrases [][]byte
+}
+
+var licenseSignatures = []*licenseSignature{
+	{name: "Apache-2.0", phrases: [][]byte{[]byte("apache.org/licenses/license-2.0"), []byte("licensed under the apache license, version 2.0")}},
+	{name: "BSD", phrases: [][]byte{[]byte("governed by a bsd-style"), []byte("redistribution and use in source and binary forms")}},
+	{name: "MIT", phrases: [][]byte{[]byte("permission is hereby granted, free of charge"), []byte("licensed under the mit license")}},
+	{name: "GPL", phrases: [][]byte{[]byte("gnu general public license")}},
+	{name: "MPL-2.0", phrases: [][]byte{[]byte("mozilla public license")}},
+}
+
+// detectLicenses returns the names of every license whose notice is in b.
+func detectLicenses(b []byte) []string {
+	lower := bytes.ToLower(collapseWhitespace(b))
+	var names []string
+	for _, sig := range licenseSignatures {
+		if containsAny(lower, sig.phrases) {
+			names = append(names, sig.name)
+		}
+	}
+	return names
+}
+
+var regWhitespaceRun = regexp.MustCompile(`\s+(?:(?://+|--|#+|\*)\s*)*`)
+
+// collapseWhitespace replaces every run of whitespace, together with any
+// line comment markers that open the next line, with a single space so
+// that headers wrapped differently still match the canonical wording.
+func collapseWhitespace(b []byte) []byte {
+	return regWhitespaceRun.ReplaceAll(b, []byte(" "))
+}
+
+// leadingComment returns the prefix of b made up only of comments in
+// style and blank lines, after any byte-order mark and shebang, that is
+// everything before the first line of code.
+func leadingComment(b []byte, style *CommentStyle) []byte {
+	i := bomLen(b)
+	i += shebangLen(b[i:])
+	for i < len(b) {
+		line := b[i:]
+		if nl := bytes.IndexByte(line, '\n'); nl >= 0 {
+			line = line[:nl+1]
+		}
+		trimmed := bytes.TrimSpace(line)
+		switch {
+		case len(trimmed) == 0, isLineComment(trimmed, style):
+			i += len(line)
+		case isBlockComment(trimmed):
+			// An unterminated comment runs to the end of b.
+			lead := bytes.Index(line, trimmed)
+			n, _ := blockCommentLen(b[i+lead:])
+			i += lead + n
+		default:
+			return b[:i]
+		}
+	}
+	return b
+}
+
+// licenseBlock returns the bounds of the first run of comment lines
+// in the leading comment of b that contains reports as a license,
+// along with the blank lines that follow it.
+func licenseBlock(b []byte, style *CommentStyle, contains func([]byte) bool) (start, end int, ok bool) {
+	comment := leadingComment(b, style)
+	// A byte-order mark or shebang stays where it is.
+	i := bomLen(comment)
+	i += shebangLen(comment[i:])
+	for i < len(comment) {
+		// Skip the blank lines leading up to the next run.
+		for i < len(comment) {
+			nl := bytes.IndexByte(comment[i:], '\n')
+			if nl < 0 || len(bytes.TrimSpace(comment[i:i+nl])) > 0 {
+				break
+			}
+			i += nl + 1
+		}
+		start = i
+		for i < len(comment) {
+			line := comment[i:]
+			if nl := bytes.IndexByte(line, '\n'); nl >= 0 {
+				line = line[:nl+1]
+			}
+			trimmed := bytes.TrimSpace(line)
+			if len(trimmed) == 0 {
+				break
+			}
+			if isBlockComment(trimmed) {
+				lead := bytes.Index(line, trimmed)
+				n, _ := blockCommentLen(comment[i+lead:])
+				line = comment[i : i+lead+n]
+				if nl := bytes.IndexByte(comment[i+len(line):], '\n'); nl >= 0 {
+					line = comment[i : i+len(line)+nl+1]
+				}
+			}
+			i += len(line)
+		}
+		if i == start {
+			break
+		}
+		if contains(comment[start:i]) {
+			end = i
+			for end < len(comment) {
+				nl := bytes.IndexByte(comment[end:], '\n')
+				if nl < 0 || len(bytes.TrimSpace(comment[end:end+nl])) > 0 {
+					break
+				}
+				end += nl + 1
+			}
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
+
+func isBlockComment(b []byte) bool {
+	_, ok := blockCommentLen(b)
+	return ok
+}
+
+func containsAny(b []byte, needles [][]byte) bool {
+	for _, needle := range needles {
+		if bytes.Contains(b, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func isApacheHeader(b []byte) bool {
+	return bytes.Contains(b, apacheLicenseURL) || bytes.Contains(bytes.ToLower(b), apacheBoilerplateLower) ||
+		bytes.Contains(b, apacheSPDXIdentifier)
+}
+
+// truncatedHeader reports whether the comment b, in style, opens the
+// license that tmpl renders but stops before its closing line, as left
+// behind by a stamp that was interrupted midway through writing the file.
+// The license opens with its first two lines so that one line notices,
+// such as "Licensed under the Apache License, Version 2.0", are not
+// taken for cut off ones.
+func truncatedHeader(b []byte, tmpl *template.Template, style *CommentStyle) bool {
+	header, err := renderHeader(tmpl, newCopyright(2000, []string{"Holder"}, func(holder string) string { return holder }), style)
+	if err != nil {
+		return false
+	}
+	want := licenseTextLines(header)
+	if len(want) < 3 {
+		return false
+	}
+	have := make(map[string]bool)
+	for _, line := range licenseTextLines(b) {
+		have[line] = true
+	}
+	return have[want[0]] && have[want[1]] && !have[want[len(want)-1]]
+}
+
+// licenseTextLines returns the lines of the comment b that carry any
+// text besides copyright lines, trimmed, with their whitespace collapsed
+// and in lower case.
+func licenseTextLines(b []byte) []string {
+	var lines []string
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if regCopyrightLine.Match(line) || bytes.IndexFunc(line, unicode.IsLetter) < 0 {
+			continue
+		}
+		lines = append(lines, strings.ToLower(string(collapseWhitespace(bytes.TrimSpace(line)))))
+	}
+	return lines
+}
+
+// noticeFileNames are the names under which
+// Apache 2.0 projects conventionally ship a NOTICE.
+var noticeFileNames = []string{"NOTICE", "NOTICE.txt", "NOTICE.md"}
+
+// HasNoticeFile reports whether the repo at dirPath has a
+// NOTICE file at its root, under any of noticeFileNames.
+func HasNoticeFile(dirPath string) bool {
+	for _, name := range noticeFileNames {
+		if fi, err := os.Stat(filepath.Join(dirPath, name)); err == nil && fi.Mode().IsRegular() {
+			return true
+		}
+	}
+	return false
+}
+
+func autoGenerated(b []byte) bool { return bytes.Contains(b, doNotEdit) }
+
+func sniffIfHasLicense(p string, size int, style *CommentStyle, contains func([]byte) bool) ([]byte, io.ReadCloser, bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	headerBlob := make([]byte, size)
+	n, err := io.ReadAtLeast(f, headerBlob, 1)
+	if err == io.EOF {
+		// An empty file just lacks a license like any other.
+		return nil, f, false, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, false, err
+	}
+	// Files shorter than the buffer only fill part of it, the
+	// rest must not end up in the file along with the header.
+	headerBlob = headerBlob[:n]
+	// A long leading banner, e.g. a generated preamble, can push the real
+	// license past the window so keep reading for as long as the leading
+	// comment runs to the end of what has been read so far.
+	for !contains(headerBlob) && len(headerBlob) < maxLeadingCommentSize &&
+		len(leadingComment(headerBlob, style)) == len(headerBlob) {
+		chunk := make([]byte, size)
+		n, err := io.ReadAtLeast(f, chunk, 1)
+		if err != nil {
+			break
+		}
+		headerBlob = append(headerBlob, chunk[:n]...)
+	}
+	return headerBlob, f, contains(headerBlob), nil
+}
+
+var vcsDirNames = map[string]bool{
+	".git":   true,
+	".hg":    true,
+	".svn":   true,
+	".bzr":   true,
+	"_darcs": true,
+	"CVS":    true,
+}
+
+func vcsDir(path string, fi os.FileInfo) bool { return vcsDirNames[fi.Name()] }
+
+func hiddenDir(path string, fi os.FileInfo) bool {
+	name := fi.Name()
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// anyDirSkipper returns a func that skips a
+// directory if any of skippers would skip it.
+func anyDirSkipper(skippers ...func(string, os.FileInfo) bool) func(string, os.FileInfo) bool {
+	return func(path string, fi os.FileInfo) bool {
+		for _, skip := range skippers {
+			if skip(path, fi) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// errWalkStopped ends the walk of siftThroughFiles once it is told to stop.
+var errWalkStopped = errors.New("walk stopped")
+
+// siftThroughFiles walks root sending every path that satisfies match,
+// until stop is closed. Directories other than root for which skipDir
+// returns true are pruned.
+func siftThroughFiles(root string, match, skipDir func(string, os.FileInfo) bool, stop <-chan bool) chan string {
+	filesChan := make(chan string)
+	go func() {
+		defer close(filesChan)
+		filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi.IsDir() && path != root && skipDir != nil && skipDir(path, fi) {
+				return filepath.SkipDir
+			}
+			if err == nil && match(path, fi) {
+				select {
+				case filesChan <- path:
+				case <-stop:
+					return errWalkStopped
+				}
+			}
+			return err
+		})
+	}()
+	return filesChan
+}
+
+// repoRelPath returns the path of p relative to root with slashes as
+// separators, which is how git names files on every platform.
+func repoRelPath(root, p string) (string, error) {
+	relPath, err := filepath.Rel(root, p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// approxShortHeaderSize is how much of a file is sniffed for a license
+// unless Options.SniffBytes says otherwise.
+const approxShortHeaderSize = 624
+
+// maxLeadingCommentSize bounds how far past the sniff size
+// the sniff keeps reading a leading comment in search of a license.
+const maxLeadingCommentSize = 64 << 10
@@ -0,0 +1,789 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestCollapseWhitespace(t *testing.T) {
+	tests := []struct {
+		name           string
+		b              string
+		want           bool
+		wantNormalized bool
+	}{
+		{name: "on one line", b: "// Copyright 2017 ACME. All Rights Reserved.\n", want: true, wantNormalized: true},
+		{name: "split across lines", b: "// Copyright 2017 ACME. All\n//   Rights Reserved.\n", want: false, wantNormalized: true},
+		{name: "split with blank comment lines", b: "// Copyright 2017 ACME. All\n//\n// Rights Reserved.\n", want: false, wantNormalized: true},
+		{name: "license URL", b: "// You may obtain a copy of the License at\n//\n//      http://www.apache.org/licenses/LICENSE-2.0\n", want: true, wantNormalized: true},
+		{name: "no license", b: "// Package a does things.\npackage a\n", want: false, wantNormalized: false},
+	}
+	for _, tt := range tests {
+		if got := containsALicense([]byte(tt.b)); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		if got := containsALicense(collapseWhitespace([]byte(tt.b))); got != tt.wantNormalized {
+			t.Errorf("%s: normalized got %v, want %v", tt.name, got, tt.wantNormalized)
+		}
+	}
+}
+
+func TestHolderResolverNested(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	writeFiles(t, dir, map[string]string{
+		".conform-holder":         "Root Corp\n",
+		"a/.conform-holder":       "  A Corp  \n",
+		"a/b/.conform-holder":     "B Corp",
+		"a/blank/.conform-holder": "\n",
+	})
+
+	hr := &holderResolver{root: dir}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "x.go", want: "Root Corp"},
+		{path: "c/x.go", want: "Root Corp"},
+		{path: "a/x.go", want: "A Corp"},
+		{path: "a/deep/er/x.go", want: "A Corp"},
+		{path: "a/b/x.go", want: "B Corp"},
+		{path: "a/b/c/x.go", want: "B Corp"},
+		// An empty config file defers to the one above it.
+		{path: "a/blank/x.go", want: "A Corp"},
+	}
+	for _, tt := range tests {
+		got, ok := hr.holderFor(filepath.Join(dir, filepath.FromSlash(tt.path)))
+		if !ok || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.path, got, ok, tt.want)
+		}
+	}
+}
+
+func TestHolderResolverNone(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	// A config file above the root does not apply.
+	writeFiles(t, dir, map[string]string{".conform-holder": "Outer Corp"})
+
+	hr := &holderResolver{root: filepath.Join(dir, "repo")}
+	if got, ok := hr.holderFor(filepath.Join(dir, "repo", "sub", "x.go")); ok {
+		t.Errorf("got %q, want no holder", got)
+	}
+}
+
+func TestSiftThroughFilesNoRecurse(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	writeFiles(t, dir, map[string]string{
+		"a.go":          "package a\n",
+		"sub/b.go":      "package sub\n",
+		"sub/deep/c.go": "package deep\n",
+	})
+
+	noRecurse := func(string, os.FileInfo) bool { return true }
+	tests := []struct {
+		name    string
+		skipDir func(string, os.FileInfo) bool
+		want    []string
+	}{
+		{name: "no-recurse", skipDir: noRecurse, want: []string{"a.go"}},
+		{name: "recursive", want: []string{"a.go", "sub/b.go", "sub/deep/c.go"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for path := range siftThroughFiles(dir, testExtensions(t).isSourceFile, tt.skipDir, nil) {
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, filepath.ToSlash(relPath))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSiftThroughFilesStop(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	files := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		files[fmt.Sprintf("f%d.go", i)] = "package a\n"
+	}
+	writeFiles(t, dir, files)
+
+	stop := make(chan bool)
+	filesChan := siftThroughFiles(dir, testExtensions(t).isSourceFile, nil, stop)
+	<-filesChan
+	close(stop)
+	// Once the walk sees stop it ends rather than send the rest,
+	// though it may have been sending one more path already.
+	time.Sleep(50 * time.Millisecond)
+	timeout := time.After(5 * time.Second)
+	for sent := 0; ; sent++ {
+		select {
+		case _, ok := <-filesChan:
+			if !ok {
+				if sent > 1 {
+					t.Errorf("got %d more paths after stop was closed, want at most 1", sent)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("walk still going after stop was closed")
+		}
+	}
+}
+
+func TestSanitizeHolder(t *testing.T) {
+	tests := []struct {
+		holder string
+		want   string
+	}{
+		{holder: "ACME", want: "ACME"},
+		{holder: "ACME.", want: "ACME"},
+		{holder: "ACME, ", want: "ACME"},
+		{holder: "ACME inc.", want: "ACME Inc"},
+		{holder: "ACME INC", want: "ACME Inc"},
+		{holder: "ACME Inc.;", want: "ACME Inc"},
+		// Only a trailing Inc is a suffix.
+		{holder: "Incense Works", want: "Incense Works"},
+		{holder: "Zinc", want: "Zinc"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeHolder(tt.holder); got != tt.want {
+			t.Errorf("SanitizeHolder(%q) = %q, want %q", tt.holder, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalHolders(t *testing.T) {
+	canonical := CanonicalHolders(map[string]string{"ACME Inc.": "ACME Corp", " Acme ": "ACME Corp"})
+	tests := []struct {
+		holder string
+		want   string
+	}{
+		{holder: "ACME Inc.", want: "ACME Corp"},
+		{holder: "  acme inc. ", want: "ACME Corp"},
+		{holder: "ACME", want: "ACME Corp"},
+		{holder: "ACME Inc", want: "ACME Inc"},
+		{holder: "Globex", want: "Globex"},
+	}
+	for _, tt := range tests {
+		if got := canonical(tt.holder); got != tt.want {
+			t.Errorf("canonical(%q) = %q, want %q", tt.holder, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateFor(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2015), map[string]string{
+		"apache.go": testSource,
+		"bsd.go":    testSource,
+		"mit.go":    testSource,
+	})
+	templates := map[string]*template.Template{
+		"apache.go": shortApache2Point0Templ,
+		"bsd.go":    shortBSDTempl,
+		"mit.go":    shortMITTempl,
+	}
+	templateFor := func(path string) *template.Template { return templates[filepath.Base(path)] }
+
+	tests := []struct {
+		relPath   string
+		wantAdded bool
+		want      string
+	}{
+		{relPath: "apache.go", wantAdded: true, want: renderTestHeader(t, shortApache2Point0Templ, 2015, "ACME") + testSource},
+		{relPath: "bsd.go", wantAdded: true, want: renderTestHeader(t, shortBSDTempl, 2015, "ACME") + testSource},
+		{relPath: "mit.go", wantAdded: true, want: renderTestHeader(t, shortMITTempl, 2015, "ACME") + testSource},
+	}
+	for _, tt := range tests {
+		lc := &licenseConformer{
+			dirPath:     tr.dir,
+			holders:     []string{"ACME"},
+			holderConf:  &holderResolver{root: tr.dir},
+			normHolder:  chainHolderFilters(),
+			fixIt:       true,
+			filePath:    filepath.Join(tr.dir, tt.relPath),
+			headCommit:  tr.head(),
+			templateFor: templateFor,
+			contains:    containsALicense,
+			sniffSize:   approxShortHeaderSize,
+			exts:        testExtensions(t),
+		}
+		res, err := lc.Do()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.relPath, err)
+		}
+		cr, _ := res.(*conformResult)
+		if added := cr != nil && cr.added; added != tt.wantAdded {
+			t.Errorf("%s: got added %v, want %v", tt.relPath, added, tt.wantAdded)
+		}
+		if got := tr.read(tt.relPath); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.relPath, got, tt.want)
+		}
+	}
+}
+
+func TestLeadingComment(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     string
+		style *CommentStyle
+		want  string
+	}{
+		{name: "line comments", b: "// a\n//b\n\npackage a\n", want: "// a\n//b\n\n"},
+		{name: "block comment", b: "/* a\n * b */\npackage a\n", want: "/* a\n * b */\n"},
+		{name: "unterminated block comment", b: "/* a\n * b\n", want: "/* a\n * b\n"},
+		{name: "no comment", b: "package a\n// a\n", want: ""},
+		{name: "only comments", b: "// a\n", want: "// a\n"},
+		{name: "hash comments", b: "# a\n\nset -e\n", style: hashComments, want: "# a\n\n"},
+		// In C a "#" starts a preprocessor directive, not a comment.
+		{name: "include", b: "/* a */\n#include <stdio.h>\n// b\n", style: blockComments, want: "/* a */\n"},
+		{name: "include without a style", b: "// a\n#include <stdio.h>\n", want: "// a\n"},
+	}
+	for _, tt := range tests {
+		if got := string(leadingComment([]byte(tt.b), tt.style)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExistingHolder(t *testing.T) {
+	tests := []struct {
+		b      string
+		want   string
+		wantOK bool
+	}{
+		{b: "// Copyright 2015 ACME. All Rights Reserved.\n", want: "ACME", wantOK: true},
+		{b: "// Copyright (c) 2015 Globex Corp\n", want: "Globex Corp", wantOK: true},
+		{b: "// Copyright 2015-2017 The Authors. All rights reserved.\n", want: "The Authors", wantOK: true},
+		{b: "// Package a has no copyright line.\n", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := existingHolder([]byte(tt.b))
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("existingHolder(%q) = %q, %v, want %q, %v", tt.b, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSniffPastWindow(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	banner := strings.Repeat("// This file was generated from the schema, regenerate it with make.\n", 20)
+	license := "// Copyright 2015 ACME. All Rights Reserved.\n"
+	code := strings.Repeat("var x = 1\n", 100)
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{name: "license below a long banner", src: banner + license + "\npackage a\n", want: true},
+		{name: "license below the leading comment", src: banner + "\npackage a\n\n" + code + license, want: false},
+		{name: "no license", src: banner + "\npackage a\n", want: false},
+	}
+	for i, tt := range tests {
+		relPath := fmt.Sprintf("a%d.go", i)
+		writeFiles(t, dir, map[string]string{relPath: tt.src})
+		_, f, got, err := sniffIfHasLicense(filepath.Join(dir, relPath), approxShortHeaderSize, slashComments, containsALicense)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		f.Close()
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStampShortFile(t *testing.T) {
+	short := "package a\n"
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": short})
+
+	sniff, f, _, err := sniffIfHasLicense(filepath.Join(tr.dir, "a.go"), approxShortHeaderSize, slashComments, containsALicense)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if string(sniff) != short {
+		t.Errorf("got sniff %q, want %q", sniff, short)
+	}
+
+	if _, err := tr.conform(Options{Fix: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tr.read("a.go"), renderTestHeader(t, shortApache2Point0Templ, 2018, "ACME")+short; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStampEmptyFile(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2016), map[string]string{"empty.go": ""})
+	rep, err := tr.conform(Options{Fix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr := rep.Files[0]; fr.Err != nil || fr.Status != StatusAdded || fr.Year != 2016 {
+		t.Errorf("got status %q year %d error %v, want %q from 2016", fr.Status, fr.Year, fr.Err, StatusAdded)
+	}
+	if got, want := tr.read("empty.go"), renderTestHeader(t, shortApache2Point0Templ, 2016, "ACME"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	// Replacing "License" everywhere would break a header it reached.
+	transform := func(path string, body []byte) []byte {
+		if filepath.Base(path) != "a.go" {
+			t.Errorf("got path %q, want one of a.go", path)
+		}
+		return []byte(strings.Replace(string(body), "License", "Permit", -1))
+	}
+	body := "// Package a follows the License.\n" + testSource
+	transformed := "// Package a follows the Permit.\n" + testSource
+	tests := []struct {
+		name       string
+		contents   string
+		rewriteAll bool
+		want       string
+	}{
+		{
+			name:     "added header",
+			contents: body,
+			want:     renderTestHeader(t, shortApache2Point0Templ, 2018, "ACME") + transformed,
+		},
+		{
+			name:       "rewritten header",
+			contents:   "// Copyright 2016 ACME. All Rights Reserved.\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n\n" + body,
+			rewriteAll: true,
+			want:       renderTestHeader(t, shortApache2Point0Templ, 2016, "ACME") + transformed,
+		},
+		// Files that keep their header are left alone.
+		{
+			name:     "conforming header",
+			contents: renderTestHeader(t, shortApache2Point0Templ, 2016, "ACME") + body,
+			want:     renderTestHeader(t, shortApache2Point0Templ, 2016, "ACME") + body,
+		},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2018), map[string]string{"a.go": tt.contents})
+		if _, err := tr.conform(Options{Fix: true, RewriteAll: tt.rewriteAll, Transform: transform}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := tr.read("a.go"); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrimLeadingBlankLines(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "\n\npackage a\n", want: "package a\n"},
+		{in: " \n\t\n\npackage a\n", want: "package a\n"},
+		{in: "package a\n\n", want: "package a\n\n"},
+		{in: "\n  package a\n", want: "  package a\n"},
+		{in: "\n\n", want: ""},
+		// A last line without a newline is kept.
+		{in: "\n ", want: " "},
+	}
+	for _, tt := range tests {
+		if got := string(trimLeadingBlankLines([]byte(tt.in))); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFlagLicenseConflicts(t *testing.T) {
+	apache := "// Copyright 2014 ACME. All Rights Reserved.\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n"
+	mit := "// Permission is hereby granted, free of charge, to any person obtaining\n// a copy of this software.\n"
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{name: "apache", contents: apache + "\n" + testSource, want: []string{"Apache-2.0"}},
+		{name: "apache and mit", contents: apache + "//\n" + mit + "\n" + testSource, want: []string{"Apache-2.0", "MIT"}},
+		// Only the leading comment counts.
+		{name: "mit below the code", contents: apache + "\n" + testSource + "\n" + mit, want: []string{"Apache-2.0"}},
+	}
+	for _, tt := range tests {
+		if got := detectLicenses(leadingComment([]byte(tt.contents), slashComments)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got licenses %q, want %q", tt.name, got, tt.want)
+		}
+
+		for _, flagConflicts := range []bool{true, false} {
+			tr, cleanup := newTestRepo(t)
+			defer cleanup()
+			tr.commit("Alice", inYear(2015), map[string]string{"a.go": tt.contents})
+			var logged []string
+			logf := func(format string, args ...interface{}) { logged = append(logged, fmt.Sprintf(format, args...)) }
+			rep, err := tr.conform(Options{Fix: true, FlagConflicts: flagConflicts, Logf: logf})
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			// A conflict is warned about, the file still conforms.
+			if fr := rep.Files[0]; fr.Err != nil || fr.Status != StatusConforming {
+				t.Errorf("%s: FlagConflicts %v: got status %q error %v, want %q", tt.name, flagConflicts, fr.Status, fr.Err, StatusConforming)
+			}
+			var wantLogged []string
+			if flagConflicts && len(tt.want) > 1 {
+				wantLogged = []string{`warning: "a.go": conflicting licenses in header: Apache-2.0, MIT`}
+			}
+			if !reflect.DeepEqual(logged, wantLogged) {
+				t.Errorf("%s: FlagConflicts %v: got logged %q, want %q", tt.name, flagConflicts, logged, wantLogged)
+			}
+			if got := tr.read("a.go"); got != tt.contents {
+				t.Errorf("%s: got\n%s\nwant it untouched", tt.name, got)
+			}
+		}
+	}
+}
+
+func TestSpliceCopyrightLines(t *testing.T) {
+	header := []byte("// Copyright 2020 ACME.\n//\n// License text.\n\n")
+	if got, want := spliceCopyrightLines(header, []byte("// Copyright 2016 Alice.\n// Copyright 2017 Bob.\n//\n// Old text.\n")), "// Copyright 2016 Alice.\n// Copyright 2017 Bob.\n//\n// License text.\n\n"; string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := spliceCopyrightLines(header, []byte("// Some notice.\n")); !bytes.Equal(got, header) {
+		t.Errorf("got\n%s\nwant the header as is without existing copyright lines", got)
+	}
+	if got := spliceCopyrightLines([]byte("// License text.\n\n"), []byte("// Copyright 2016 Alice.\n")); string(got) != "// License text.\n\n" {
+		t.Errorf("got\n%s\nwant the header as is without copyright lines of its own", got)
+	}
+}
+
+func TestApacheHeaderWithoutURL(t *testing.T) {
+	header := "// Copyright 2016 ACME\n//\n// LICENSED under the Apache License, Version 2.0 (the \"License\");\n" +
+		"// you may not use this file except in compliance with the License.\n//\n" +
+		"// Unless required by applicable law or agreed to in writing, software\n" +
+		"// distributed under the License is distributed on an \"AS IS\" BASIS,\n" +
+		"// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.\n" +
+		"// See the License for the specific language governing permissions and\n" +
+		"// limitations under the License.\n\n"
+	if !isApacheHeader([]byte(header)) {
+		t.Errorf("got no Apache header in\n%s", header)
+	}
+
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": header + testSource})
+	rep, err := tr.conform(Options{Fix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.statuses(rep)["a.go"]; got != StatusConforming {
+		t.Errorf("got status %q, want %q", got, StatusConforming)
+	}
+	if got := tr.read("a.go"); got != header+testSource {
+		t.Errorf("got\n%s\nwant it untouched", got)
+	}
+}
+
+func TestExtendYearRanges(t *testing.T) {
+	thisYear := time.Now().Year()
+	current := fmt.Sprintf("// Copyright %d ACME. All Rights Reserved.\n\n", thisYear)
+	files := map[string]string{
+		"a.go":       "// Copyright 2017 ACME. All Rights Reserved.\n// Copyright 2015-2019 Bob. All Rights Reserved.\n\n" + testSource,
+		"current.go": current + testSource,
+		"body.go":    "// Copyright 2017 ACME. All Rights Reserved.\n\n" + testSource + "\n// Copyright 2012 Vendored.\n",
+	}
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), files)
+	if _, err := tr.conform(Options{Fix: true, ExtendYearRanges: true}); err != nil {
+		t.Fatal(err)
+	}
+	wants := map[string]string{
+		"a.go": fmt.Sprintf("// Copyright 2017-%d ACME. All Rights Reserved.\n// Copyright 2015-%d Bob. All Rights Reserved.\n\n", thisYear, thisYear) +
+			testSource,
+		"current.go": current + testSource,
+		"body.go":    fmt.Sprintf("// Copyright 2017-%d ACME. All Rights Reserved.\n\n", thisYear) + testSource + "\n// Copyright 2012 Vendored.\n",
+	}
+	for path, want := range wants {
+		if got := tr.read(path); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", path, got, want)
+		}
+	}
+}
+
+func TestRepoRelPath(t *testing.T) {
+	root := filepath.Join("repo", "root")
+	got, err := repoRelPath(root, filepath.Join(root, "sub", "deep", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sub/deep/a.go"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUncleanRepoPath(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{
+		"sub/.conform-holder": "Sub Corp\n",
+		"sub/deep/a.go":       testSource,
+	})
+	cacheDir, cleanupCache := tempDir(t)
+	defer cleanupCache()
+	cacheFile := filepath.Join(cacheDir, "cache.json")
+
+	opts := Options{RepoPath: tr.dir + string(filepath.Separator), Fix: true, BlameCacheFile: cacheFile}
+	if _, err := Conform(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tr.read("sub/deep/a.go"), "// Copyright 2018 Sub Corp. All Rights Reserved.\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%s\nwant it to start with\n%s", got, want)
+	}
+	var data blameCacheData
+	if err := json.Unmarshal([]byte(readFile(t, cacheDir, "cache.json")), &data); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := data.Files["sub/deep/a.go"]; !ok {
+		t.Errorf("got %v cached, want sub/deep/a.go", data.Files)
+	}
+}
+
+func TestCheckSkipsBlame(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	rep, err := tr.conform(Options{Check: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr := rep.Files[0]; fr.Status != StatusMissing || fr.Year != 0 {
+		t.Errorf("got status %q year %d, want %q with no year", fr.Status, fr.Year, StatusMissing)
+	}
+	if _, err := tr.conform(Options{Check: true, Fix: true}); err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Errorf("got error %v, want one saying they cannot be combined", err)
+	}
+}
+
+func TestHolderSuffix(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	plain := renderTestHeader(t, shortApache2Point0Templ, 2015, "ACME") + testSource
+	tr.commit("Alice", inYear(2016), map[string]string{"new.go": testSource, "plain.go": plain})
+	opts := Options{Fix: true, RestampHolder: true, HolderSuffix: ", Inc."}
+	if _, err := tr.conform(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tr.read("new.go"), "// Copyright 2016 ACME, Inc. All Rights Reserved.\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("new.go: got\n%s\nwant it to start with\n%s", got, want)
+	}
+	// Holders are compared without the suffix.
+	if got := tr.read("plain.go"); got != plain {
+		t.Errorf("plain.go: got\n%s\nwant it untouched", got)
+	}
+
+	// The suffix is kept as given by templates that do
+	// not end the holder with a period of their own.
+	spdxMIT, _ := SPDXTemplate(shortMITTempl)
+	tests := []struct {
+		name  string
+		tmpl  *template.Template
+		files []string
+		want  string
+	}{
+		{name: "MIT", tmpl: shortMITTempl, files: []string{"new.go", "other.go"}, want: "// Copyright (c) 2016 ACME, Inc.\n"},
+		{name: "BSD", tmpl: shortBSDTempl, files: []string{"new.go", "other.go"}, want: "// Copyright 2016 ACME, Inc. All rights reserved.\n"},
+		// SPDX lines are never restamped.
+		{name: "SPDX", tmpl: spdxMIT, files: []string{"new.go"}, want: "// SPDX-FileCopyrightText: 2016 ACME, Inc.\n"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		other := renderTestHeader(t, tt.tmpl, 2016, "Someone Else") + testSource
+		tr.commit("Alice", inYear(2016), map[string]string{"new.go": testSource, "other.go": other})
+		opts := Options{Fix: true, RestampHolder: true, HolderSuffix: ", Inc.", Template: tt.tmpl}
+		for i := 0; i < 2; i++ {
+			rep, err := tr.conform(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, relPath := range tt.files {
+				if got := tr.read(relPath); !strings.HasPrefix(got, tt.want) {
+					t.Errorf("%s: run %d: %s: got\n%s\nwant it to start with\n%s", tt.name, i+1, relPath, got, tt.want)
+				}
+			}
+			if i == 1 && rep.Conforming != 2 {
+				t.Errorf("%s: got %d conforming on the second run, want 2", tt.name, rep.Conforming)
+			}
+			tr.commit("Alice", inYear(2017), map[string]string{"new.go": tr.read("new.go"), "other.go": tr.read("other.go")})
+		}
+	}
+}
+
+func TestHolderInBody(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	// The header names no holder, the code below it does.
+	notice := "// Licensed under the Apache License, Version 2.0 (the \"License\");\n\npackage a\n\n"
+	files := map[string]string{
+		"other.go":       notice + "// Copyright 2012 Someone Else. Used with permission.\nvar x = 1\n",
+		"placeholder.go": notice + "// Copyright 2012 YOUR_COMPANY\nvar x = 1\n",
+	}
+	tr.commit("Alice", inYear(2016), files)
+	rep, err := tr.conform(Options{Fix: true, RestampHolder: true, FlagPlaceholders: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fr := range rep.Files {
+		if fr.Err != nil || fr.Status != StatusConforming {
+			t.Errorf("%s: got status %q error %v, want %q", fr.Path, fr.Status, fr.Err, StatusConforming)
+		}
+	}
+	for relPath, want := range files {
+		if got := tr.read(relPath); got != want {
+			t.Errorf("%s: got\n%s\nwant it untouched", relPath, got)
+		}
+	}
+}
+
+func TestRepairTruncatedHeader(t *testing.T) {
+	full := renderTestHeader(t, shortApache2Point0Templ, 2014, "Old Corp")
+	cut := full[:strings.Index(full, "// Unless required")] + "\n"
+	var stacked string
+	for i := 0; i < 20; i++ {
+		stacked += fmt.Sprintf("// Copyright 2014 Holder %d. All Rights Reserved.\n", i)
+	}
+	stacked += full[strings.Index(full, "//\n"):]
+	files := map[string]string{"cut.go": cut + testSource, "stacked.go": stacked + testSource, "whole.go": full + testSource}
+
+	for _, check := range []bool{true, false} {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2016), files)
+		rep, err := tr.conform(Options{Fix: !check, Check: check})
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses := tr.statuses(rep)
+		wantStatus := StatusAdded
+		if check {
+			wantStatus = StatusMissing
+		}
+		if got := statuses["cut.go"]; got != wantStatus {
+			t.Errorf("check=%v: cut.go: got status %q, want %q", check, got, wantStatus)
+		}
+		for _, path := range []string{"stacked.go", "whole.go"} {
+			if got := statuses[path]; got != StatusConforming {
+				t.Errorf("check=%v: %s: got status %q, want %q", check, path, got, StatusConforming)
+			}
+		}
+		if !check {
+			if got := tr.read("cut.go"); got != full+testSource {
+				t.Errorf("cut.go: got\n%s\nwant\n%s", got, full+testSource)
+			}
+		}
+		for _, path := range []string{"stacked.go", "whole.go"} {
+			if got := tr.read(path); got != files[path] {
+				t.Errorf("check=%v: %s: got\n%s\nwant it untouched", check, path, got)
+			}
+		}
+	}
+
+	// Other licenses and comment styles are repaired alike.
+	tests := []struct {
+		name    string
+		tmpl    *template.Template
+		relPath string
+		style   *CommentStyle
+		cutAt   string
+	}{
+		{name: "MIT", tmpl: shortMITTempl, relPath: "a.go", style: slashComments, cutAt: "// The above copyright"},
+		{name: "GPL 3.0", tmpl: shortGPL3Templ, relPath: "a.go", style: slashComments, cutAt: "// You should have"},
+		{name: "MPL 2.0", tmpl: shortMPL2Templ, relPath: "a.go", style: slashComments, cutAt: "// file, You can"},
+		{name: "Apache 2.0 in shell", tmpl: shortApache2Point0Templ, relPath: "a.sh", style: hashComments, cutAt: "# Unless required"},
+	}
+	for _, tt := range tests {
+		info := newCopyright(2014, []string{"Old Corp"}, chainHolderFilters())
+		rendered, err := renderHeader(tt.tmpl, info, tt.style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full := string(rendered)
+		cut := full[:strings.Index(full, tt.cutAt)] + "\n"
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2016), map[string]string{tt.relPath: cut + "x = 1\n"})
+		opts := Options{Template: tt.tmpl, Languages: []string{"go", "shell"}}
+		rep, err := tr.conform(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.statuses(rep)[tt.relPath]; got != StatusMissing {
+			t.Errorf("%s: got status %q, want %q", tt.name, got, StatusMissing)
+		}
+		opts.Fix = true
+		if _, err := tr.conform(opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.read(tt.relPath); got != full+"x = 1\n" {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, full+"x = 1\n")
+		}
+	}
+}
+
+func TestSniffBytes(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	// A license below this much code is past the default sniff.
+	licensed := "package a\n\n" + strings.Repeat("var x = 1\n", 70) + "\n// All Rights Reserved.\n"
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": licensed})
+
+	if _, err := tr.conform(Options{SniffBytes: 100}); err == nil || !strings.Contains(err.Error(), "smaller than the") {
+		t.Errorf("got error %v, want one saying the sniff is too small", err)
+	}
+	for _, tt := range []struct {
+		sniffBytes uint
+		want       string
+	}{{sniffBytes: 0, want: StatusMissing}, {sniffBytes: 4096, want: StatusConforming}} {
+		rep, err := tr.conform(Options{SniffBytes: tt.sniffBytes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.statuses(rep)["a.go"]; got != tt.want {
+			t.Errorf("sniff bytes %d: got status %q, want %q", tt.sniffBytes, got, tt.want)
+		}
+	}
+}
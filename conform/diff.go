@@ -0,0 +1,273 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// diffContextLines is the number of unchanged lines
+// shown around each change, matching `diff -u`.
+const diffContextLines = 3
+
+// unifiedDiff returns a git style unified diff that turns before into
+// after for the file at the repo relative path relPath, or nil if the
+// contents are identical. Since header edits are localized, everything
+// between the longest common prefix and suffix of lines is reported as
+// replaced, unless minimal is set in which case only the lines that
+// actually differ within that region are shown.
+func unifiedDiff(relPath string, before, after []byte, minimal bool) []byte {
+	ops := diffLines(splitLines(before), splitLines(after), minimal)
+	hunks := groupHunks(ops)
+	if len(hunks) == 0 {
+		return nil
+	}
+
+	relPath = filepath.ToSlash(relPath)
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "diff --git a/%s b/%s\n", relPath, relPath)
+	fmt.Fprintf(buf, "--- a/%s\n+++ b/%s\n", relPath, relPath)
+	for _, h := range hunks {
+		fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(h.aStart, h.aCount), hunkRange(h.bStart, h.bCount))
+		for _, op := range ops[h.from:h.to] {
+			writeDiffLine(buf, op.kind, op.line)
+		}
+	}
+	return buf.Bytes()
+}
+
+// newFileDiff returns a git style diff that creates relPath with contents.
+func newFileDiff(relPath string, contents []byte) []byte {
+	lines := splitLines(contents)
+	relPath = filepath.ToSlash(relPath)
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "diff --git a/%s b/%s\nnew file mode 100644\n", relPath, relPath)
+	fmt.Fprintf(buf, "--- /dev/null\n+++ b/%s\n", relPath)
+	fmt.Fprintf(buf, "@@ -0,0 +%s @@\n", hunkRange(0, len(lines)))
+	for _, line := range lines {
+		writeDiffLine(buf, '+', line)
+	}
+	return buf.Bytes()
+}
+
+// diffOp is one line of an edit script, kind is one of ' ', '-' or '+'.
+type diffOp struct {
+	kind byte
+	line []byte
+}
+
+// maxMinimalDiffCells bounds the size of the table used
+// to find the fewest changed lines in minimal mode.
+const maxMinimalDiffCells = 1 << 22
+
+func diffLines(a, b [][]byte, minimal bool) []*diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && bytes.Equal(a[prefix], b[prefix]) {
+		prefix += 1
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		bytes.Equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix += 1
+	}
+
+	var ops []*diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, &diffOp{kind: ' ', line: line})
+	}
+	aMid, bMid := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if minimal && (len(aMid)+1)*(len(bMid)+1) <= maxMinimalDiffCells {
+		ops = append(ops, lcsDiff(aMid, bMid)...)
+	} else {
+		for _, line := range aMid {
+			ops = append(ops, &diffOp{kind: '-', line: line})
+		}
+		for _, line := range bMid {
+			ops = append(ops, &diffOp{kind: '+', line: line})
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, &diffOp{kind: ' ', line: line})
+	}
+	return ops
+}
+
+// lcsDiff returns the edit script from a to b that keeps
+// their longest common subsequence of lines unchanged.
+func lcsDiff(a, b [][]byte) []*diffOp {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if bytes.Equal(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []*diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case bytes.Equal(a[i], b[j]):
+			ops = append(ops, &diffOp{kind: ' ', line: a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, &diffOp{kind: '-', line: a[i]})
+			i += 1
+		default:
+			ops = append(ops, &diffOp{kind: '+', line: b[j]})
+			j += 1
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, &diffOp{kind: '-', line: a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, &diffOp{kind: '+', line: b[j]})
+	}
+	return ops
+}
+
+type hunk struct {
+	// from and to delimit the hunk's ops.
+	from, to int
+
+	aStart, aCount int
+	bStart, bCount int
+}
+
+// groupHunks splits ops into hunks made of every change plus up to
+// diffContextLines unchanged lines around it, merging hunks that touch.
+func groupHunks(ops []*diffOp) []*hunk {
+	// distance[i] is how many ops separate ops[i] from the nearest
+	// change, or more than diffContextLines if there is none.
+	distance := make([]int, len(ops))
+	last := -1
+	for i, op := range ops {
+		if op.kind != ' ' {
+			last = i
+		}
+		distance[i] = diffContextLines + 1
+		if last >= 0 {
+			distance[i] = i - last
+		}
+	}
+	last = -1
+	for i := len(ops) - 1; i >= 0; i-- {
+		if ops[i].kind != ' ' {
+			last = i
+		}
+		if last >= 0 && last-i < distance[i] {
+			distance[i] = last - i
+		}
+	}
+
+	var hunks []*hunk
+	var cur *hunk
+	aLine, bLine := 0, 0
+	for i, op := range ops {
+		if distance[i] <= diffContextLines {
+			if cur == nil {
+				cur = &hunk{from: i, aStart: aLine, bStart: bLine}
+				hunks = append(hunks, cur)
+			}
+			cur.to = i + 1
+			if op.kind != '+' {
+				cur.aCount += 1
+			}
+			if op.kind != '-' {
+				cur.bCount += 1
+			}
+		} else {
+			cur = nil
+		}
+		if op.kind != '+' {
+			aLine += 1
+		}
+		if op.kind != '-' {
+			bLine += 1
+		}
+	}
+	return hunks
+}
+
+// hunkRange formats the 1-based "start,count" of a hunk. An empty
+// range is reported as starting at the line before it, as diff does.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeDiffLine(buf *bytes.Buffer, op byte, line []byte) {
+	buf.WriteByte(op)
+	buf.Write(line)
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		buf.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// splitLines splits b after every newline, keeping the newlines.
+func splitLines(b []byte) [][]byte {
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	if last := len(lines) - 1; len(lines[last]) == 0 {
+		lines = lines[:last]
+	}
+	return lines
+}
+
+// writePatch writes diffs ordered by path to a single patch file
+// suitable for `git apply`.
+func writePatch(patchPath string, diffs map[string][]byte) error {
+	f, err := os.Create(patchPath)
+	if err != nil {
+		return err
+	}
+	if err := writeDiffs(f, diffs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeDiffs writes diffs to w ordered by path.
+func writeDiffs(w io.Writer, diffs map[string][]byte) error {
+	paths := make([]string, 0, len(diffs))
+	for path := range diffs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if _, err := w.Write(diffs[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const testHeader = "// Copyright 2018 ACME. All Rights Reserved.\n\n"
+
+func TestUnifiedDiffApplies(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is needed to apply the patch")
+	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		relPath       string
+		before, after string
+	}{
+		{relPath: "a.go", before: "package a\n\nfunc A() {}\n", after: testHeader + "package a\n\nfunc A() {}\n"},
+		{relPath: "sub/b.go", before: "//go:build linux\n\npackage sub\n", after: "//go:build linux\n\n" + testHeader + "package sub\n"},
+		{relPath: "sub/noeol.go", before: "package sub", after: testHeader + "package sub"},
+		{relPath: "empty.go", before: "", after: testHeader},
+	}
+	before := make(map[string]string)
+	diffs := make(map[string][]byte)
+	for _, tt := range tests {
+		before[tt.relPath] = tt.before
+		diffs[tt.relPath] = unifiedDiff(tt.relPath, []byte(tt.before), []byte(tt.after), false)
+	}
+	writeFiles(t, dir, before)
+	patchPath := filepath.Join(dir, "changes.diff")
+	if err := writePatch(patchPath, diffs); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(gitPath, "apply", patchPath)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply failed: %v\n%s", err, out)
+	}
+	for _, tt := range tests {
+		if got := readFile(t, dir, tt.relPath); got != tt.after {
+			t.Errorf("%s: the patch made %q, want %q", tt.relPath, got, tt.after)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name    string
+		after   string
+		minimal bool
+		want    string
+	}{
+		{name: "unchanged", after: before, want: ""},
+		{
+			name:  "one line",
+			after: "a\nB\nc\nd\ne\n",
+			want:  "@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n",
+		},
+		{
+			name:  "two lines",
+			after: "a\nB\nc\nD\ne\n",
+			want:  "@@ -1,5 +1,5 @@\n a\n-b\n-c\n-d\n+B\n+c\n+D\n e\n",
+		},
+		{
+			name:    "two lines minimal",
+			after:   "a\nB\nc\nD\ne\n",
+			minimal: true,
+			want:    "@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n-d\n+D\n e\n",
+		},
+		{
+			name:  "inserted at the top",
+			after: "x\n\na\nb\nc\nd\ne\n",
+			want:  "@@ -1,3 +1,5 @@\n+x\n+\n a\n b\n c\n",
+		},
+		{
+			name:  "no newline at the end",
+			after: "a\nb\nc\nd\ne",
+			want:  "@@ -2,4 +2,4 @@\n b\n c\n d\n-e\n+e\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		got := string(unifiedDiff("sub/x.go", []byte(before), []byte(tt.after), tt.minimal))
+		want := tt.want
+		if want != "" {
+			want = "diff --git a/sub/x.go b/sub/x.go\n--- a/sub/x.go\n+++ b/sub/x.go\n" + want
+		}
+		if got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+func TestUnifiedDiffUnchanged(t *testing.T) {
+	if diff := unifiedDiff("a.go", []byte("package a\n"), []byte("package a\n"), false); diff != nil {
+		t.Errorf("got diff %q for identical contents", diff)
+	}
+}
+
+func TestNewFileDiffApplies(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is needed to apply the patch")
+	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	sidecar := "SPDX-FileCopyrightText: 2018 ACME\n\nSPDX-License-Identifier: Apache-2.0\n"
+	diffs := map[string][]byte{"img/logo.png.license": newFileDiff(filepath.Join("img", "logo.png.license"), []byte(sidecar))}
+	patchPath := filepath.Join(dir, "changes.diff")
+	if err := writePatch(patchPath, diffs); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(gitPath, "apply", patchPath)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply failed: %v\n%s", err, out)
+	}
+	if got := readFile(t, dir, "img/logo.png.license"); got != sidecar {
+		t.Errorf("the patch made %q, want %q", got, sidecar)
+	}
+}
+
+func TestWriteDiffs(t *testing.T) {
+	diffs := map[string][]byte{
+		"sub/b.go": unifiedDiff("sub/b.go", []byte("package sub\n"), []byte(testHeader+"package sub\n"), false),
+		"a.go":     unifiedDiff("a.go", []byte("package a\n"), []byte(testHeader+"package a\n"), false),
+	}
+	var buf bytes.Buffer
+	if err := writeDiffs(&buf, diffs); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), string(diffs["a.go"])+string(diffs["sub/b.go"]); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	encodingUTF8   = "utf-8"
+	encodingLatin1 = "latin1"
+)
+
+var errNotUTF8 = errors.New("not valid UTF-8, rerun with -output-encoding=latin1 if it is Latin-1 encoded")
+
+// parseEncoding canonicalizes the -output-encoding flag's value.
+func parseEncoding(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "utf-8", "utf8":
+		return encodingUTF8, nil
+	case "latin1", "latin-1", "iso-8859-1":
+		return encodingLatin1, nil
+	default:
+		return "", fmt.Errorf("unsupported output encoding %q, options are: utf-8, latin1", name)
+	}
+}
+
+// encodeHeader re-encodes a rendered UTF-8 header for a file
+// that is not valid UTF-8 and is declared to use encoding.
+func encodeHeader(header []byte, encoding string) ([]byte, error) {
+	if encoding != encodingLatin1 {
+		return nil, errNotUTF8
+	}
+	encoded := make([]byte, 0, len(header))
+	for len(header) > 0 {
+		r, size := utf8.DecodeRune(header)
+		if r > 0xFF {
+			return nil, fmt.Errorf("header character %q cannot be represented in Latin-1", r)
+		}
+		encoded = append(encoded, byte(r))
+		header = header[size:]
+	}
+	return encoded, nil
+}
+
+// skipNotUTF8 leaves alone a file that is not valid UTF-8 when
+// no other encoding was given, saying why it was skipped.
+func (lc *licenseConformer) skipNotUTF8(goFile string) *conformResult {
+	relPath, _ := repoRelPath(lc.dirPath, goFile)
+	lc.logf("skipping %q: %v", relPath, errNotUTF8)
+	return &conformResult{status: StatusSkipped}
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "", want: encodingUTF8},
+		{name: "UTF8", want: encodingUTF8},
+		{name: "Latin-1", want: encodingLatin1},
+		{name: "ISO-8859-1", want: encodingLatin1},
+		{name: "shift-jis", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEncoding(tt.name)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseEncoding(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeHeader(t *testing.T) {
+	tests := []struct {
+		header   string
+		encoding string
+		want     string
+		wantErr  bool
+	}{
+		{header: "// Copyright 2015 Société Générale.\n", encoding: encodingLatin1, want: "// Copyright 2015 Soci\xe9t\xe9 G\xe9n\xe9rale.\n"},
+		{header: "// Copyright 2015 ACME ☃.\n", encoding: encodingLatin1, wantErr: true},
+		{header: "// Copyright 2015 ACME.\n", encoding: encodingUTF8, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := encodeHeader([]byte(tt.header), tt.encoding)
+		if string(got) != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("encodeHeader(%q, %s) = %q, %v, want %q", tt.header, tt.encoding, got, err, tt.want)
+		}
+	}
+}
+
+func TestLatin1Files(t *testing.T) {
+	// "Café" with é as the single Latin-1 byte 0xE9.
+	latin1 := testSource + "\n// Caf\xe9\nvar x = 1\n"
+	tests := []struct {
+		name     string
+		encoding string
+		holder   string
+		// want is the header added, empty if the file is left as it is.
+		want    string
+		wantErr string
+		// wantLog is part of what is logged about the file.
+		wantLog string
+	}{
+		{name: "skipped by default", holder: "ACME", wantLog: `skipping "a.go": not valid UTF-8`},
+		{
+			name:     "latin1 header",
+			encoding: "iso-8859-1",
+			holder:   "Société Générale",
+			want:     "// Copyright 2015 Soci\xe9t\xe9 G\xe9n\xe9rale. All Rights Reserved.\n",
+		},
+		{name: "holder outside latin1", encoding: EncodingLatin1, holder: "ACME ☃", wantErr: "cannot be represented in Latin-1"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": latin1})
+		var logged []string
+		logf := func(format string, args ...interface{}) { logged = append(logged, fmt.Sprintf(format, args...)) }
+		rep, err := tr.conform(Options{Fix: true, Holders: []string{tt.holder}, Encoding: tt.encoding, Logf: logf})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := rep.Files[0].Err; (tt.wantErr == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got error %v, want one with %q", tt.name, err, tt.wantErr)
+		}
+		if tt.wantLog != "" && (rep.Files[0].Status != StatusSkipped || !strings.Contains(strings.Join(logged, "\n"), tt.wantLog)) {
+			t.Errorf("%s: got status %q and logged %q, want it skipped saying %q", tt.name, rep.Files[0].Status, logged, tt.wantLog)
+		}
+		got := tr.read("a.go")
+		switch {
+		case tt.want == "" && got != latin1:
+			t.Errorf("%s: file was changed to %q", tt.name, got)
+		case tt.want != "" && (!strings.HasPrefix(got, tt.want) || !strings.HasSuffix(got, latin1)):
+			t.Errorf("%s: got %q, want it to start with %q and end with the original", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// pathFilter selects files by glob patterns matched against their
+// slash separated path relative to the repo's root. A file is selected
+// if it matches any of include, or include is empty, and none of exclude.
+type pathFilter struct {
+	include, exclude []string
+}
+
+// newPathFilter checks that every pattern is well formed.
+func newPathFilter(include, exclude []string) (*pathFilter, error) {
+	pf := new(pathFilter)
+	for _, set := range []struct {
+		patterns []string
+		dst      *[]string
+	}{{include, &pf.include}, {exclude, &pf.exclude}} {
+		for _, pattern := range set.patterns {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			if _, err := path.Match(strings.Replace(pattern, "**", "*", -1), ""); err != nil {
+				return nil, fmt.Errorf("bad glob %q: %v", pattern, err)
+			}
+			*set.dst = append(*set.dst, pattern)
+		}
+	}
+	return pf, nil
+}
+
+func (pf *pathFilter) selects(relPath string) bool {
+	for _, pattern := range pf.exclude {
+		if matchGlob(pattern, relPath) {
+			return false
+		}
+	}
+	if len(pf.include) == 0 {
+		return true
+	}
+	for _, pattern := range pf.include {
+		if matchGlob(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether the slash separated relPath matches pattern,
+// in which a "**" segment stands for any number of directories. Like in
+// .gitignore files, patterns without a slash match the base name only,
+// so "*_test.go" matches test files in every directory.
+func matchGlob(pattern, relPath string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(relPath))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(relPath, "/"))
+}
+
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, relPath string
+		want             bool
+	}{
+		{pattern: "*_test.go", relPath: "a_test.go", want: true},
+		{pattern: "*_test.go", relPath: "sub/deep/a_test.go", want: true},
+		{pattern: "*_test.go", relPath: "a.go", want: false},
+		{pattern: "cmd/*.go", relPath: "cmd/a.go", want: true},
+		{pattern: "cmd/*.go", relPath: "cmd/sub/a.go", want: false},
+		{pattern: "cmd/**", relPath: "cmd/sub/a.go", want: true},
+		{pattern: "cmd/**", relPath: "internal/cmd/a.go", want: false},
+		{pattern: "**/testdata/**", relPath: "testdata/a.go", want: true},
+		{pattern: "**/testdata/**", relPath: "a/b/testdata/c/d.go", want: true},
+		{pattern: "**/gen/*.go", relPath: "gen/a.go", want: true},
+		{pattern: "/vendor/**", relPath: "vendor/x/a.go", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.relPath); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.relPath, got, tt.want)
+		}
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	if _, err := newPathFilter([]string{"cmd/["}, nil); err == nil || !strings.Contains(err.Error(), `bad glob "cmd/["`) {
+		t.Errorf("got error %v, want one about the bad glob", err)
+	}
+
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{
+		"a.go":          testSource,
+		"cmd/b.go":      testSource,
+		"cmd/b_test.go": testSource,
+		"cmd/sub/c.go":  testSource,
+		"internal/d.go": testSource,
+	})
+	tests := []struct {
+		include, exclude []string
+		want             []string
+	}{
+		{include: []string{""}, exclude: []string{""}, want: []string{"a.go", "cmd/b.go", "cmd/b_test.go", "cmd/sub/c.go", "internal/d.go"}},
+		{include: []string{"cmd/**"}, want: []string{"cmd/b.go", "cmd/b_test.go", "cmd/sub/c.go"}},
+		{include: []string{"cmd/**", " internal/* "}, exclude: []string{"*_test.go"}, want: []string{"cmd/b.go", "cmd/sub/c.go", "internal/d.go"}},
+		{exclude: []string{"cmd/**"}, want: []string{"a.go", "internal/d.go"}},
+	}
+	for _, tt := range tests {
+		rep, err := tr.conform(Options{Include: tt.include, Exclude: tt.exclude})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for path := range tr.statuses(rep) {
+			got = append(got, path)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("include %q exclude %q: got %q, want %q", tt.include, tt.exclude, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// fileCreationTime returns the author time of the oldest commit reachable
+// from "from" that touched relPath, which unlike blame also accounts for
+// the history of lines that have since been rewritten or deleted. Merge
+// commits are passed over if skipMerges is set.
+func fileCreationTime(repo *git.Repository, from plumbing.Hash, relPath string, skipMerges bool) (time.Time, error) {
+	c, err := fileCreationCommit(repo, from, relPath, skipMerges)
+	if err != nil || c == nil {
+		return blankTime, err
+	}
+	return c.Author.When, nil
+}
+
+// fileCreationCommit returns the oldest commit by author time
+// reachable from "from" that touched relPath, or nil if none did.
+func fileCreationCommit(repo *git.Repository, from plumbing.Hash, relPath string, skipMerges bool) (*object.Commit, error) {
+	iter, err := repo.Log(&git.LogOptions{From: from, FileName: &relPath})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var created *object.Commit
+	err = iter.ForEach(func(c *object.Commit) error {
+		if skipMerges && c.NumParents() > 1 {
+			return nil
+		}
+		if created == nil || c.Author.When.Before(created.Author.When) {
+			created = c
+		}
+		return nil
+	})
+	if err == io.EOF {
+		// Some go-git releases surface the end of a
+		// path filtered log as io.EOF from ForEach.
+		err = nil
+	}
+	return created, err
+}
+
+// isMergeCommit reports whether hash names a commit with more than
+// one parent, memoizing answers in seen. Commits that cannot be read
+// are not considered merges.
+func isMergeCommit(repo *git.Repository, hash plumbing.Hash, seen map[plumbing.Hash]bool) bool {
+	if merge, ok := seen[hash]; ok {
+		return merge
+	}
+	c, err := repo.CommitObject(hash)
+	merge := err == nil && c.NumParents() > 1
+	seen[hash] = merge
+	return merge
+}
+
+// blameCredit is the author and year of a line of a blame.
+type blameCredit struct {
+	author string
+	year   int
+}
+
+// blameCredits names the author of the commit that introduced each of
+// lines as mm spells it, falling back to their email. Lines for which
+// skip reports true are passed over.
+func blameCredits(repo *git.Repository, lines []*git.Line, skip func(plumbing.Hash) bool, mm *mailmap) ([]*blameCredit, error) {
+	names := make(map[plumbing.Hash]string)
+	var credits []*blameCredit
+	for _, line := range lines {
+		if skip(line.Hash) {
+			continue
+		}
+		name, ok := names[line.Hash]
+		if !ok {
+			c, err := repo.CommitObject(line.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if name = mm.name(c.Author.Name, c.Author.Email); name == "" {
+				name = c.Author.Email
+			}
+			names[line.Hash] = name
+		}
+		credits = append(credits, &blameCredit{author: name, year: line.Date.Year()})
+	}
+	return credits, nil
+}
+
+// earliestBlameAuthor names the author of the earliest commit among
+// lines as mm spells it. It returns "" if that commit names no author
+// or if commits of different authors tie for the earliest. Lines for
+// which skip reports true are passed over.
+func earliestBlameAuthor(repo *git.Repository, lines []*git.Line, skip func(plumbing.Hash) bool, mm *mailmap) (string, error) {
+	var earliest time.Time
+	var authors map[string]bool
+	seen := make(map[plumbing.Hash]bool)
+	for _, line := range lines {
+		if seen[line.Hash] || skip(line.Hash) {
+			continue
+		}
+		seen[line.Hash] = true
+		switch {
+		case authors == nil || line.Date.Before(earliest):
+			earliest, authors = line.Date, make(map[string]bool)
+		case !line.Date.Equal(earliest):
+			continue
+		}
+		c, err := repo.CommitObject(line.Hash)
+		if err != nil {
+			return "", err
+		}
+		authors[mm.name(c.Author.Name, c.Author.Email)] = true
+	}
+	if len(authors) != 1 {
+		return "", nil
+	}
+	for author := range authors {
+		return author, nil
+	}
+	return "", nil
+}
+
+// authorSpan is the range of years over which
+// an author's commits survive in a file's blame.
+type authorSpan struct {
+	author string
+	years  yearSpan
+}
+
+// latestYear returns the most recent year among credits.
+func latestYear(credits []*blameCredit) int {
+	latest := 0
+	for _, credit := range credits {
+		if credit.year > latest {
+			latest = credit.year
+		}
+	}
+	return latest
+}
+
+// authorSpans aggregates credits by author, ordered by first year and then by name.
+func authorSpans(credits []*blameCredit) []*authorSpan {
+	byAuthor := make(map[string]*authorSpan)
+	for _, credit := range credits {
+		span, ok := byAuthor[credit.author]
+		if !ok {
+			span = &authorSpan{author: credit.author, years: yearSpan{First: credit.year, Last: credit.year}}
+			byAuthor[credit.author] = span
+		}
+		if credit.year < span.years.First {
+			span.years.First = credit.year
+		}
+		if credit.year > span.years.Last {
+			span.years.Last = credit.year
+		}
+	}
+	spans := make([]*authorSpan, 0, len(byAuthor))
+	for _, span := range byAuthor {
+		spans = append(spans, span)
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].years.First != spans[j].years.First {
+			return spans[i].years.First < spans[j].years.First
+		}
+		return spans[i].author < spans[j].author
+	})
+	return spans
+}
+
+// yearAuthors is the set of authors whose
+// commits from a year survive in a file's blame.
+type yearAuthors struct {
+	year    int
+	authors []string
+}
+
+// authorsByYear groups credits by year, in order,
+// listing the authors of each year by name.
+func authorsByYear(credits []*blameCredit) []*yearAuthors {
+	byYear := make(map[int]map[string]bool)
+	for _, credit := range credits {
+		if byYear[credit.year] == nil {
+			byYear[credit.year] = make(map[string]bool)
+		}
+		byYear[credit.year][credit.author] = true
+	}
+	years := make([]*yearAuthors, 0, len(byYear))
+	for year, authors := range byYear {
+		ya := &yearAuthors{year: year}
+		for author := range authors {
+			ya.authors = append(ya.authors, author)
+		}
+		sort.Strings(ya.authors)
+		years = append(years, ya)
+	}
+	sort.Slice(years, func(i, j int) bool { return years[i].year < years[j].year })
+	return years
+}
+
+// filesChangedSinceTag returns the slash separated, repo relative paths
+// of files that were added or modified between tag and head.
+func filesChangedSinceTag(repo *git.Repository, tag string, head *object.Commit) (map[string]bool, error) {
+	ref, err := repo.Reference(plumbing.NewTagReferenceName(tag), true)
+	if err != nil {
+		return nil, fmt.Errorf("tag %q: %v", tag, err)
+	}
+	// Annotated tags point at a tag object rather than at the commit.
+	var tagCommit *object.Commit
+	if tagObj, err := repo.TagObject(ref.Hash()); err == nil {
+		tagCommit, err = tagObj.Commit()
+		if err != nil {
+			return nil, err
+		}
+	} else if tagCommit, err = repo.CommitObject(ref.Hash()); err != nil {
+		return nil, err
+	}
+
+	fromTree, err := tagCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]bool)
+	for _, change := range changes {
+		// Deletions have no destination and there is nothing to stamp.
+		if name := change.To.Name; name != "" {
+			changed[name] = true
+		}
+	}
+	return changed, nil
+}
+
+// uncommittedFiles returns the sorted paths of tracked files in the
+// worktree that have staged or unstaged changes. Untracked files are
+// ignored since stamping never touches them.
+func uncommittedFiles(repo *git.Repository) ([]string, error) {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+	var dirty []string
+	for path, fs := range status {
+		if fs.Staging == git.Untracked && fs.Worktree == git.Untracked {
+			continue
+		}
+		if fs.Staging != git.Unmodified || fs.Worktree != git.Unmodified {
+			dirty = append(dirty, path)
+		}
+	}
+	sort.Strings(dirty)
+	return dirty, nil
+}
+
+// gitignoredPaths returns a func that reports whether a path under root,
+// the worktree of a repo, is excluded by .git/info/exclude or by the
+// .gitignore files of the directories above it, nested ones applying to
+// their own directory. Those files are read as the walk reaches their
+// directory, so that ignored directories are never read. The func is
+// only meant for the single goroutine of a walk.
+func gitignoredPaths(root string) (func(string, os.FileInfo) bool, error) {
+	patterns, err := readIgnorePatterns(filepath.Join(root, ".git", "info", "exclude"), nil)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]bool)
+	return func(path string, fi os.FileInfo) bool {
+		relPath, err := repoRelPath(root, path)
+		if err != nil || relPath == "." {
+			return false
+		}
+		parts := strings.Split(relPath, "/")
+		for i := range parts {
+			if key := strings.Join(parts[:i], "/"); !loaded[key] {
+				loaded[key] = true
+				ignoreFile := filepath.Join(root, filepath.FromSlash(key), ".gitignore")
+				// An unreadable .gitignore excludes nothing.
+				if ps, err := readIgnorePatterns(ignoreFile, parts[:i]); err == nil {
+					patterns = append(patterns, ps...)
+				}
+			}
+		}
+		return gitignore.NewMatcher(patterns).Match(parts, fi.IsDir())
+	}, nil
+}
+
+// readIgnorePatterns parses the gitignore patterns of the file at path,
+// which apply below domain. A missing file has none.
+func readIgnorePatterns(path string, domain []string) ([]gitignore.Pattern, error) {
+	blob, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var patterns []gitignore.Pattern
+	for _, line := range strings.Split(string(blob), "\n") {
+		if line = strings.TrimRight(line, "\r"); !strings.HasPrefix(line, "#") && strings.TrimSpace(line) != "" {
+			patterns = append(patterns, gitignore.ParsePattern(line, domain))
+		}
+	}
+	return patterns, nil
+}
+
+// authorsFileNames are the files at the root of a repo that
+// list who holds its copyright, in the order they are consulted.
+var authorsFileNames = []string{"AUTHORS", "CONTRIBUTORS"}
+
+// gitHolder infers the copyright holder of the repo at root from the
+// user.name of its git config, or else from the first entry of its
+// AUTHORS or CONTRIBUTORS file without the email. It returns "" if
+// neither names anyone. A nil repo only has the files consulted.
+func gitHolder(repo *git.Repository, root string) (string, error) {
+	if repo != nil {
+		cfg, err := repo.Config()
+		if err != nil {
+			return "", err
+		}
+		if name := strings.TrimSpace(cfg.Raw.Section("user").Option("name")); name != "" {
+			return name, nil
+		}
+	}
+	for _, name := range authorsFileNames {
+		blob, err := ioutil.ReadFile(filepath.Join(root, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(blob))
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if lt := strings.IndexByte(line, '<'); lt >= 0 {
+				line = strings.TrimSpace(line[:lt])
+			}
+			if line != "" {
+				return line, nil
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,580 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestFilesChangedSinceTag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tagged := tr.commit("Alice", inYear(2015), map[string]string{
+		"kept.go":     "package a\n",
+		"modified.go": "package a\n",
+	})
+	if _, err := tr.repo.CreateTag("v1", tagged, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.repo.CreateTag("v1-annotated", tagged, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "Alice", Email: "alice@example.com", When: inYear(2015)},
+		Message: "Release v1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tr.commit("Bob", inYear(2016), map[string]string{
+		"modified.go": "package a\n\nvar x = 1\n",
+		"sub/new.go":  "package sub\n",
+	})
+
+	want := map[string]bool{"modified.go": true, "sub/new.go": true}
+	for _, tag := range []string{"v1", "v1-annotated"} {
+		got, err := filesChangedSinceTag(tr.repo, tag, tr.head())
+		if err != nil {
+			t.Fatalf("%s: %v", tag, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tag, got, want)
+		}
+	}
+	if _, err := filesChangedSinceTag(tr.repo, "v2", tr.head()); err == nil {
+		t.Error("got no error for a missing tag")
+	}
+}
+
+func TestSkipMerges(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	base := tr.commit("Jane Doe", inYear(2018), map[string]string{"a.go": testSource})
+	side := tr.commit("John Roe", inYear(2018), map[string]string{"b.go": testSource})
+	// A merge dated before the work it merges, as happens when
+	// the merging clone has its clock set wrong.
+	merge := tr.merge("Jane Doe", inYear(2015), map[string]string{"a.go": testSource + "\nvar y = 2\n"}, side, base)
+
+	merges := make(map[plumbing.Hash]bool)
+	for _, hash := range []plumbing.Hash{base, side} {
+		if isMergeCommit(tr.repo, hash, merges) {
+			t.Errorf("%s: got a merge, want none", hash)
+		}
+	}
+	if !isMergeCommit(tr.repo, merge, merges) {
+		t.Errorf("%s: got no merge, want one", merge)
+	}
+
+	tests := []struct {
+		skipMerges bool
+		wantYear   int
+	}{
+		{skipMerges: false, wantYear: 2015},
+		{skipMerges: true, wantYear: 2018},
+	}
+	for _, tt := range tests {
+		created, err := fileCreationTime(tr.repo, merge, "a.go", tt.skipMerges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.Year() != tt.wantYear {
+			t.Errorf("skipMerges=%v: got created in %d, want %d", tt.skipMerges, created.Year(), tt.wantYear)
+		}
+	}
+}
+
+func TestYearSpan(t *testing.T) {
+	tests := []struct {
+		span yearSpan
+		want string
+	}{
+		{span: yearSpan{First: 2017}, want: "2017"},
+		{span: yearSpan{First: 2017, Last: 2017}, want: "2017"},
+		{span: yearSpan{First: 2017, Last: 2019}, want: "2017-2019"},
+	}
+	for _, tt := range tests {
+		if got := tt.span.String(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.span, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorSpans(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	contents := testSource
+	// Blame needs commits in the order of their dates.
+	for i, c := range []struct {
+		author string
+		year   int
+	}{{"Alice", 2017}, {"Carol", 2017}, {"Bob", 2018}, {"Alice", 2019}, {"Bob", 2021}} {
+		contents += fmt.Sprintf("var v%d = 1\n", i)
+		tr.commit(c.author, inYear(c.year).Add(time.Duration(i)*time.Hour), map[string]string{"a.go": contents})
+	}
+
+	blame, err := git.Blame(tr.head(), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credits, err := blameCredits(tr.repo, blame.Lines, func(plumbing.Hash) bool { return false }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, span := range authorSpans(credits) {
+		got = append(got, fmt.Sprintf("%s %s", span.author, span.years))
+	}
+	if want := []string{"Alice 2017-2019", "Carol 2017", "Bob 2018-2021"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got spans %q, want %q", got, want)
+	}
+
+	if _, err := tr.conform(Options{Fix: true, PerAuthorSpans: true}); err != nil {
+		t.Fatal(err)
+	}
+	stamped := tr.read("a.go")
+	var lines []string
+	for _, line := range strings.Split(stamped, "\n") {
+		if strings.HasPrefix(line, "// Copyright") {
+			lines = append(lines, line)
+		}
+	}
+	wantLines := []string{
+		"// Copyright 2017-2019 Alice. All Rights Reserved.",
+		"// Copyright 2017 Carol. All Rights Reserved.",
+		"// Copyright 2018-2021 Bob. All Rights Reserved.",
+	}
+	if !reflect.DeepEqual(lines, wantLines) {
+		t.Errorf("got\n%s\nwant the copyright lines %q", stamped, wantLines)
+	}
+}
+
+func TestAuthorsByYear(t *testing.T) {
+	credits := []*blameCredit{
+		{author: "Bob", year: 2019},
+		{author: "Alice", year: 2017},
+		{author: "Bob", year: 2017},
+		{author: "Alice", year: 2017},
+		{author: "Alice", year: 2019},
+	}
+	want := []*yearAuthors{
+		{year: 2017, authors: []string{"Alice", "Bob"}},
+		{year: 2019, authors: []string{"Alice", "Bob"}},
+	}
+	if got := authorsByYear(credits); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	contents := testSource
+	for i, c := range []struct {
+		author string
+		year   int
+	}{{"Alice", 2017}, {"Bob", 2017}, {"Bob", 2018}, {"Carol", 2020}, {"Alice", 2020}} {
+		contents += fmt.Sprintf("var v%d = 1\n", i)
+		// Blame cannot order commits made at the same time.
+		tr.commit(c.author, inYear(c.year).Add(time.Duration(i)*time.Hour), map[string]string{"a.go": contents})
+	}
+	if _, err := tr.conform(Options{Fix: true, PerYearHolders: true}); err != nil {
+		t.Fatal(err)
+	}
+	wantLines := "// Copyright 2017 Alice, Bob. All Rights Reserved.\n" +
+		"// Copyright 2018 Bob. All Rights Reserved.\n" +
+		"// Copyright 2020 Alice, Carol. All Rights Reserved.\n//\n"
+	if got := tr.read("a.go"); !strings.HasPrefix(got, wantLines) {
+		t.Errorf("got\n%s\nwant it to start with\n%s", got, wantLines)
+	}
+
+	if _, err := tr.conform(Options{PerAuthorSpans: true, PerYearHolders: true}); err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Errorf("got error %v, want one saying they cannot be combined", err)
+	}
+}
+
+func TestWrapCopyrightLines(t *testing.T) {
+	authors := []string{"Alice Anderson", "Bob Brown", "Carol Clark", "Dave Davis"}
+	tests := []struct {
+		width int
+		want  []string
+	}{
+		// "// Copyright 2019 " and ". All Rights Reserved." take 40 columns.
+		{width: 80, want: []string{"Alice Anderson, Bob Brown, Carol Clark", "Dave Davis"}},
+		{width: 78, want: []string{"Alice Anderson, Bob Brown, Carol Clark", "Dave Davis"}},
+		{width: 77, want: []string{"Alice Anderson, Bob Brown", "Carol Clark, Dave Davis"}},
+		{width: 60, want: authors},
+		// Holders that do not fit still get a line each.
+		{width: 10, want: authors},
+	}
+	for _, tt := range tests {
+		info := newYearCopyright([]*yearAuthors{{year: 2018, authors: []string{"Eve"}}, {year: 2019, authors: authors}}, func(holder string) string { return holder })
+		wrapped, err := wrapCopyrightLines(info, shortApache2Point0Templ, slashComments, tt.width)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, line := range wrapped.Lines[1:] {
+			if line.Year.String() != "2019" {
+				t.Errorf("width %d: got year %s for %q", tt.width, line.Year, line.Holder)
+			}
+			got = append(got, line.Holder)
+		}
+		if wrapped.Lines[0].Holder != "Eve" || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("width %d: got %q then %q, want Eve then %q", tt.width, wrapped.Lines[0].Holder, got, tt.want)
+		}
+	}
+}
+
+func TestHolderFromFirstAuthor(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2017), map[string]string{"a.go": testSource})
+	tr.commit("Bob", inYear(2018), map[string]string{
+		"a.go":                testSource + "\nvar y = 2\n",
+		"b.go":                testSource,
+		"sub/.conform-holder": "Sub Corp\n",
+		"sub/c.go":            testSource,
+	})
+
+	created, err := fileCreationCommit(tr.repo, tr.head().Hash, "a.go", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Author.Name != "Alice" {
+		t.Errorf("a.go: got created by %q, want Alice", created.Author.Name)
+	}
+
+	if _, err := tr.conform(Options{Fix: true, HolderFromFirstAuthor: true}); err != nil {
+		t.Fatal(err)
+	}
+	wants := map[string]string{
+		"a.go":     "// Copyright 2017 Alice. All Rights Reserved.\n",
+		"b.go":     "// Copyright 2018 Bob. All Rights Reserved.\n",
+		"sub/c.go": "// Copyright 2018 Sub Corp. All Rights Reserved.\n",
+	}
+	for path, want := range wants {
+		if got := tr.read(path); !strings.HasPrefix(got, want) {
+			t.Errorf("%s: got\n%s\nwant it to start with\n%s", path, got, want)
+		}
+	}
+}
+
+func TestYearRange(t *testing.T) {
+	custom := template.Must(template.New("custom").Parse("// Copyright {{.StartYear}} to {{.EndYear}} {{.Holder}}. All Rights Reserved.\n\n"))
+	tests := []struct {
+		name string
+		tmpl *template.Template
+		want map[string]string
+	}{
+		{
+			name: "built-in",
+			want: map[string]string{
+				"edited.go": "// Copyright 2017-2023 ACME. All Rights Reserved.\n",
+				"once.go":   "// Copyright 2017 ACME. All Rights Reserved.\n",
+			},
+		},
+		{
+			name: "custom",
+			tmpl: custom,
+			want: map[string]string{
+				"edited.go": "// Copyright 2017 to 2023 ACME. All Rights Reserved.\n",
+				"once.go":   "// Copyright 2017 to 2017 ACME. All Rights Reserved.\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2017), map[string]string{"edited.go": testSource, "once.go": testSource})
+		tr.commit("Bob", inYear(2023), map[string]string{"edited.go": testSource + "\nvar y = 2\n"})
+		if _, err := tr.conform(Options{Fix: true, YearRange: true, Template: tt.tmpl}); err != nil {
+			t.Fatal(err)
+		}
+		for path, want := range tt.want {
+			if got := tr.read(path); !strings.HasPrefix(got, want) {
+				t.Errorf("%s: %s: got\n%s\nwant it to start with\n%s", tt.name, path, got, want)
+			}
+		}
+	}
+}
+
+func TestUpdateYear(t *testing.T) {
+	single := "// Copyright 2015 ACME. All Rights Reserved.\n\n"
+	span := "// Copyright 2015-2018 ACME. All Rights Reserved.\n// Copyright 2016 Globex. All Rights Reserved.\n\n"
+	current := "// Copyright 2020 ACME. All Rights Reserved.\n\n"
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2015), map[string]string{"single.go": single + testSource, "span.go": span + testSource, "current.go": current + testSource, "old.go": single + testSource})
+	tr.commit("Bob", inYear(2020), map[string]string{
+		"single.go":  single + testSource + "\nvar y = 2\n",
+		"span.go":    span + testSource + "\nvar y = 2\n",
+		"current.go": current + testSource + "\nvar y = 2\n",
+	})
+	if _, err := tr.conform(Options{Fix: true, UpdateYear: true}); err != nil {
+		t.Fatal(err)
+	}
+	wants := map[string]string{
+		"single.go":  "// Copyright 2020 ACME. All Rights Reserved.\n\n" + testSource + "\nvar y = 2\n",
+		"span.go":    "// Copyright 2015-2020 ACME. All Rights Reserved.\n// Copyright 2020 Globex. All Rights Reserved.\n\n" + testSource + "\nvar y = 2\n",
+		"current.go": current + testSource + "\nvar y = 2\n",
+		"old.go":     single + testSource,
+	}
+	for path, want := range wants {
+		if got := tr.read(path); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", path, got, want)
+		}
+	}
+}
+
+func TestYearFromFSCreation(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	writeFiles(t, tr.dir, map[string]string{"untracked.go": testSource})
+	path := filepath.Join(tr.dir, "untracked.go")
+	if err := os.Chtimes(path, inYear(2016), inYear(2016)); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Where the OS records birth times those win over the one set above.
+	wantYear := 2016
+	if born, ok := birthTime(path, fi); ok {
+		wantYear = born.Year()
+	}
+
+	rep, err := tr.conform(Options{YearFromFSCreation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fr := range rep.Files {
+		if !strings.HasSuffix(fr.Path, "untracked.go") {
+			continue
+		}
+		if fr.Err != nil || fr.Status != StatusMissing || fr.Year != wantYear {
+			t.Errorf("got status %q year %d error %v, want %q from %d", fr.Status, fr.Year, fr.Err, StatusMissing, wantYear)
+		}
+	}
+
+	rep, err = tr.conform(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Errors != 1 {
+		t.Errorf("got %d errors without the option, want the untracked file to fail", rep.Errors)
+	}
+
+	// Without git every file, and every sidecar, is dated that way.
+	dir, cleanupDir := tempDir(t)
+	defer cleanupDir()
+	writeFiles(t, dir, map[string]string{"a.go": testSource, "logo.png": "\x89PNG\r\n\x1a\n"})
+	for _, name := range []string{"a.go", "logo.png"} {
+		if err := os.Chtimes(filepath.Join(dir, name), inYear(2016), inYear(2016)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := Options{RepoPath: dir, NoGit: true, YearFromFSCreation: true, Fix: true, Holders: []string{"ACME"}, SidecarExtensions: []string{".png"}}
+	if _, err := Conform(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, dir, "a.go"), fmt.Sprintf("// Copyright %d ACME.", wantYear); !strings.HasPrefix(got, want) {
+		t.Errorf("got a.go\n%s\nwant it to start with %q", got, want)
+	}
+	if got, want := readFile(t, dir, "logo.png.license"), fmt.Sprintf("SPDX-FileCopyrightText: %d ACME\n", wantYear); !strings.HasPrefix(got, want) {
+		t.Errorf("got sidecar\n%s\nwant it to start with %q", got, want)
+	}
+}
+
+func TestGitHolder(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	holder := func() string {
+		got, err := gitHolder(tr.repo, tr.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := holder(); got != "" {
+		t.Errorf("got %q with nothing to go by, want none", got)
+	}
+
+	writeFiles(t, tr.dir, map[string]string{"CONTRIBUTORS": "\n# Who contributed\nJohn Roe <john@example.com>\n"})
+	if got := holder(); got != "John Roe" {
+		t.Errorf("got %q, want John Roe from CONTRIBUTORS", got)
+	}
+	writeFiles(t, tr.dir, map[string]string{"AUTHORS": "# Who holds the copyright\n<nobody@example.com>\nThe Jane Doe Authors <jane@example.com>\n"})
+	if got := holder(); got != "The Jane Doe Authors" {
+		t.Errorf("got %q, want the first entry of AUTHORS", got)
+	}
+
+	cfg, err := tr.repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Raw.Section("user").SetOption("name", "Jane Doe")
+	if err := tr.repo.Storer.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := holder(); got != "Jane Doe" {
+		t.Errorf("got %q, want user.name", got)
+	}
+
+	if _, err := tr.conform(Options{Fix: true, HolderFromGit: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tr.read("a.go"), "// Copyright 2018 Jane Doe. All Rights Reserved.\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%s\nwant it to start with\n%s", got, want)
+	}
+}
+
+func TestHolderFromBlame(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	bob := tr.commit("Bob", inYear(2019), map[string]string{"a.go": testSource, "sub/.conform-holder": "Sub Corp\n", "sub/b.go": testSource})
+	alice := tr.commit("Alice", inYear(2021), map[string]string{"a.go": testSource + "\nvar y = 2\n"})
+	if _, err := tr.conform(Options{Fix: true, HolderFromBlame: true}); err != nil {
+		t.Fatal(err)
+	}
+	wants := map[string]string{
+		"a.go":     "// Copyright 2019 Bob. All Rights Reserved.\n",
+		"sub/b.go": "// Copyright 2019 Sub Corp. All Rights Reserved.\n",
+	}
+	for path, want := range wants {
+		if got := tr.read(path); !strings.HasPrefix(got, want) {
+			t.Errorf("%s: got\n%s\nwant it to start with\n%s", path, got, want)
+		}
+	}
+
+	never := func(plumbing.Hash) bool { return false }
+	tied := tr.commit("Carol", inYear(2019), map[string]string{"c.go": testSource})
+	tests := []struct {
+		name  string
+		lines []*git.Line
+		want  string
+	}{
+		{name: "earliest first", lines: []*git.Line{{Hash: bob, Date: inYear(2019)}, {Hash: alice, Date: inYear(2021)}}, want: "Bob"},
+		{name: "earliest last", lines: []*git.Line{{Hash: alice, Date: inYear(2021)}, {Hash: bob, Date: inYear(2019)}}, want: "Bob"},
+		{name: "tie", lines: []*git.Line{{Hash: bob, Date: inYear(2019)}, {Hash: tied, Date: inYear(2019)}}, want: ""},
+		{name: "no lines"},
+	}
+	for _, tt := range tests {
+		got, err := earliestBlameAuthor(tr.repo, tt.lines, never, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestForcedYear(t *testing.T) {
+	tests := []struct {
+		name string
+		year int
+		want int
+	}{
+		{name: "blame", want: 2015},
+		{name: "later year", year: 2020, want: 2020},
+		{name: "earlier year", year: 2010, want: 2010},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": "package a\n"})
+		tr.commit("Bob", inYear(2017), map[string]string{"a.go": "package a\n\nvar b = 1\n"})
+		if _, err := tr.conform(Options{Fix: true, Year: tt.year, Holders: []string{"Initech"}}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got, want := tr.read("a.go"), renderTestHeader(t, shortApache2Point0Templ, tt.want, "Initech")+"package a\n\nvar b = 1\n"; got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+
+	// Blaming a file that git has no history of fails, so
+	// stamping it shows that a forced year skips blame.
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2015), map[string]string{"a.go": "package a\n"})
+	writeFiles(t, tr.dir, map[string]string{"untracked.go": "package a\n"})
+	rep, err := tr.conform(Options{Fix: true, Year: 2020, Holders: []string{"Initech"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Errors != 0 {
+		t.Errorf("got %d errors, want none", rep.Errors)
+	}
+	if got, want := tr.read("untracked.go"), renderTestHeader(t, shortApache2Point0Templ, 2020, "Initech")+"package a\n"; got != want {
+		t.Errorf("untracked.go: got\n%s\nwant\n%s", got, want)
+	}
+
+	for _, opts := range []Options{{Year: 2020, YearRange: true}, {Year: 2020, YearFromCreation: true}} {
+		if _, err := tr.conform(opts); err == nil || !strings.Contains(err.Error(), "fixed year") {
+			t.Errorf("%+v: got error %v, want one about the fixed year", opts, err)
+		}
+	}
+}
+
+func TestGitignoredPaths(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	writeFiles(t, dir, map[string]string{
+		".git/info/exclude": "# local\nexcluded.go\nkept.go\n",
+		".gitignore":        "*.gen.go\n!kept.go\n",
+		"sub/.gitignore":    "local.go\n",
+		"sub/deep/c.gen.go": testSource,
+		"sub/local.go":      testSource,
+		"local.go":          testSource,
+		"excluded.go":       testSource,
+		"kept.go":           testSource,
+	})
+	ignored, err := gitignoredPaths(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The paths are asked about in an order that the walk
+	// would not take, before their directories.
+	tests := []struct {
+		relPath string
+		want    bool
+	}{
+		{relPath: "sub/deep/c.gen.go", want: true},
+		{relPath: "sub/local.go", want: true},
+		{relPath: "local.go", want: false},
+		{relPath: "excluded.go", want: true},
+		// .gitignore files win over .git/info/exclude.
+		{relPath: "kept.go", want: false},
+		{relPath: "sub", want: false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, filepath.FromSlash(tt.relPath))
+		fi, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ignored(path, fi); got != tt.want {
+			t.Errorf("%s: got ignored %v, want %v", tt.relPath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sourceLanguage is a family of source files
+// that share extensions and a comment style.
+type sourceLanguage struct {
+	name  string
+	exts  []string
+	style *CommentStyle
+}
+
+// sourceLanguages are told apart by extension. Protocol buffer files
+// qualify since comments may precede their `syntax = "proto3";`
+// statement, as do Terraform and other HCL files since nothing, not
+// even a `terraform {` block, has to come first in them.
+var sourceLanguages = []*sourceLanguage{
+	{name: "go", exts: []string{".go"}, style: slashComments},
+	{name: "proto", exts: []string{".proto"}, style: slashComments},
+	{name: "sql", exts: []string{".sql"}, style: dashComments},
+	{name: "r", exts: []string{".R", ".r"}, style: hashComments},
+	{name: "julia", exts: []string{".jl"}, style: hashComments},
+	{name: "python", exts: []string{".py"}, style: hashComments},
+	{name: "shell", exts: []string{".sh", ".bash"}, style: hashComments},
+	{name: "terraform", exts: []string{".tf", ".hcl"}, style: hashComments},
+	{name: "c", exts: []string{".c", ".h"}, style: blockComments},
+	{name: "cpp", exts: []string{".cc", ".cpp", ".cxx", ".hh", ".hpp"}, style: blockComments},
+	{name: "java", exts: []string{".java"}, style: blockComments},
+	{name: "html", exts: []string{".html", ".htm"}, style: htmlComments},
+	{name: "xml", exts: []string{".xml"}, style: xmlComments},
+}
+
+// Language is a family of source files that get stamped.
+type Language struct {
+	Name       string
+	Extensions []string
+}
+
+// Languages returns the languages that can be stamped in order.
+func Languages() []*Language {
+	langs := make([]*Language, 0, len(sourceLanguages))
+	for _, lang := range sourceLanguages {
+		langs = append(langs, &Language{Name: lang.name, Extensions: append([]string(nil), lang.exts...)})
+	}
+	return langs
+}
+
+// extensionTable maps the extensions of the files that get stamped in
+// a run to the comment style they use and the language they are of.
+type extensionTable struct {
+	styles    map[string]*CommentStyle
+	languages map[string]string
+	// sidecars are the extensions of files, such as binaries and
+	// data, that get a sidecar written for them instead of a header.
+	sidecars map[string]bool
+	// resolve if set picks the style of a path ahead of styles.
+	resolve func(path string) *CommentStyle
+}
+
+// newExtensionTable limits the stamped files to those of
+// the languages named in opts, or to Go if none are, and
+// registers opts.StampExtensions and opts.SidecarExtensions on top.
+func newExtensionTable(opts *Options) (*extensionTable, error) {
+	et := &extensionTable{
+		styles:    make(map[string]*CommentStyle),
+		languages: make(map[string]string),
+		sidecars:  make(map[string]bool),
+		resolve:   opts.CommentStyleFor,
+	}
+	wanted := make(map[string]bool)
+	for _, name := range opts.Languages {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			wanted[name] = true
+		}
+	}
+	// Other languages are opt-in.
+	if len(wanted) == 0 {
+		wanted["go"] = true
+	}
+	for _, lang := range sourceLanguages {
+		if !wanted[lang.name] {
+			continue
+		}
+		delete(wanted, lang.name)
+		for _, ext := range lang.exts {
+			et.styles[ext] = lang.style
+			et.languages[ext] = lang.name
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown language %q", name)
+	}
+	for ext, name := range opts.StampExtensions {
+		ext = normalizeExt(ext)
+		style, ok := CommentStyleNamed(name)
+		if !ok {
+			return nil, fmt.Errorf("stamp extensions: unknown comment style %q for %q", name, ext)
+		}
+		et.styles[ext] = style
+		delete(et.languages, ext)
+	}
+	for _, ext := range opts.SidecarExtensions {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			et.sidecars[normalizeExt(ext)] = true
+		}
+	}
+	return et, nil
+}
+
+// normalizeExt makes sure that ext starts with a dot.
+func normalizeExt(ext string) string {
+	if !strings.HasPrefix(ext, ".") {
+		return "." + ext
+	}
+	return ext
+}
+
+// styleFor returns the comment style of the file at path.
+func (et *extensionTable) styleFor(path string) *CommentStyle {
+	if et.resolve != nil {
+		if style := et.resolve(path); style != nil {
+			return style
+		}
+	}
+	if style := et.styles[filepath.Ext(path)]; style != nil {
+		return style
+	}
+	return slashComments
+}
+
+// matchSourceFile reports whether path is a regular file that gets a
+// header or a sidecar and if so, the language detected for it.
+func (et *extensionTable) matchSourceFile(path string, fi os.FileInfo) (lang string, ok bool) {
+	if fi == nil || !fi.Mode().IsRegular() || strings.Contains(filepath.ToSlash(path), "vendor/") || strings.HasSuffix(path, "doc.go") {
+		return "", false
+	}
+	ext := filepath.Ext(path)
+	switch {
+	case et.languages[ext] != "":
+		return et.languages[ext], true
+	case et.styles[ext] != nil:
+		// Registered through Options.StampExtensions.
+		return strings.TrimPrefix(ext, "."), true
+	case et.sidecars[ext]:
+		return "sidecar", true
+	}
+	return "", false
+}
+
+func (et *extensionTable) isSourceFile(path string, fi os.FileInfo) bool {
+	_, ok := et.matchSourceFile(path, fi)
+	return ok
+}
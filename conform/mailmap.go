@@ -0,0 +1,91 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// mailmap maps the identities that commits were authored under to the
+// canonical names that a repo's .mailmap file gives them, see
+// git-check-mailmap(1). A nil mailmap maps every identity to itself.
+type mailmap struct {
+	// byEmail is keyed by the lowercased commit email,
+	// byNameEmail additionally by the commit name.
+	byEmail     map[string]string
+	byNameEmail map[string]string
+}
+
+// regMailmapIdent matches an optional name followed by an email in angle brackets.
+var regMailmapIdent = regexp.MustCompile(`\s*([^<]*?)\s*<([^>]*)>`)
+
+// readMailmap parses the .mailmap file at the root of dirPath, returning
+// a nil mailmap if there is none. Entries that give a proper email but no
+// proper name are ignored since only names end up in headers.
+func readMailmap(dirPath string) (*mailmap, error) {
+	blob, err := ioutil.ReadFile(filepath.Join(dirPath, ".mailmap"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	mm := &mailmap{byEmail: make(map[string]string), byNameEmail: make(map[string]string)}
+	sc := bufio.NewScanner(bytes.NewReader(blob))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		idents := regMailmapIdent.FindAllStringSubmatch(line, 2)
+		if len(idents) == 0 {
+			continue
+		}
+		proper := idents[0][1]
+		commit := idents[len(idents)-1]
+		if proper == "" {
+			continue
+		}
+		email := strings.ToLower(commit[2])
+		if len(idents) == 2 && commit[1] != "" {
+			mm.byNameEmail[commit[1]+"\x00"+email] = proper
+		} else {
+			mm.byEmail[email] = proper
+		}
+	}
+	return mm, sc.Err()
+}
+
+// name returns the canonical name of the author called name at email,
+// or name itself if the mailmap has no entry for them.
+func (mm *mailmap) name(name, email string) string {
+	if mm == nil {
+		return name
+	}
+	email = strings.ToLower(email)
+	if proper, ok := mm.byNameEmail[name+"\x00"+email]; ok {
+		return proper
+	}
+	if proper, ok := mm.byEmail[email]; ok {
+		return proper
+	}
+	return name
+}
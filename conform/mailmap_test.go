@@ -0,0 +1,81 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadMailmap(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	if mm, err := readMailmap(dir); mm != nil || err != nil {
+		t.Fatalf("got %v, %v without a .mailmap, want neither", mm, err)
+	}
+	writeFiles(t, dir, map[string]string{".mailmap": "# Canonical names\n" +
+		"Jane Doe <jane@example.com>\n" +
+		"<jane@example.com> <jd@old.example.com>\n" +
+		"John Roe <john@example.com> <JR@Example.com> # a typo of his\n" +
+		"Joe Bloggs <joe@example.com> jb <joe@old.example.com>\n"})
+	mm, err := readMailmap(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, email string
+		want        string
+	}{
+		{name: "jane", email: "jane@example.com", want: "Jane Doe"},
+		{name: "jane", email: "JANE@example.com", want: "Jane Doe"},
+		// Only a proper email is given, names stay as they are.
+		{name: "jd", email: "jd@old.example.com", want: "jd"},
+		{name: "Johnny", email: "jr@example.com", want: "John Roe"},
+		{name: "jb", email: "joe@old.example.com", want: "Joe Bloggs"},
+		{name: "Joseph", email: "joe@old.example.com", want: "Joseph"},
+		{name: "Someone", email: "someone@example.com", want: "Someone"},
+	}
+	for _, tt := range tests {
+		if got := mm.name(tt.name, tt.email); got != tt.want {
+			t.Errorf("name(%q, %q) = %q, want %q", tt.name, tt.email, got, tt.want)
+		}
+	}
+	if got := (*mailmap)(nil).name("jane", "jane@example.com"); got != "jane" {
+		t.Errorf("nil mailmap: got %q, want jane", got)
+	}
+}
+
+func TestMailmapCollapsesAliases(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	contents := testSource
+	for i, c := range []struct {
+		author string
+		year   int
+	}{{"Jane Doe", 2019}, {"jdoe", 2021}} {
+		contents += fmt.Sprintf("var v%d = 1\n", i)
+		tr.commit(c.author, inYear(c.year).Add(time.Duration(i)*time.Hour), map[string]string{"a.go": contents})
+	}
+	tr.commit("Jane Doe", inYear(2021).Add(2*time.Hour), map[string]string{".mailmap": "Jane Doe <jane.doe@example.com> <jdoe@example.com>\n"})
+	if _, err := tr.conform(Options{Fix: true, PerAuthorSpans: true, Mailmap: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := "// Copyright 2019-2021 Jane Doe. All Rights Reserved.\n//\n"
+	if got := tr.read("a.go"); !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%s\nwant it to start with\n%s", got, want)
+	}
+}
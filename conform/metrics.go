@@ -0,0 +1,67 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/odeke-em/semalim"
+)
+
+// workerMetrics accumulates how long jobs kept the workers busy.
+type workerMetrics struct {
+	busyNanos int64
+	jobs      int64
+}
+
+func (wm *workerMetrics) wrap(job semalim.Job) semalim.Job {
+	return &timedJob{Job: job, metrics: wm}
+}
+
+// report summarizes utilization of concurrency workers over wall time.
+func (wm *workerMetrics) report(w io.Writer, concurrency uint, wall time.Duration) {
+	busy := time.Duration(atomic.LoadInt64(&wm.busyNanos))
+	jobs := atomic.LoadInt64(&wm.jobs)
+	capacity := wall * time.Duration(concurrency)
+	idle := capacity - busy
+	if idle < 0 {
+		idle = 0
+	}
+	utilization, perJob := 0.0, time.Duration(0)
+	if capacity > 0 {
+		utilization = 100 * float64(busy) / float64(capacity)
+	}
+	if jobs > 0 {
+		perJob = busy / time.Duration(jobs)
+	}
+	fmt.Fprintf(w, "Workers: %d Busy: %s Idle: %s Utilization: %.1f%% AvgPerFile: %s\n",
+		concurrency, busy, idle, utilization, perJob)
+}
+
+type timedJob struct {
+	semalim.Job
+	metrics *workerMetrics
+}
+
+func (tj *timedJob) Do() (interface{}, error) {
+	defer func(start time.Time) {
+		atomic.AddInt64(&tj.metrics.busyNanos, int64(time.Since(start)))
+		atomic.AddInt64(&tj.metrics.jobs, 1)
+	}(time.Now())
+	return tj.Job.Do()
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerMetricsReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		busy        time.Duration
+		jobs        int64
+		concurrency uint
+		wall        time.Duration
+		want        string
+	}{
+		{
+			name: "partly busy", busy: 3 * time.Second, jobs: 3, concurrency: 2, wall: 2 * time.Second,
+			want: "Workers: 2 Busy: 3s Idle: 1s Utilization: 75.0% AvgPerFile: 1s\n",
+		},
+		{
+			name: "no jobs", concurrency: 4, wall: time.Second,
+			want: "Workers: 4 Busy: 0s Idle: 4s Utilization: 0.0% AvgPerFile: 0s\n",
+		},
+		{
+			name: "no time", concurrency: 4,
+			want: "Workers: 4 Busy: 0s Idle: 0s Utilization: 0.0% AvgPerFile: 0s\n",
+		},
+	}
+	for _, tt := range tests {
+		wm := &workerMetrics{busyNanos: int64(tt.busy), jobs: tt.jobs}
+		buf := new(bytes.Buffer)
+		wm.report(buf, tt.concurrency, tt.wall)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConcurrencyMetrics(t *testing.T) {
+	for _, metrics := range []bool{true, false} {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource, "b.go": testSource})
+		rep, err := tr.conform(Options{Concurrency: 3, Metrics: metrics})
+		if err != nil {
+			t.Fatalf("metrics=%v: %v", metrics, err)
+		}
+		buf := new(bytes.Buffer)
+		if ok := rep.WriteMetrics(buf); ok != metrics || (metrics && !strings.HasPrefix(buf.String(), "Workers: 3 Busy: ")) {
+			t.Errorf("metrics=%v: got %v and output %q", metrics, ok, buf)
+		}
+	}
+}
+
+func TestDefaultConcurrency(t *testing.T) {
+	n := DefaultConcurrency()
+	if n == 0 || n > maxDefaultConcurrency || (runtime.NumCPU() <= maxDefaultConcurrency && n != uint(runtime.NumCPU())) {
+		t.Fatalf("got %d with %d CPUs", n, runtime.NumCPU())
+	}
+
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource})
+	rep, err := tr.conform(Options{Metrics: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	rep.WriteMetrics(buf)
+	if want := fmt.Sprintf("Workers: %d ", n); !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("got %q, want prefix %q", buf, want)
+	}
+}
+
+func TestReportMerge(t *testing.T) {
+	a := &FileResult{Path: "a.go", Status: StatusAdded, Added: true, Apache: true}
+	b := &FileResult{Path: "b.go", Status: StatusConforming}
+	rep := new(Report)
+	rep.Merge(&Report{Files: []*FileResult{a}, Statuses: map[string]int{StatusAdded: 1}, Added: 1, Apache: 1, concurrency: 2,
+		metrics: &workerMetrics{busyNanos: int64(time.Second), jobs: 1}, elapsed: time.Second})
+	rep.Merge(&Report{Files: []*FileResult{b}, Statuses: map[string]int{StatusConforming: 1}, Conforming: 1, Aborted: true, concurrency: 4,
+		metrics: &workerMetrics{busyNanos: int64(time.Second), jobs: 1}, elapsed: time.Second})
+	want := &Report{
+		Files:       []*FileResult{a, b},
+		Statuses:    map[string]int{StatusAdded: 1, StatusConforming: 1},
+		Added:       1,
+		Conforming:  1,
+		Apache:      1,
+		Aborted:     true,
+		metrics:     &workerMetrics{busyNanos: int64(2 * time.Second), jobs: 2},
+		concurrency: 4,
+		elapsed:     2 * time.Second,
+	}
+	if !reflect.DeepEqual(rep, want) {
+		t.Errorf("got %+v, want %+v", rep, want)
+	}
+}
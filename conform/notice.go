@@ -0,0 +1,166 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	noticeLineKeep   = "keep"
+	noticeLineAdd    = "add"
+	noticeLineRemove = "remove"
+)
+
+var shortApache2Point0Notice = `{{range .Lines}}// Copyright {{.Year}} {{.Holder}}. All Rights Reserved.
+{{end}}//
+// See the NOTICE file distributed with this work for additional
+// information regarding copyright ownership.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+`
+
+var shortApache2Point0NoticeTempl = template.Must(template.New("apache2.0-notice").Parse(shortApache2Point0Notice))
+
+// regNoticeLine matches the comment line that opens a reference to
+// the NOTICE file, but not the ASF header which mentions the file
+// in the middle of a sentence.
+var regNoticeLine = regexp.MustCompile(`(?m)^([^\w\n]*)See the NOTICE file\b.*$`)
+
+// withNoticeLine makes templateFor render the Apache header with a
+// reference to the NOTICE file wherever it would render the plain one.
+func withNoticeLine(templateFor func(path string) *template.Template) func(path string) *template.Template {
+	return func(path string) *template.Template {
+		if tmpl := templateFor(path); tmpl != shortApache2Point0Templ {
+			return tmpl
+		}
+		return shortApache2Point0NoticeTempl
+	}
+}
+
+func parseNoticeLine(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", noticeLineKeep:
+		return noticeLineKeep, nil
+	case noticeLineAdd:
+		return noticeLineAdd, nil
+	case noticeLineRemove:
+		return noticeLineRemove, nil
+	default:
+		return "", fmt.Errorf("unsupported NOTICE line mode %q, options are: keep, add, remove", mode)
+	}
+}
+
+// hasNoticeReference reports whether the header in comment already
+// points readers at the NOTICE file, in either of its usual wordings.
+func hasNoticeReference(comment []byte) bool {
+	return bytes.Contains(bytes.ToLower(collapseWhitespace(comment)), []byte("see the notice file"))
+}
+
+// normalizeNoticeLine adds the NOTICE reference below the copyright
+// lines of an Apache header that lacks one, or removes the one it has.
+func (lc *licenseConformer) normalizeNoticeLine(goFile string, sniff []byte, f io.ReadCloser) (*conformResult, error) {
+	rest, err := ioutil.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	conforming := &conformResult{status: StatusConforming, year: headerYear(sniff), apache: true}
+	comment := leadingComment(sniff, lc.exts.styleFor(goFile))
+	normalized := new(bytes.Buffer)
+	switch lc.noticeLine {
+	case noticeLineAdd:
+		if hasNoticeReference(comment) {
+			return conforming, nil
+		}
+		locs := regCopyrightLine.FindAllIndex(comment, -1)
+		if len(locs) == 0 {
+			return conforming, nil
+		}
+		end := locs[len(locs)-1][1]
+		if nl := bytes.IndexByte(comment[end:], '\n'); nl >= 0 {
+			end += nl + 1
+		} else {
+			return conforming, nil
+		}
+		lineStart := bytes.LastIndexByte(comment[:locs[len(locs)-1][0]], '\n') + 1
+		prefix := copyrightLinePrefix(comment[lineStart:end])
+		bare := strings.TrimRight(prefix, " \t")
+		normalized.Write(sniff[:end])
+		fmt.Fprintf(normalized, "%s\n%sSee the NOTICE file distributed with this work for additional\n%sinformation regarding copyright ownership.\n", bare, prefix, prefix)
+		normalized.Write(sniff[end:])
+	case noticeLineRemove:
+		loc := regNoticeLine.FindSubmatchIndex(comment)
+		if loc == nil {
+			return conforming, nil
+		}
+		bare := strings.TrimRight(string(comment[loc[2]:loc[3]]), " \t")
+		start, end := loc[0], loc[1]
+		// The sentence may wrap onto the lines below it.
+		for !strings.HasSuffix(strings.TrimSpace(string(comment[start:end])), ".") {
+			if end >= len(comment) {
+				return conforming, nil
+			}
+			nl := bytes.IndexByte(comment[end+1:], '\n')
+			if nl < 0 {
+				return conforming, nil
+			}
+			end += 1 + nl
+		}
+		if end < len(comment) {
+			end++
+		}
+		// Drop the blank comment line that set the reference apart.
+		if start > 0 {
+			before := bytes.LastIndexByte(comment[:start-1], '\n') + 1
+			if strings.TrimRight(string(comment[before:start-1]), " \t") == bare {
+				start = before
+			}
+		}
+		normalized.Write(sniff[:start])
+		normalized.Write(sniff[end:])
+	default:
+		return conforming, nil
+	}
+	normalized.Write(rest)
+	return lc.save(goFile, normalized.Bytes())
+}
+
+// copyrightLinePrefix returns the comment marker and spacing that
+// line starts with, e.g. "// " or " * ".
+func copyrightLinePrefix(line []byte) string {
+	i := bytes.Index(bytes.ToLower(line), []byte("copyright"))
+	if i < 0 {
+		return ""
+	}
+	return string(line[:i])
+}
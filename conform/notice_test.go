@@ -0,0 +1,76 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestNoticeLine(t *testing.T) {
+	render := func(tmpl *template.Template, style *CommentStyle) string {
+		header, err := renderHeader(tmpl, newCopyright(2016, []string{"Initech"}, func(holder string) string { return holder }), style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(header)
+	}
+	plain := render(shortApache2Point0Templ, slashComments) + "package a\n"
+	noticed := render(shortApache2Point0NoticeTempl, slashComments) + "package a\n"
+	if !strings.Contains(noticed, "// See the NOTICE file distributed with this work for additional\n") {
+		t.Fatalf("header has no NOTICE reference:\n%s", noticed)
+	}
+	plainSh := render(shortApache2Point0Templ, hashComments) + "echo a\n"
+	noticedSh := render(shortApache2Point0NoticeTempl, hashComments) + "echo a\n"
+
+	tests := []struct {
+		name, noticeLine string
+		path             string
+		contents, want   string
+	}{
+		{name: "keep with reference", noticeLine: NoticeLineKeep, path: "a.go", contents: noticed, want: noticed},
+		{name: "keep without reference", noticeLine: NoticeLineKeep, path: "a.go", contents: plain, want: plain},
+		{name: "add", noticeLine: NoticeLineAdd, path: "a.go", contents: plain, want: noticed},
+		{name: "add again", noticeLine: NoticeLineAdd, path: "a.go", contents: noticed, want: noticed},
+		{name: "add in shell", noticeLine: NoticeLineAdd, path: "a.sh", contents: plainSh, want: noticedSh},
+		{name: "remove", noticeLine: NoticeLineRemove, path: "a.go", contents: noticed, want: plain},
+		{name: "remove in shell", noticeLine: NoticeLineRemove, path: "a.sh", contents: noticedSh, want: plainSh},
+		{name: "remove again", noticeLine: NoticeLineRemove, path: "a.go", contents: plain, want: plain},
+	}
+	for _, tt := range tests {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		writeFiles(t, dir, map[string]string{tt.path: tt.contents})
+		rep, err := Conform(Options{RepoPath: dir, NoGit: true, Fix: true, Holders: []string{"Initech"}, NoticeLine: tt.noticeLine, Languages: []string{"go", "shell"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus := StatusConforming
+		if tt.want != tt.contents {
+			wantStatus = StatusAdded
+		}
+		if len(rep.Files) != 1 || rep.Files[0].Status != wantStatus {
+			t.Errorf("%s: got files %+v, want one %q", tt.name, rep.Files, wantStatus)
+		}
+		if got := readFile(t, dir, tt.path); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Conform(Options{RepoPath: ".", NoGit: true, NoticeLine: "toggle"}); err == nil {
+		t.Error("got no error for an unknown -notice-line")
+	}
+}
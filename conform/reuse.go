@@ -0,0 +1,167 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// sidecarSuffix is appended to a file's name to get the name of the
+// REUSE sidecar that declares its license, for files that cannot carry
+// a header of their own, see https://reuse.software/spec/.
+const sidecarSuffix = ".license"
+
+// spdxIdentifiers are the SPDX license identifiers of the built-in templates.
+var spdxIdentifiers = map[*template.Template]string{
+	shortApache2Point0Templ:       "Apache-2.0",
+	shortApache2Point0NoticeTempl: "Apache-2.0",
+	shortBSDTempl:                 "BSD-3-Clause",
+	shortMITTempl:                 "MIT",
+	shortGPL3Templ:                "GPL-3.0-or-later",
+	shortMPL2Templ:                "MPL-2.0",
+}
+
+var regSPDXIdentifier = regexp.MustCompile(`(?m)SPDX-License-Identifier:\s*(.+?)\s*$`)
+
+// regSPDXHeaderLine matches SPDX-License-Identifier lines in comments,
+// as opposed to mentions of the tag elsewhere such as in string literals.
+var regSPDXHeaderLine = regexp.MustCompile(`(?m)^\W*SPDX-License-Identifier:[ \t]*\S`)
+
+// shortSPDX is the REUSE style header that -spdx stamps
+// instead of a full license notice, for the identifier %s.
+const shortSPDX = `{{range .Lines}}// SPDX-FileCopyrightText: {{.Year}} {{.Holder}}
+{{end}}// SPDX-License-Identifier: %s
+
+`
+
+// spdxTemplates map the built-in templates to their short SPDX forms.
+var spdxTemplates = make(map[*template.Template]*template.Template)
+
+func init() {
+	for _, tmpl := range builtinTemplates {
+		id := spdxIdentifiers[tmpl]
+		short := template.Must(template.New(tmpl.Name() + "-spdx").Parse(fmt.Sprintf(shortSPDX, id)))
+		spdxTemplates[tmpl] = short
+		spdxIdentifiers[short] = id
+	}
+}
+
+// SPDXTemplate returns the short SPDX form of tmpl, which must be
+// one of the built-in templates, that names the license by its SPDX
+// identifier alone.
+func SPDXTemplate(tmpl *template.Template) (*template.Template, bool) {
+	short, ok := spdxTemplates[tmpl]
+	return short, ok
+}
+
+// licenseSidecar returns the contents of the sidecar of path if its
+// SPDX license expression names want, any identifier will do if want
+// is "".
+func licenseSidecar(path, want string) ([]byte, bool) {
+	blob, err := ioutil.ReadFile(path + sidecarSuffix)
+	if err != nil {
+		return nil, false
+	}
+	for _, match := range regSPDXIdentifier.FindAllSubmatch(blob, -1) {
+		if want == "" {
+			return blob, true
+		}
+		// Expressions such as "(Apache-2.0 OR MIT)" may name several.
+		for _, id := range strings.FieldsFunc(string(match[1]), isSPDXSeparator) {
+			if strings.EqualFold(id, want) {
+				return blob, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func isSPDXSeparator(r rune) bool { return r == ' ' || r == '(' || r == ')' }
+
+// sidecarYear returns the first year among the
+// SPDX-FileCopyrightText lines of a sidecar.
+func sidecarYear(blob []byte) int {
+	const tag = "SPDX-FileCopyrightText:"
+	for _, line := range bytes.Split(blob, []byte("\n")) {
+		if i := bytes.Index(line, []byte(tag)); i >= 0 {
+			return headerYear(append([]byte("Copyright "), bytes.TrimSpace(line[i+len(tag):])...))
+		}
+	}
+	return 0
+}
+
+// stampSidecar makes sure that goFile, which cannot carry comments, has
+// a sidecar declaring its license, dated by the commit that added it.
+func (lc *licenseConformer) stampSidecar(goFile string, holders []string) (*conformResult, error) {
+	tmpl := lc.templateFor(goFile)
+	want := spdxIdentifiers[tmpl]
+	if blob, ok := licenseSidecar(goFile, want); ok {
+		return &conformResult{status: StatusConforming, year: sidecarYear(blob)}, nil
+	}
+	if lc.check {
+		return &conformResult{status: StatusMissing}, nil
+	}
+	if want == "" {
+		return nil, fmt.Errorf("no SPDX identifier is known for template %q to write a sidecar with", tmpl.Name())
+	}
+	relToRootPath, err := repoRelPath(lc.dirPath, goFile)
+	if err != nil {
+		return nil, err
+	}
+	created := time.Date(lc.fixedYear, time.January, 1, 0, 0, 0, 0, time.UTC)
+	switch {
+	case lc.fixedYear != 0:
+	case lc.headCommit == nil:
+		// Only left unset without git for YearFromFSCreation.
+		if created, err = fsCreationTime(goFile); err != nil {
+			return nil, err
+		}
+	default:
+		if created, err = fileCreationTime(lc.repo, lc.headCommit.Hash, relToRootPath, lc.skipMerges); err != nil {
+			return nil, err
+		}
+	}
+	if (lc.fixIt || lc.dryRun) && !created.After(blankTime) && lc.failZeroYear {
+		return nil, errNoYear
+	}
+	if !(lc.fixIt || lc.dryRun) || !created.After(blankTime) {
+		return &conformResult{status: StatusMissing, year: created.Year()}, nil
+	}
+	buf := new(bytes.Buffer)
+	for _, holder := range holders {
+		fmt.Fprintf(buf, "SPDX-FileCopyrightText: %d %s\n", created.Year(), lc.renderHolder(holder))
+	}
+	fmt.Fprintf(buf, "\nSPDX-License-Identifier: %s\n", want)
+
+	res := &conformResult{added: true, status: StatusAdded, year: created.Year(), apache: want == "Apache-2.0"}
+	switch {
+	case lc.patch:
+		res.diff = newFileDiff(lc.diffPrefix+relToRootPath+sidecarSuffix, buf.Bytes())
+	case lc.dryRun:
+	case lc.staged:
+		res.staged = &stagedWrite{path: goFile + sidecarSuffix, contents: buf.Bytes()}
+	default:
+		if err := ioutil.WriteFile(goFile+sidecarSuffix, buf.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
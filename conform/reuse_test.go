@@ -0,0 +1,182 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLicenseSidecar(t *testing.T) {
+	tests := []struct {
+		name     string
+		sidecar  string
+		want     string
+		wantOK   bool
+		wantYear int
+	}{
+		{name: "apache", sidecar: "SPDX-FileCopyrightText: 2017 ACME\n\nSPDX-License-Identifier: Apache-2.0\n", want: "Apache-2.0", wantOK: true, wantYear: 2017},
+		{name: "expression", sidecar: "SPDX-FileCopyrightText: 2016 ACME\nSPDX-License-Identifier: (MIT OR apache-2.0)\n", want: "Apache-2.0", wantOK: true, wantYear: 2016},
+		{name: "other license", sidecar: "SPDX-License-Identifier: MIT\n", want: "Apache-2.0"},
+		{name: "any license", sidecar: "SPDX-License-Identifier: MIT\n", wantOK: true},
+		{name: "no identifier", sidecar: "SPDX-FileCopyrightText: 2017 ACME\n", want: "Apache-2.0"},
+	}
+	for _, tt := range tests {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		writeFiles(t, dir, map[string]string{"a.go": testSource, "a.go.license": tt.sidecar})
+		blob, ok := licenseSidecar(filepath.Join(dir, "a.go"), tt.want)
+		if ok != tt.wantOK {
+			t.Errorf("%s: got %v, want %v", tt.name, ok, tt.wantOK)
+		}
+		if got := sidecarYear(blob); ok && got != tt.wantYear {
+			t.Errorf("%s: got year %d, want %d", tt.name, got, tt.wantYear)
+		}
+	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	if _, ok := licenseSidecar(filepath.Join(dir, "a.go"), ""); ok {
+		t.Error("got a sidecar for a file without one")
+	}
+}
+
+func TestSidecarConforms(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{
+		"apache.go":         testSource,
+		"apache.go.license": "SPDX-FileCopyrightText: 2017 ACME\n\nSPDX-License-Identifier: Apache-2.0\n",
+		"mit.go":            testSource,
+		"mit.go.license":    "SPDX-License-Identifier: MIT\n",
+	})
+	rep, err := tr.conform(Options{Fix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file whose sidecar declares the license is left alone.
+	statuses := tr.statuses(rep)
+	if got := statuses["apache.go"]; got != StatusConforming || tr.read("apache.go") != testSource {
+		t.Errorf("apache.go: got status %q and\n%s\nwant it conforming and untouched", got, tr.read("apache.go"))
+	}
+	for _, fr := range rep.Files {
+		if filepath.Base(fr.Path) == "apache.go" && fr.Year != 2017 {
+			t.Errorf("apache.go: got year %d, want 2017 from its sidecar", fr.Year)
+		}
+	}
+	if got := statuses["mit.go"]; got != StatusAdded {
+		t.Errorf("mit.go: got status %q, want %q", got, StatusAdded)
+	}
+}
+
+func TestWriteSidecar(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2017), map[string]string{"logo.png": png})
+	tr.commit("Alice", inYear(2018), map[string]string{"icon.png": png, "icon.png.license": "SPDX-License-Identifier: Apache-2.0\n", "data.bin": png})
+
+	want := map[string]string{"logo.png": StatusAdded, "icon.png": StatusConforming}
+	for i := 0; i < 2; i++ {
+		rep, err := tr.conform(Options{Fix: true, SidecarExtensions: []string{"png"}})
+		if err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+		if got := tr.statuses(rep); !reflect.DeepEqual(got, want) {
+			t.Errorf("run %d: got statuses %v, want %v", i+1, got, want)
+		}
+		// The sidecar is dated by the commit that added its file.
+		wantSidecar := "SPDX-FileCopyrightText: 2017 ACME\n\nSPDX-License-Identifier: Apache-2.0\n"
+		if got := tr.read("logo.png.license"); got != wantSidecar {
+			t.Errorf("run %d: got sidecar\n%s\nwant\n%s", i+1, got, wantSidecar)
+		}
+		for _, rel := range []string{"logo.png", "icon.png", "data.bin"} {
+			if got := tr.read(rel); got != png {
+				t.Errorf("run %d: %s: got %q, want it untouched", i+1, rel, got)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(tr.dir, "data.bin.license")); !os.IsNotExist(err) {
+			t.Errorf("run %d: got a sidecar for data.bin: %v", i+1, err)
+		}
+		tr.commit("Alice", inYear(2019), map[string]string{"logo.png.license": wantSidecar})
+		want["logo.png"] = StatusConforming
+	}
+}
+
+func TestSPDXHeader(t *testing.T) {
+	spdxOnly := "// SPDX-FileCopyrightText: 2016 ACME\n// SPDX-License-Identifier: MIT\n\n" + testSource
+	literal := testSource + "\nconst tag = \"SPDX-License-Identifier: MIT\"\n"
+	if !containsALicense([]byte(spdxOnly)) {
+		t.Errorf("got no license in\n%s", spdxOnly)
+	}
+	if containsALicense([]byte(literal)) {
+		t.Errorf("got a license in\n%s", literal)
+	}
+
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"spdx.go": spdxOnly, "literal.go": literal})
+	rep, err := tr.conform(Options{Fix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.read("spdx.go"); got != spdxOnly {
+		t.Errorf("spdx.go: got\n%s\nwant it untouched", got)
+	}
+	for _, fr := range rep.Files {
+		if strings.HasSuffix(fr.Path, "spdx.go") && (fr.Status != StatusConforming || fr.Year != 2016) {
+			t.Errorf("spdx.go: got status %q year %d, want %q from 2016", fr.Status, fr.Year, StatusConforming)
+		}
+	}
+	if got := tr.read("literal.go"); !strings.HasPrefix(got, "// Copyright 2018 ACME. All Rights Reserved.\n") {
+		t.Errorf("literal.go: got\n%s\nwant it stamped", got)
+	}
+
+	// A lone identifier line is found when normalizing whitespace too.
+	idOnly := "// SPDX-License-Identifier: MIT\n\n" + testSource
+	tr.commit("Alice", inYear(2019), map[string]string{"id.go": idOnly, "literal.go": tr.read("literal.go")})
+	for _, normalize := range []bool{false, true} {
+		rep, err := tr.conform(Options{Fix: true, NormalizeWhitespace: normalize})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Added != 0 || tr.read("id.go") != idOnly {
+			t.Errorf("NormalizeWhitespace=%v: got %d added and id.go\n%s", normalize, rep.Added, tr.read("id.go"))
+		}
+	}
+}
+
+func TestSPDXTemplate(t *testing.T) {
+	mit, _ := BuiltinTemplate("MIT")
+	short, ok := SPDXTemplate(mit)
+	if !ok {
+		t.Fatal("got no SPDX form of MIT")
+	}
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	if _, err := tr.conform(Options{Fix: true, Template: short, Holders: []string{"ACME", "Globex"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := "// SPDX-FileCopyrightText: 2018 ACME\n// SPDX-FileCopyrightText: 2018 Globex\n// SPDX-License-Identifier: MIT\n\n" + testSource
+	if got := tr.read("a.go"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if _, ok := SPDXTemplate(short); ok {
+		t.Error("got an SPDX form of the SPDX form")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var shortBSD = `{{range .Lines}}// Copyright {{.Year}} {{.Holder}}. All rights reserved.
+{{end}}// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+`
+
+var shortApache2Point0 = `{{range .Lines}}// Copyright {{.Year}} {{.Holder}}. All Rights Reserved.
+{{end}}//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+`
+
+var shortMIT = `{{range .Lines}}// Copyright (c) {{.Year}} {{.Holder}}
+{{end}}//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+`
+
+// shortGPL3 is the notice that the GNU General Public License
+// recommends, see "How to Apply These Terms to Your New Programs".
+var shortGPL3 = `{{range .Lines}}// Copyright (C) {{.Year}} {{.Holder}}
+{{end}}//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+`
+
+// shortMPL2 is Exhibit A of the Mozilla Public License.
+var shortMPL2 = `{{range .Lines}}// Copyright {{.Year}} {{.Holder}}
+{{end}}//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+`
+
+// builtinTemplates maps lowercased license names to their templates.
+var builtinTemplates = map[string]*template.Template{
+	"apache2.0": shortApache2Point0Templ,
+	"bsd":       shortBSDTempl,
+	"gpl3":      shortGPL3Templ,
+	"mit":       shortMITTempl,
+	"mpl2":      shortMPL2Templ,
+}
+
+// LicenseNames returns the names of the built-in licenses in order.
+func LicenseNames() []string {
+	names := make([]string, 0, len(builtinTemplates))
+	for _, tmpl := range builtinTemplates {
+		names = append(names, tmpl.Name())
+	}
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+	return names
+}
+
+// BuiltinTemplate returns the template of the built-in
+// license called name, see LicenseNames, ignoring case.
+func BuiltinTemplate(name string) (*template.Template, bool) {
+	tmpl, ok := builtinTemplates[strings.ToLower(name)]
+	return tmpl, ok
+}
+
+// ReadTemplateFile parses the license header template at path, making
+// sure up front that it renders for a sample copyright so mistakes such
+// as misspelled fields are reported before any file is processed.
+func ReadTemplateFile(path string) (*template.Template, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(blob))
+	if err != nil {
+		return nil, err
+	}
+	sample := newCopyright(time.Now().Year(), []string{"Sample Holder"}, func(holder string) string { return holder })
+	if err := tmpl.Execute(ioutil.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+var shortApache2Point0Templ = template.Must(template.New("apache2.0").Parse(shortApache2Point0))
+var shortBSDTempl = template.Must(template.New("BSD").Parse(shortBSD))
+var shortMITTempl = template.Must(template.New("MIT").Parse(shortMIT))
+var shortGPL3Templ = template.Must(template.New("GPL3").Parse(shortGPL3))
+var shortMPL2Templ = template.Must(template.New("MPL2").Parse(shortMPL2))
@@ -0,0 +1,75 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestReadTemplateFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	license := "// All Rights Reserved."
+	tests := []struct {
+		name string
+		tmpl string
+		// wantErr is a substring of the error, if any.
+		wantErr string
+		// wantProblems are substrings of those that
+		// validateTemplates finds for every extension.
+		wantProblems []string
+	}{
+		{name: "valid", tmpl: "// Copyright {{.Year}} {{.Holder}}\n" + license + "\n\n"},
+		{name: "unclosed action", tmpl: "// Copyright {{.Year}\n", wantErr: "header1.tmpl:1"},
+		{name: "misspelled field", tmpl: "// Copyright {{.Yeer}} {{.Holder}}\n" + license + "\n\n", wantErr: "can't evaluate field Yeer"},
+		{name: "no license", tmpl: "// Copyright {{.Year}} {{.Holder}}\n\n", wantProblems: []string{"is not detected as a license"}},
+		{name: "no blank line", tmpl: "// Copyright {{.Year}} {{.Holder}}\n" + license + "\n", wantProblems: []string{"must end with a blank line"}},
+	}
+	et := testExtensions(t)
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("header%d.tmpl", i))
+		if err := ioutil.WriteFile(path, []byte(tt.tmpl), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := ReadTemplateFile(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		problems := validateTemplates(et, func(string) *template.Template { return tmpl }, containsALicense)
+		if len(problems) != len(tt.wantProblems)*len(et.styles) {
+			t.Errorf("%s: got problems %q, want %d", tt.name, problems, len(tt.wantProblems)*len(et.styles))
+			continue
+		}
+		for i, problem := range problems {
+			if want := tt.wantProblems[i%len(tt.wantProblems)]; !strings.Contains(problem, want) {
+				t.Errorf("%s: got problem %q, want it to contain %q", tt.name, problem, want)
+			}
+		}
+	}
+	if _, err := ReadTemplateFile(filepath.Join(dir, "missing.tmpl")); err == nil {
+		t.Error("got no error for a missing template file")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFile replaces the contents of the file at path, through
+// writeFileAtomically if renameSafe is set.
+func writeFile(path string, contents []byte, renameSafe bool) error {
+	if renameSafe {
+		return writeFileAtomically(path, contents)
+	}
+	return ioutil.WriteFile(path, contents, 0644)
+}
+
+// writeFileAtomically writes contents to a temporary file next to path
+// and renames it over path, so that the file is never seen half written.
+// On case-insensitive filesystems a rename onto a name that only differs
+// in case from that of a sibling replaces the sibling, so the directory
+// must list path spelled exactly as given or nothing is written.
+func writeFileAtomically(path string, contents []byte) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	fi, err := exactlyNamed(dir, name)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+name+".conform-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// exactlyNamed returns the entry of dir called name, matching case.
+func exactlyNamed(dir, name string) (os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range entries {
+		if fi.Name() == name {
+			return fi, nil
+		}
+	}
+	return nil, fmt.Errorf("refusing to write %q: %q has no entry of that exact name, the filesystem may have matched one that differs in case", name, dir)
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conform
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "a.go")
+	if err := ioutil.WriteFile(path, []byte("package a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomically(path, []byte(testHeader+"package a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dir, "a.go"); got != testHeader+"package a\n" {
+		t.Errorf("got %q", got)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("got permissions %v, want them kept at 0600", perm)
+	}
+
+	// A name that the directory does not list exactly is what a
+	// case-insensitive filesystem resolves to a sibling.
+	err = writeFileAtomically(filepath.Join(dir, "A.go"), []byte("package a\n"))
+	if err == nil || !strings.Contains(err.Error(), "refusing to write") {
+		t.Errorf("got error %v, want a refusal", err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d entries, want only a.go and no temporary files left", len(entries))
+	}
+}
@@ -15,7 +15,7 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -23,267 +23,707 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
-	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
-	"gopkg.in/src-d/go-git.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing/object"
-
-	"github.com/odeke-em/semalim"
+	"github.com/orijtech/apache2conform/conform"
 )
 
-var regGo = regexp.MustCompile(".*\\.go$")
-
-func goLikeFile(path string, fi os.FileInfo) bool {
-	return fi != nil && fi.Mode().IsRegular() && regGo.Match([]byte(path)) && !strings.Contains(path, "vendor/") && !strings.HasSuffix(path, "doc.go")
-}
-
-var blankTime time.Time
-
 func main() {
 	log.SetFlags(0)
 	var goRepo string
+	var repoDir string
+	var noGit bool
+	var fixedYear int
 	var fixIt bool
 	var copyrightHolder string
 	var concurrency uint
+	var maxParallelRepos uint
 	var tmplStr string
-
-	flag.StringVar(&goRepo, "repo", "github.com/orijtech/apache2conform", "the go repo to use")
-	flag.StringVar(&tmplStr, "tmpl", "apache2.0", "the license to use, options are: apache2.0, BSD")
+	var normalizeWhitespace bool
+	var noRecurse bool
+	var patchPath string
+	var checkNotice bool
+	var holderSanitize bool
+	var holderEnvExpand bool
+	var requireMarker string
+	var exemptComment string
+	var yearFromCreation bool
+	var yearFromFSCreation bool
+	var onlyChangedLines bool
+	var flagPlaceholders bool
+	var clampToUlimit bool
+	var skipHidden bool
+	var outputEncoding string
+	var sinceTag string
+	var holderListFile string
+	var verifyClean bool
+	var force bool
+	var concurrencyMetrics bool
+	var templateMap string
+	var restampHolder bool
+	var validateOnly bool
+	var templateValidate string
+	var maxErrors uint
+	var sniffBytes uint
+	var listLicenses bool
+	var skipMerges bool
+	var failIfWouldChange bool
+	var report bool
+	var flagConflicts bool
+	var reportSortBy string
+	var forceRewriteAll bool
+	var dedupeBlanks bool
+	var trimHeaderSpace bool
+	var perAuthorSpans bool
+	var ignoreCaseHolder bool
+	var stampExtensions string
+	var progressEvery uint64
+	var blameCacheFile string
+	var onlyChanged bool
+	var tmplFile string
+	var maxRuntimePerFile time.Duration
+	var writeSidecar string
+	var perYearHolders bool
+	var holderWrapWidth uint
+	var fixOnlyIfValid bool
+	var langs string
+	var listLanguages bool
+	var badgePath string
+	var reportPercentage bool
+	var holderFromGit bool
+	var holderTrim bool
+	var holderCollapse bool
+	var updateBody bool
+	var reportNonConforming bool
+	var holderFromFirstAuthor bool
+	var holderFromBlame bool
+	var failOnZeroYear bool
+	var mailmap bool
+	var showDiff bool
+	var skipIgnored bool
+	var extendYears bool
+	var updateYear bool
+	var noticeLine string
+	var streamStatus bool
+	var treeSummary bool
+	var checkOnly bool
+	var holderMapFile string
+	var yearRange bool
+	var skipIfContains string
+	var spdx bool
+	var renameSafe bool
+	var holderSuffix string
+	var include string
+	var exclude string
+
+	flag.StringVar(&repoDir, "path", "", "directory of the git repo to use, wherever it lives, instead of -repo which is looked up under $GOPATH/src")
+	flag.BoolVar(&noGit, "no-git", false, "process a plain directory with no .git, such as an exported tree, dating every added header by -year, by its creation time on disk with -year-from-file-creation-fs, or else this year")
+	flag.IntVar(&fixedYear, "year", 0, "date every added header this year instead of blaming files for it")
+	flag.StringVar(&goRepo, "repo", "github.com/orijtech/apache2conform", "the go repo to use, or a comma separated list of them, more can be given as arguments")
+	flag.StringVar(&tmplStr, "tmpl", "apache2.0", "the license to use, see -list-licenses for the options")
+	flag.StringVar(&tmplFile, "tmpl-file", "", "file holding a custom text/template license header to use instead of -tmpl, check it with -validate-templates")
+	flag.BoolVar(&spdx, "spdx", false, "stamp the short REUSE form of the built-in license, SPDX-FileCopyrightText and SPDX-License-Identifier lines, instead of its full notice")
+	flag.BoolVar(&listLicenses, "list-licenses", false, "print the names of the built-in licenses and exit")
+	flag.StringVar(&langs, "lang", "", "comma separated languages to stamp files of, only go if empty, see -list-languages for the options")
+	flag.BoolVar(&listLanguages, "list-languages", false, "print the languages that can be stamped along with their file extensions and exit")
+	flag.StringVar(&stampExtensions, "stamp-extensions", "", "comma separated ext=style pairs of extra files to stamp e.g. files embedded with //go:embed, styles are: slash, dash, hash, c, html, xml")
+	flag.StringVar(&writeSidecar, "write-sidecar", "", "comma separated extensions of files that cannot carry comments, such as .png, to write REUSE <file>.license sidecars for instead")
+	flag.StringVar(&templateMap, "template-map", "", "comma separated ext=license pairs overriding -tmpl per file extension e.g. .proto=BSD")
+	flag.BoolVar(&validateOnly, "validate-templates", false, "only check that the chosen templates render valid headers for every stamped file extension, then exit")
+	flag.StringVar(&templateValidate, "template-validate", "", "only check that the text/template license header in this file parses and renders valid headers for every stamped file extension, then exit")
 	flag.BoolVar(&fixIt, "fix", false, "whether to add the headers")
+	flag.BoolVar(&checkOnly, "check", false, "lint mode: without blaming or writing anything, list the files that lack a license and exit non-zero if there are any, cannot be combined with -fix")
+	flag.BoolVar(&fixOnlyIfValid, "fix-only-if-all-files-valid", false, "with -fix, hold every write back until all changed Go files are known to still parse, and change nothing if any would not")
+	flag.BoolVar(&renameSafe, "rename-safe-write", false, "write each file atomically through a renamed temporary file, refusing to if the file's directory does not list it in the exact case given, as on case-insensitive filesystems")
+	flag.BoolVar(&verifyClean, "verify-git-clean", true, "with -fix, refuse to write if tracked files have uncommitted changes, on by default so set it to false or pass -force to write anyway")
+	flag.BoolVar(&force, "force", false, "write even if -verify-git-clean finds uncommitted changes")
 	flag.StringVar(&copyrightHolder, "copyright-holder", "ACME", "the name of the copyright holder")
-	flag.UintVar(&concurrency, "concurrency", 6, "controls how many files can be opened at once")
+	flag.BoolVar(&holderFromGit, "holder-from-git", false, "unless -copyright-holder or -holder-list-file is given, credit the user.name of the repo's git config or the first entry of its AUTHORS or CONTRIBUTORS file")
+	flag.StringVar(&holderListFile, "holder-list-file", "", "file listing one copyright holder per line, rendered as stacked copyright lines instead of -copyright-holder")
+	flag.BoolVar(&forceRewriteAll, "force-rewrite-all", false, "rewrite every existing header to the current template's canonical form, keeping its year")
+	flag.BoolVar(&perAuthorSpans, "per-author-year-spans", false, "instead of -copyright-holder, credit every author in a file's blame on a line of its own spanning their first to last year")
+	flag.BoolVar(&perYearHolders, "holder-per-year-from-blame", false, "instead of -copyright-holder, put a line per year in a file's blame crediting the authors active that year")
+	flag.UintVar(&holderWrapWidth, "holder-multiple-lines-wrap", 0, "split copyright lines listing several holders, as -holder-per-year-from-blame makes, into lines of the same year at most this many columns wide, 0 never splits them")
+	flag.BoolVar(&holderFromFirstAuthor, "holder-from-first-commit-author", false, "instead of -copyright-holder, credit the author of the commit that added each file, a fit for personal projects")
+	flag.BoolVar(&mailmap, "git-author-mailmap", false, "credit the authors found in history by the names the repo's .mailmap gives them, so that aliases of one person collapse")
+	flag.BoolVar(&holderFromBlame, "holder-from-blame", false, "instead of -copyright-holder, credit the author of the earliest line in each file's blame, falling back to -copyright-holder if authors tie")
+	flag.BoolVar(&updateBody, "update-license-body", false, "bring the license text of existing headers up to date with the template, keeping their copyright lines verbatim")
+	flag.BoolVar(&yearRange, "year-range", false, "date added headers from the earliest to the latest year in the file's blame e.g. 2017-2023, a single year if they are the same")
+	flag.BoolVar(&extendYears, "rewrite-copyright-year-range", false, "extend the years of existing headers in place to run up to the current one, e.g. 2017 becomes 2017-<this year>")
+	flag.BoolVar(&updateYear, "update-year", false, "rewrite the years of existing headers to the latest year in the file's blame, e.g. 2015 becomes 2020 for a file last changed in 2020")
+	flag.StringVar(&noticeLine, "notice-line", conform.NoticeLineKeep, "what to do with the \"See the NOTICE file...\" line of Apache headers, options are: keep, add (to new and existing headers), remove (from existing headers)")
+	flag.BoolVar(&restampHolder, "restamp-holder", false, "update existing headers whose copyright holder differs from the configured one")
+	flag.BoolVar(&ignoreCaseHolder, "ignore-case-holder-match", false, "with -restamp-holder, treat holders differing only in case such as \"acme\" and \"ACME\" as the same")
+	flag.BoolVar(&holderEnvExpand, "holder-env-expand", false, "expand $VAR references in the copyright holder e.g. -copyright-holder '$COMPANY'")
+	flag.BoolVar(&holderTrim, "holder-trim-whitespace", false, "trim whitespace around holders, such as that left by CI variable interpolation")
+	flag.BoolVar(&holderCollapse, "holder-collapse-spaces", false, "with -holder-trim-whitespace, also collapse runs of spaces inside holders into one")
+	flag.StringVar(&holderMapFile, "holder-canonicalization-map", "", "file of alias=canonical lines mapping holder aliases such as \"ACME Inc.\" to one spelling, used both when rendering and when comparing holders")
+	flag.StringVar(&holderSuffix, "holder-with-entity-suffix", "", "legal suffix such as \", Inc.\" or \", LLC\" to append to the holder in rendered headers, holders are compared without it")
+	flag.BoolVar(&holderSanitize, "holder-sanitize", false, "strip trailing punctuation from holders and spell the \"Inc\" suffix consistently")
+	flag.UintVar(&concurrency, "concurrency", 0, "controls how many files can be opened at once, 0 means one per CPU up to 32")
+	flag.UintVar(&maxErrors, "max-errors", 0, "stop queueing files once this many have failed, 0 means no limit")
+	flag.UintVar(&sniffBytes, "sniff-bytes", 0, "how many bytes at the start of each file to look for a license in, raise it for long headers such as the full GPL text, 0 means 624")
+	flag.Uint64Var(&progressEvery, "progress-every", 1, "update the progress line once every this many files, 0 only prints the final count")
+	flag.UintVar(&maxParallelRepos, "max-parallel-repos", 1, "controls how many of several repos are opened and processed at once")
+	flag.BoolVar(&concurrencyMetrics, "concurrency-metrics", false, "report how busy the workers were, to help tune -concurrency")
+	flag.BoolVar(&clampToUlimit, "concurrency-limit-from-ulimit", true, "cap -concurrency to a safe fraction of the open files limit")
+	flag.StringVar(&outputEncoding, "output-encoding", conform.EncodingUTF8, "encoding to render headers in for files that are not valid UTF-8, options are: utf-8 (skip such files), latin1")
+	flag.BoolVar(&showDiff, "diff", false, "preview changes by printing a unified diff of them ordered by path to stdout instead of modifying the working tree, all other output goes to stderr")
+	flag.BoolVar(&streamStatus, "dry-run-per-file-status", false, "without writing anything, print a JSON line with the status each file would end up in to stdout as soon as it is known, all other output goes to stderr")
+	flag.StringVar(&patchPath, "patch", "", "if set, write a unified diff of all changes to this file instead of modifying the working tree")
+	flag.StringVar(&exemptComment, "exempt-comment", "conform:ignore", "files whose leading comment contains this annotation are never stamped, empty disables it")
+	flag.StringVar(&skipIfContains, "skip-if-contains", "", "never stamp files whose leading comment contains this text e.g. a legal exemption phrase")
+	flag.StringVar(&requireMarker, "require-marker", "", "only stamp files whose leading comment does not contain this marker e.g. \"nolint:license\"")
+	flag.BoolVar(&onlyChangedLines, "render-only-changed-lines", false, "in -patch output only show the header lines that actually changed")
+	flag.BoolVar(&failOnZeroYear, "fail-on-placeholder-year", false, "report files that no copyright year can be found for as errors instead of leaving them without a header")
+	flag.BoolVar(&flagPlaceholders, "flag-placeholder-holders", false, "report files whose existing header names a placeholder holder such as \"ACME\" as errors")
+	flag.BoolVar(&flagConflicts, "flag-license-conflicts", true, "warn about files whose header carries notices of more than one license")
+	flag.BoolVar(&checkNotice, "check-notice", false, "warn if files carry Apache 2.0 headers but the repo has no NOTICE file at its root")
+	flag.DurationVar(&maxRuntimePerFile, "max-runtime-per-file", 0, "skip files whose blame takes longer than this e.g. 30s, 0 means no limit")
+	flag.StringVar(&blameCacheFile, "blame-cache-file", "", "file in which to remember each file's earliest year across runs, files whose contents are unchanged are not blamed again")
+	flag.BoolVar(&onlyChanged, "only-changed-since-last-run", false, "with -blame-cache-file, skip files left unchanged since the last run that had no errors")
+	flag.BoolVar(&skipMerges, "skip-merge-commits", false, "ignore lines attributed to merge commits when computing the earliest year")
+	flag.BoolVar(&yearFromCreation, "year-from-creation", false, "also consult the commit that added each file, so heavily rewritten files keep their original year")
+	flag.BoolVar(&yearFromFSCreation, "year-from-file-creation-fs", false, "date files that git has no history of, such as untracked ones, by their creation time on disk, or their modification time where the OS does not record one, as Linux before 4.11 and file systems such as tmpfs do not")
+	flag.BoolVar(&skipHidden, "walk-skip-hidden", true, "do not descend into hidden directories such as .git or .cache, on by default so set it to false to stamp files in them too")
+	flag.BoolVar(&skipIgnored, "walk-respect-gitignore", true, "skip files and directories that the repo's .gitignore files and .git/info/exclude leave out, on by default so set it to false to stamp ignored files too")
+	flag.StringVar(&include, "include", "", "comma separated globs of repo relative paths to only process e.g. 'cmd/**', where ** matches any number of directories")
+	flag.StringVar(&exclude, "exclude", "", "comma separated globs of repo relative paths to skip e.g. '*_test.go', taking precedence over -include")
+	flag.StringVar(&sinceTag, "since-tag", "", "only process files added or modified between this git tag and HEAD")
+	flag.BoolVar(&failIfWouldChange, "dry-run-fail-if-would-change", false, "without writing anything, report how many files would change and exit non-zero if any would")
+	flag.StringVar(&badgePath, "badge", "", "if set, write an SVG badge with the share of conforming files to this path for dashboards")
+	flag.BoolVar(&reportPercentage, "report-percentage", false, "print the share of the files that were not skipped which carry a license, to track a rollout over time")
+	flag.BoolVar(&treeSummary, "dry-run-tree-summary", false, "without writing anything, print the directories as a tree with how many of the files below each conform, for a quick audit")
+	flag.BoolVar(&report, "report", false, "print the status and copyright year of every file once done")
+	flag.BoolVar(&reportNonConforming, "report-only-non-conforming", false, "leave files that already conform or were skipped out of -report")
+	flag.StringVar(&reportSortBy, "report-sort-by", reportSortPath, "order of -report entries, options are: path, status, year")
+	flag.BoolVar(&dedupeBlanks, "dedupe-blank-lines-after-header", false, "leave exactly one blank line between an added header and the code that follows it")
+	flag.BoolVar(&trimHeaderSpace, "strip-trailing-whitespace-in-header", true, "strip trailing whitespace from each line of rendered headers, which gofmt and linters flag, on by default so set it to false to keep it")
+	flag.BoolVar(&noRecurse, "no-recurse", false, "only process files directly inside the repo's root directory")
+	flag.BoolVar(&normalizeWhitespace, "normalize-whitespace", false, "collapse whitespace when detecting existing licenses so that reflowed headers still match")
 	flag.Parse()
 
+	if listLicenses {
+		for _, name := range conform.LicenseNames() {
+			fmt.Println(name)
+		}
+		return
+	}
+	if listLanguages {
+		for _, lang := range conform.Languages() {
+			fmt.Printf("%s\t%s\n", lang.Name, strings.Join(lang.Extensions, " "))
+		}
+		return
+	}
+
+	if checkOnly && fixIt {
+		log.Fatal("-check and -fix cannot be combined")
+	}
+	if showDiff && streamStatus {
+		log.Fatal("-diff and -dry-run-per-file-status cannot be combined, both print to stdout")
+	}
+	// With -diff or -dry-run-per-file-status stdout only carries
+	// their output, so that it can be piped.
+	var out io.Writer = os.Stdout
+	if showDiff || streamStatus {
+		out = os.Stderr
+	}
+
+	if concurrency == 0 {
+		concurrency = conform.DefaultConcurrency()
+	}
+
 	startTime := time.Now()
 	defer func() {
-		fmt.Printf("\nTimeSpent: %s\n", time.Now().Sub(startTime))
+		fmt.Fprintf(out, "\nTimeSpent: %s\n", time.Now().Sub(startTime))
 	}()
 
-	var tmpl *template.Template
-	switch strings.ToLower(tmplStr) {
-	case "bsd":
-		tmpl = shortBSDTempl
-	default:
-		tmpl = shortApache2Point0Templ
+	tmpl, ok := conform.BuiltinTemplate(tmplStr)
+	if !ok {
+		log.Fatalf("unknown license %q for -tmpl, see -list-licenses for the options", tmplStr)
+	}
+	if tmplFile != "" {
+		var err error
+		if tmpl, err = conform.ReadTemplateFile(tmplFile); err != nil {
+			log.Fatalf("template: %v", err)
+		}
 	}
 
-	dirPath := os.ExpandEnv(filepath.Join("$GOPATH", "src", goRepo))
-	repo, err := git.PlainOpen(dirPath)
+	stampStyles, err := parsePairs(stampExtensions, "stamp extensions", "ext=style")
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	head, err := repo.Head()
+	extLicenses, err := parsePairs(templateMap, "template map", "ext=license")
 	if err != nil {
 		log.Fatal(err)
 	}
-	// First step here is to find the head hash
-	refHash := head.Hash()
-	// Start sifting through all the files
-	headCommit, err := object.GetCommit(repo.Storer, refHash)
-	if err != nil {
-		log.Fatalf("failed to get headCommit: %v", err)
-	}
-
-	jobsChan := make(chan semalim.Job)
-	go func() {
-		defer close(jobsChan)
-		goFiles := siftThroughFiles(dirPath, goLikeFile)
-		for goFile := range goFiles {
-			jobsChan <- &licenseConformer{
-				dirPath:    dirPath,
-				holder:     copyrightHolder,
-				fixIt:      fixIt,
-				filePath:   goFile,
-				headCommit: headCommit,
-				tmpl:       tmpl,
+	extTemplates := make(map[string]*template.Template)
+	for ext, name := range extLicenses {
+		extTmpl, ok := conform.BuiltinTemplate(name)
+		if !ok {
+			log.Fatalf("template map: unknown license %q for %q, see -list-licenses for the options", name, ext)
+		}
+		extTemplates[ext] = extTmpl
+	}
+
+	if spdx {
+		if tmplFile != "" {
+			log.Fatal("-spdx needs a built-in license, not -tmpl-file")
+		}
+		tmpl, _ = conform.SPDXTemplate(tmpl)
+		for ext, extTmpl := range extTemplates {
+			extTemplates[ext], _ = conform.SPDXTemplate(extTmpl)
+		}
+	}
+
+	if !validReportSort(reportSortBy) {
+		log.Fatalf("unknown -report-sort-by %q, options are: path, status, year", reportSortBy)
+	}
+	if perAuthorSpans && perYearHolders {
+		log.Fatal("-per-author-year-spans and -holder-per-year-from-blame cannot be combined")
+	}
+	if holderFromFirstAuthor && (perAuthorSpans || perYearHolders) {
+		log.Fatal("-holder-from-first-commit-author cannot be combined with -per-author-year-spans or -holder-per-year-from-blame")
+	}
+	if holderFromBlame && (holderFromFirstAuthor || perAuthorSpans || perYearHolders) {
+		log.Fatal("-holder-from-blame cannot be combined with -holder-from-first-commit-author, -per-author-year-spans or -holder-per-year-from-blame")
+	}
+	if onlyChanged && blameCacheFile == "" {
+		log.Fatal("-only-changed-since-last-run needs -blame-cache-file to remember the last run in")
+	}
+
+	var holderFilters []func(string) string
+	if holderEnvExpand {
+		holderFilters = append(holderFilters, os.ExpandEnv)
+	}
+	if holderTrim {
+		holderFilters = append(holderFilters, strings.TrimSpace)
+		if holderCollapse {
+			holderFilters = append(holderFilters, conform.CollapseSpaces)
+		}
+	}
+	if holderSanitize {
+		holderFilters = append(holderFilters, conform.SanitizeHolder)
+	}
+	if holderMapFile != "" {
+		aliases, err := readHolderMap(holderMapFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		holderFilters = append(holderFilters, conform.CanonicalHolders(aliases))
+	}
+
+	copyrightHolders := []string{copyrightHolder}
+	if holderFromGit {
+		// Leave the holder to Conform unless one was given explicitly.
+		copyrightHolders = nil
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "copyright-holder" {
+				copyrightHolders = []string{copyrightHolder}
 			}
+		})
+	}
+	if holderListFile != "" {
+		copyrightHolders, err = readHolderList(holderListFile)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}()
+	}
+
+	// Repos given as arguments add to -repo, or replace its default.
+	repos := strings.Split(goRepo, ",")
+	if flag.NArg() > 0 {
+		repoSet := false
+		flag.Visit(func(f *flag.Flag) { repoSet = repoSet || f.Name == "repo" })
+		if !repoSet {
+			repos = nil
+		}
+		repos = append(repos, flag.Args()...)
+	}
+	var dirPaths []string
+	for _, repo := range repos {
+		if repo = strings.TrimSpace(repo); repo != "" {
+			dirPaths = append(dirPaths, os.ExpandEnv(filepath.Join("$GOPATH", "src", repo)))
+		}
+	}
+	if repoDir != "" {
+		// -path names the repo directly, wherever it lives.
+		if flag.NArg() > 0 {
+			log.Fatal("-path cannot be combined with repos given as arguments")
+		}
+		abs, err := filepath.Abs(repoDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dirPaths = []string{abs}
+	}
+	if len(dirPaths) == 0 {
+		log.Fatal("-repo names no repo")
+	}
+	// Paths are shown relative to the repo or, with several of them,
+	// to where they all live so that their files can be told apart.
+	dirPath := dirPaths[0]
+	if len(dirPaths) > 1 {
+		if patchPath != "" {
+			log.Fatal("-patch writes a single patch for git apply and cannot be combined with several repos")
+		}
+		dirPath = os.ExpandEnv(filepath.Join("$GOPATH", "src"))
+	}
+	if maxParallelRepos == 0 {
+		log.Fatal("-max-parallel-repos must be at least 1")
+	}
+	if maxParallelRepos > 1 && len(dirPaths) > 1 && blameCacheFile != "" {
+		log.Fatal("-blame-cache-file cannot be shared by repos processed in parallel, use -max-parallel-repos=1")
+	}
+	if limit, ok := fileDescriptorLimit(); clampToUlimit && ok {
+		// Repos processed in parallel share the limit.
+		parallel := reposAtOnce(maxParallelRepos, len(dirPaths))
+		if clamped := clampConcurrency(concurrency, limit/parallel); clamped != concurrency {
+			shared := ""
+			if parallel > 1 {
+				shared = fmt.Sprintf(" shared by %d repos", parallel)
+			}
+			log.Printf("clamping concurrency from %d to %d given an open files limit of %d%s", concurrency, clamped, limit, shared)
+			concurrency = clamped
+		}
+	}
+	// In a dry run changes are computed as if fixing but never written.
+	dryRun := patchPath != "" || showDiff || streamStatus || failIfWouldChange || treeSummary
+	opts := conform.Options{
+		RepoPath:              dirPaths[0],
+		Holders:               copyrightHolders,
+		HolderFromGit:         holderFromGit,
+		HolderFilters:         holderFilters,
+		HolderSuffix:          holderSuffix,
+		Template:              tmpl,
+		ExtTemplates:          extTemplates,
+		Concurrency:           concurrency,
+		Fix:                   fixIt,
+		Check:                 checkOnly,
+		DryRun:                dryRun,
+		Patch:                 patchPath != "" || showDiff,
+		MinimalDiff:           onlyChangedLines,
+		FixOnlyIfValid:        fixOnlyIfValid,
+		VerifyClean:           verifyClean && !force,
+		RenameSafeWrite:       renameSafe,
+		Languages:             strings.Split(langs, ","),
+		StampExtensions:       stampStyles,
+		SidecarExtensions:     strings.Split(writeSidecar, ","),
+		NormalizeWhitespace:   normalizeWhitespace,
+		NoRecurse:             noRecurse,
+		SkipHidden:            skipHidden,
+		SkipIgnored:           skipIgnored,
+		SinceTag:              sinceTag,
+		Include:               strings.Split(include, ","),
+		Exclude:               strings.Split(exclude, ","),
+		SkipMarkers:           []string{exemptComment, requireMarker, skipIfContains},
+		YearFromCreation:      yearFromCreation,
+		Year:                  fixedYear,
+		NoGit:                 noGit,
+		YearFromFSCreation:    yearFromFSCreation,
+		SkipMerges:            skipMerges,
+		FlagPlaceholders:      flagPlaceholders,
+		FailOnZeroYear:        failOnZeroYear,
+		FlagConflicts:         flagConflicts,
+		RestampHolder:         restampHolder,
+		IgnoreCaseHolder:      ignoreCaseHolder,
+		RewriteAll:            forceRewriteAll,
+		UpdateBody:            updateBody,
+		ExtendYearRanges:      extendYears,
+		UpdateYear:            updateYear,
+		NoticeLine:            noticeLine,
+		DedupeBlanks:          dedupeBlanks,
+		TrimHeaderSpace:       trimHeaderSpace,
+		PerAuthorSpans:        perAuthorSpans,
+		PerYearHolders:        perYearHolders,
+		HolderWrapWidth:       holderWrapWidth,
+		HolderFromFirstAuthor: holderFromFirstAuthor,
+		HolderFromBlame:       holderFromBlame,
+		Mailmap:               mailmap,
+		YearRange:             yearRange,
+		BlameCacheFile:        blameCacheFile,
+		OnlyChanged:           onlyChanged,
+		BlameTimeout:          maxRuntimePerFile,
+		Encoding:              outputEncoding,
+		MaxErrors:             maxErrors,
+		SniffBytes:            sniffBytes,
+		Metrics:               concurrencyMetrics,
+		Logf:                  log.Printf,
+	}
 
-	resChan := semalim.Run(jobsChan, uint64(concurrency))
-	nTotal := uint64(0)
-	nGood := uint64(0)
-	nBad := uint64(0)
-	nAddLicense := uint64(0)
-	for res := range resChan {
-		added, err, path := res.Value().(bool), res.Err(), res.Id().(string)
-		if added {
+	if templateValidate != "" {
+		custom, err := conform.ReadTemplateFile(templateValidate)
+		if err != nil {
+			log.Fatalf("template: %v", err)
+		}
+		opts.Template, opts.ExtTemplates = custom, nil
+		validateOnly = true
+	}
+
+	if validateOnly {
+		checked, problems, err := conform.ValidateTemplates(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, problem := range problems {
+			log.Printf("template: %s", problem)
+		}
+		if len(problems) > 0 {
+			log.Fatalf("%d template problems found", len(problems))
+		}
+		fmt.Fprintf(out, "templates render valid headers for %d file extensions\n", checked)
+		return
+	}
+
+	nTotal, nGood, nBad, nAddLicense := uint64(0), uint64(0), uint64(0), uint64(0)
+	printProgress := func() {
+		fmt.Fprintf(out, "Total: %d:: AddedLicenses: %d AlreadyHaveLicenses: %d Errors: %d\r",
+			nTotal, nAddLicense, nGood, nBad)
+	}
+	statusLines := json.NewEncoder(os.Stdout)
+	// Repos processed in parallel report their files concurrently.
+	var resultsMu sync.Mutex
+	opts.OnResult = func(fr *conform.FileResult) {
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+		if streamStatus {
+			if err := statusLines.Encode(newStatusLine(dirPath, fr)); err != nil {
+				log.Fatalf("failed to write status: %v", err)
+			}
+		}
+		switch {
+		case fr.Added:
 			nAddLicense += 1
-		} else if err != nil {
-			log.Printf("err:: %q: %v", path, err)
+		case fr.Err != nil:
+			log.Printf("err:: %q: %v", fr.Path, fr.Err)
 			nBad += 1
-		} else {
+		default:
 			nGood += 1
 		}
 		nTotal += 1
-		fmt.Printf("Total: %d:: AddedLicenses: %d AlreadyHaveLicenses: %d Errors: %d\r",
-			nTotal, nAddLicense, nGood, nBad)
-
+		if progressEvery > 0 && nTotal%progressEvery == 0 {
+			printProgress()
+		}
 	}
-}
-
-type licenseConformer struct {
-	holder     string
-	dirPath    string
-	filePath   string
-	fixIt      bool
-	headCommit *object.Commit
-	tmpl       *template.Template
-}
-
-var _ semalim.Job = (*licenseConformer)(nil)
 
-func (lc *licenseConformer) Id() interface{} { return lc.filePath }
-
-func (lc *licenseConformer) Do() (res interface{}, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			stack := make([]byte, 1024*4)
-			runtime.Stack(stack, true)
-			err = fmt.Errorf("%s", stack)
+	rep := new(conform.Report)
+	apacheFiles := make(map[string]int)
+	results := conformRepos(opts, dirPath, dirPaths, maxParallelRepos)
+	for _, repoPath := range dirPaths {
+		rr := results[repoPath]
+		if rr == nil {
+			// Not started as an earlier repo came to a stop.
+			continue
 		}
-	}()
-	res = false
+		repoRep, rerr := rr.rep, rr.err
+		if ue, ok := rerr.(*conform.UncommittedError); ok {
+			log.Fatalf("%v; commit or stash them, or rerun with -force", ue)
+		}
+		if repoRep == nil && len(dirPaths) > 1 {
+			log.Fatalf("%s: %v", repoPath, rerr)
+		}
+		if repoRep == nil {
+			log.Fatal(rerr)
+		}
+		if rerr != nil && err == nil {
+			err = rerr
+		}
+		rep.Merge(repoRep)
+		apacheFiles[repoPath] = repoRep.Apache
+	}
+	if progressEvery == 0 || nTotal%progressEvery != 0 {
+		printProgress()
+	}
 
-	goFile := lc.filePath
-	fixIt := lc.fixIt
-	headCommit := lc.headCommit
-	copyrightHolder := lc.holder
-	dirPath := lc.dirPath
+	if report {
+		var entries []*reportEntry
+		for _, fr := range rep.Files {
+			if entry := newReportEntry(dirPath, fr); !(reportNonConforming && entry.conforming()) {
+				entries = append(entries, entry)
+			}
+		}
+		fmt.Fprintln(out)
+		writeReport(out, entries, reportSortBy)
+	}
 
-	sniff, f, potentiallyConformsToLicense, err := sniffIfHasLicense(goFile, containsALicense)
-	if err != nil {
-		if f != nil {
-			f.Close()
+	if treeSummary {
+		entries := make([]*reportEntry, 0, len(rep.Files))
+		for _, fr := range rep.Files {
+			entries = append(entries, newReportEntry(dirPath, fr))
 		}
-		return false, err
+		fmt.Fprintln(out)
+		writeTreeSummary(out, entries)
 	}
 
-	if potentiallyConformsToLicense || autoGenerated(sniff) {
-		// Well good, move onto the next one
-		f.Close()
-		return false, nil
+	if reportPercentage {
+		conforming, considered := conformingCounts(rep, fixIt && !dryRun)
+		percent := 100.0
+		if considered > 0 {
+			percent = float64(conforming) * 100 / float64(considered)
+		}
+		fmt.Fprintf(out, "\nConforming: %.1f%% (%d of %d files)", percent, conforming, considered)
 	}
 
-	relToRootPath, _ := filepath.Rel(dirPath, goFile)
-	if err != nil {
-		return false, err
+	if badgePath != "" {
+		percent := conformingPercent(conformingCounts(rep, fixIt && !dryRun))
+		if err := writeBadge(badgePath, percent); err != nil {
+			log.Printf("\nfailed to write badge: %v", err)
+		}
 	}
-	blame, err := git.Blame(headCommit, relToRootPath)
-	if err != nil {
-		return false, err
+
+	if concurrencyMetrics {
+		fmt.Fprintln(out)
+		rep.WriteMetrics(out)
 	}
-	// Next step is to run gitBlame and figure out
-	// the earliest date of addition of the file
-	earliestTime := time.Now()
-	for _, line := range blame.Lines {
-		if commitTime := line.When; commitTime.After(blankTime) && commitTime.Before(earliestTime) {
-			earliestTime = commitTime
+
+	for _, repoPath := range dirPaths {
+		if n := apacheFiles[repoPath]; checkNotice && n > 0 && !conform.HasNoticeFile(repoPath) {
+			log.Printf("\nwarning: %d files carry Apache 2.0 headers but %q has no NOTICE file", n, repoPath)
 		}
 	}
-	canEdit := fixIt && earliestTime.After(blankTime)
-	if !canEdit {
-		return false, nil
+
+	if patchPath != "" {
+		if err := rep.WritePatch(patchPath); err != nil {
+			log.Fatalf("failed to write patch: %v", err)
+		}
 	}
-	buf := new(bytes.Buffer)
-	info := &copyright{
-		Year: earliestTime.Year(),
 
-		Holder: copyrightHolder,
+	if showDiff {
+		if err := rep.WriteDiffs(os.Stdout); err != nil {
+			log.Fatalf("failed to write diff: %v", err)
+		}
 	}
-	if err := lc.tmpl.Execute(buf, info); err != nil {
-		return false, err
+
+	if len(rep.Unparsable) > 0 {
+		fmt.Fprintln(out)
+		for _, problem := range rep.Unparsable {
+			log.Print(problem)
+		}
+		log.Fatalf("%d files would no longer parse, no files were changed", len(rep.Unparsable))
 	}
-	// Next step is to concatenate the (license, sniff, rest)
-	wholeFileWithLicense, err := ioutil.ReadAll(io.MultiReader(
-		buf,
-		bytes.NewReader(sniff),
-		f,
-	))
-	_ = f.Close()
 	if err != nil {
-		return false, err
+		log.Fatalf("\n%v", err)
 	}
-	// Now write the properly licensed file to disk
-	wf, err := os.Create(goFile)
-	if err != nil {
-		return false, err
+
+	if failIfWouldChange && rep.Added > 0 {
+		var wouldChange []string
+		for _, fr := range rep.Files {
+			if fr.Added {
+				wouldChange = append(wouldChange, newReportEntry(dirPath, fr).path)
+			}
+		}
+		sort.Strings(wouldChange)
+		fmt.Fprintln(out)
+		for _, path := range wouldChange {
+			fmt.Fprintln(out, path)
+		}
+		log.Fatalf("%d files would change", len(wouldChange))
 	}
-	wf.Write(wholeFileWithLicense)
-	wf.Close()
-	return true, nil
-}
 
-type copyright struct {
-	Year int
+	if checkOnly && (rep.Statuses[conform.StatusMissing] > 0 || rep.Errors > 0) {
+		var missing []string
+		for _, fr := range rep.Files {
+			if fr.Status == conform.StatusMissing {
+				missing = append(missing, newReportEntry(dirPath, fr).path)
+			}
+		}
+		sort.Strings(missing)
+		fmt.Fprintln(out)
+		for _, path := range missing {
+			fmt.Fprintln(out, path)
+		}
+		log.Fatalf("%d files lack a license, %d could not be checked", len(missing), rep.Errors)
+	}
 
-	Holder string
+	if rep.Aborted {
+		log.Fatalf("\naborted after %d errors", rep.Errors)
+	}
 }
 
-var apacheLicenseURL = []byte("http://www.apache.org/licenses/LICENSE-2.0")
-var doNotEdit = []byte("DO NOT EDIT!")
-var allRightsReservedLower = []byte("all rights reserved")
+// parsePairs parses comma separated key=value pairs such as the
+// ext=style ones of -stamp-extensions, what names the flag in errors.
+func parsePairs(spec, what, form string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		eq := strings.Index(pair, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("%s entry %q is not of the form %s", what, pair, form)
+		}
+		pairs[strings.TrimSpace(pair[:eq])] = strings.TrimSpace(pair[eq+1:])
+	}
+	return pairs, nil
+}
 
-func containsALicense(b []byte) bool {
-	return bytes.Contains(bytes.ToLower(b), allRightsReservedLower) || bytes.Contains(b, apacheLicenseURL)
+// fileDescriptorsPerWorker is a conservative estimate of how many files
+// a worker can hold open at once, its source file plus whatever the git
+// object store is reading for blame.
+const fileDescriptorsPerWorker = 4
+
+// clampConcurrency caps concurrency so that all workers
+// together stay within limit open files.
+func clampConcurrency(concurrency uint, limit uint64) uint {
+	max := limit / fileDescriptorsPerWorker
+	if max < 1 {
+		max = 1
+	}
+	if uint64(concurrency) > max {
+		return uint(max)
+	}
+	return concurrency
 }
 
-func autoGenerated(b []byte) bool { return bytes.Contains(b, doNotEdit) }
+// reposAtOnce is how many of n repos are processed at once given
+// -max-parallel-repos, and so how many share the open files limit.
+func reposAtOnce(maxParallel uint, n int) uint64 {
+	if uint64(n) < uint64(maxParallel) {
+		return uint64(n)
+	}
+	return uint64(maxParallel)
+}
 
-func sniffIfHasLicense(p string, contains func([]byte) bool) ([]byte, io.ReadCloser, bool, error) {
-	f, err := os.Open(p)
+// readHolderList reads one copyright holder per line from path,
+// skipping blank lines and lines starting with '#'.
+func readHolderList(path string) ([]string, error) {
+	blob, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, nil, false, err
+		return nil, err
 	}
-
-	headerBlob := make([]byte, approxShortHeaderSize)
-	if _, err := io.ReadAtLeast(f, headerBlob, 1); err != nil {
-		return nil, nil, false, err
+	var holders []string
+	for _, line := range strings.Split(string(blob), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			holders = append(holders, line)
+		}
+	}
+	if len(holders) == 0 {
+		return nil, fmt.Errorf("%s: no copyright holders listed", path)
 	}
-	return headerBlob, f, contains(headerBlob), nil
+	return holders, nil
 }
 
-func siftThroughFiles(root string, match func(string, os.FileInfo) bool) chan string {
-	filesChan := make(chan string)
-	go func() {
-		defer close(filesChan)
-		filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
-			if err == nil && match(path, fi) {
-				filesChan <- path
-			}
-			return err
-		})
-	}()
-	return filesChan
+// readHolderMap reads alias=canonical lines from path, skipping
+// blank lines and lines starting with '#'.
+func readHolderMap(path string) (map[string]string, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string)
+	for i, line := range strings.Split(string(blob), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("%s:%d: %q is not of the form alias=canonical", path, i+1, line)
+		}
+		aliases[strings.TrimSpace(line[:eq])] = strings.TrimSpace(line[eq+1:])
+	}
+	return aliases, nil
 }
-
-const approxShortHeaderSize = 624
-
-var shortBSD = `// Copyright {{.Year}} {{.Holder}}. All rights reserved.
-// Use of this source code is governed by a BSD-style
-// license that can be found in the LICENSE file.
-
-`
-
-var shortApache2Point0 = `// Copyright {{.Year}} {{.Holder}}. All Rights Reserved.
-//
-// Licensed under the Apache License, Version 2.0 (the "License");
-// you may not use this file except in compliance with the License.
-// You may obtain a copy of the License at
-//
-//      http://www.apache.org/licenses/LICENSE-2.0
-//
-// Unless required by applicable law or agreed to in writing, software
-// distributed under the License is distributed on an "AS IS" BASIS,
-// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-// See the License for the specific language governing permissions and
-// limitations under the License.
-
-`
-var shortApache2Point0Templ = template.Must(template.New("apache2.0").Parse(shortApache2Point0))
-var shortBSDTempl = template.Must(template.New("BSD").Parse(shortBSD))
@@ -0,0 +1,1525 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orijtech/apache2conform/conform"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// mainArgsEnv, when set in the environment of the test binary, holds
+// newline separated arguments to run main with instead of the tests.
+const mainArgsEnv = "APACHE2CONFORM_MAIN_ARGS"
+
+func TestMain(m *testing.M) {
+	if args := os.Getenv(mainArgsEnv); args != "" {
+		os.Args = append([]string{"apache2conform"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs main with args in a child process that also has env
+// set, returning its combined output and whether it exited successfully.
+func runMain(t *testing.T, env []string, args ...string) (string, bool) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, mainArgsEnv+"="+strings.Join(args, "\n"))
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+	return string(out), err == nil
+}
+
+// tempDir returns a new empty directory and the func that removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "apache2conform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeFiles writes files, keyed by their slash separated
+// paths relative to dir, creating directories as needed.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for relPath, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, dir, relPath string) string {
+	blob, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(relPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(blob)
+}
+
+// testImportPath is where testRepo puts its repo in its GOPATH.
+const testImportPath = "example.com/repo"
+
+// testRepo is a git repo in a temporary GOPATH
+// that tests commit their fixtures to.
+type testRepo struct {
+	t      *testing.T
+	gopath string
+	dir    string
+	repo   *git.Repository
+}
+
+func newTestRepo(t *testing.T) (*testRepo, func()) {
+	gopath, cleanup := tempDir(t)
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(testImportPath))
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, gopath: gopath, dir: dir, repo: repo}, cleanup
+}
+
+// commit writes files and commits them as author at when.
+func (tr *testRepo) commit(author string, when time.Time, files map[string]string) plumbing.Hash {
+	return tr.merge(author, when, files)
+}
+
+// merge is commit with the given parents instead of HEAD.
+func (tr *testRepo) merge(author string, when time.Time, files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+	writeFiles(tr.t, tr.dir, files)
+	wt, err := tr.repo.Worktree()
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	for relPath := range files {
+		if _, err := wt.Add(relPath); err != nil {
+			tr.t.Fatal(err)
+		}
+	}
+	sig := &object.Signature{Name: author, Email: strings.ToLower(strings.Replace(author, " ", ".", -1)) + "@example.com", When: when}
+	hash, err := wt.Commit("Update "+author, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents})
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return hash
+}
+
+func (tr *testRepo) read(relPath string) string { return readFile(tr.t, tr.dir, relPath) }
+
+func (tr *testRepo) head() *object.Commit {
+	ref, err := tr.repo.Head()
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	commit, err := tr.repo.CommitObject(ref.Hash())
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return commit
+}
+
+// run runs main over the repo with args.
+func (tr *testRepo) run(args ...string) (string, bool) {
+	return runMain(tr.t, []string{"GOPATH=" + tr.gopath}, append([]string{"-repo", testImportPath}, args...)...)
+}
+
+// runStdout is run with stdout and stderr kept apart.
+func (tr *testRepo) runStdout(args ...string) (stdout, stderr string, ok bool) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "GOPATH="+tr.gopath)
+	cmd.Env = append(cmd.Env, mainArgsEnv+"="+strings.Join(append([]string{"-repo", testImportPath}, args...), "\n"))
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	out, err := cmd.Output()
+	if _, isExit := err.(*exec.ExitError); err != nil && !isExit {
+		tr.t.Fatal(err)
+	}
+	return string(out), errBuf.String(), err == nil
+}
+
+// inYear is a time during year.
+func inYear(year int) time.Time { return time.Date(year, time.June, 1, 12, 0, 0, 0, time.UTC) }
+
+// testSource is the source of an unlicensed Go file.
+const testSource = `package a
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Greeter greets people by name.
+type Greeter struct {
+	// Greeting is said before each name, "Hello" if empty.
+	Greeting string
+	// Shout makes the whole greeting upper case.
+	Shout bool
+}
+
+// Greet returns the greeting for name.
+func (g *Greeter) Greet(name string) string {
+	greeting := g.Greeting
+	if greeting == "" {
+		greeting = "Hello"
+	}
+	msg := fmt.Sprintf("%s, %s!", greeting, name)
+	if g.Shout {
+		msg = strings.ToUpper(msg)
+	}
+	return msg
+}
+
+// GreetAll greets every one of names on a line of its own.
+func (g *Greeter) GreetAll(names ...string) string {
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, g.Greet(name))
+	}
+	return strings.Join(lines, "\n")
+}
+`
+
+// testCopyright mirrors the fields that license templates refer to.
+type testCopyright struct {
+	Year   int
+	Holder string
+	Lines  []testCopyright
+}
+
+// renderTestHeader renders the built-in license name for year and holder.
+func renderTestHeader(t *testing.T, name string, year int, holder string) string {
+	tmpl, ok := conform.BuiltinTemplate(name)
+	if !ok {
+		t.Fatalf("no built-in license %q", name)
+	}
+	buf := new(bytes.Buffer)
+	line := testCopyright{Year: year, Holder: holder}
+	if err := tmpl.Execute(buf, &testCopyright{Year: year, Holder: holder, Lines: []testCopyright{line}}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCheckNotice(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		args        []string
+		wantWarning bool
+	}{
+		{name: "Apache headers without a NOTICE", files: map[string]string{"a.go": "package a\n"}, wantWarning: true},
+		{name: "Apache headers with a NOTICE", files: map[string]string{"a.go": "package a\n", "NOTICE": "ACME\n"}},
+		{name: "NOTICE.md", files: map[string]string{"a.go": "package a\n", "NOTICE.md": "ACME\n"}},
+		{name: "BSD headers", files: map[string]string{"a.go": "package a\n"}, args: []string{"-tmpl", "BSD"}},
+		{name: "existing Apache headers", files: map[string]string{"a.go": renderTestHeader(t, "apache2.0", 2014, "ACME") + "package a\n"}, wantWarning: true},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), tt.files)
+		out, ok := tr.run(append([]string{"-fix", "-check-notice"}, tt.args...)...)
+		if !ok {
+			t.Fatalf("%s: main failed:\n%s", tt.name, out)
+		}
+		if got := strings.Contains(out, "has no NOTICE file"); got != tt.wantWarning {
+			t.Errorf("%s: got warning %v, want %v, output:\n%s", tt.name, got, tt.wantWarning, out)
+		}
+	}
+}
+
+func TestHolderSanitizeFlag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2015), map[string]string{"a.go": "package a\n"})
+	if out, ok := tr.run("-fix", "-holder-sanitize", "-copyright-holder", "ACME inc."); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	if got, want := tr.read("a.go"), "// Copyright 2015 ACME Inc. All Rights Reserved.\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got header\n%s\nwant it to start with %q", got, want)
+	}
+}
+
+func TestRequireMarker(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	files := map[string]string{
+		"marked.go":    "// nolint:license\n\n" + testSource,
+		"body.go":      testSource + "\n// nolint:license\n",
+		"unmarked.go":  testSource,
+		"block/not.go": "/* Generated by hand, nolint:license */\n" + testSource,
+	}
+	tr.commit("Alice", inYear(2015), files)
+	if out, ok := tr.run("-fix", "-require-marker", "nolint:license"); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	header := renderTestHeader(t, "apache2.0", 2015, "ACME")
+	for relPath, want := range map[string]string{
+		"marked.go":    files["marked.go"],
+		"body.go":      header + files["body.go"],
+		"unmarked.go":  header + files["unmarked.go"],
+		"block/not.go": files["block/not.go"],
+	} {
+		if got := tr.read(relPath); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", relPath, got, want)
+		}
+	}
+}
+
+func TestExemptComment(t *testing.T) {
+	ignored := "// conform:ignore\n\n" + testSource
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "default annotation", want: ignored},
+		{name: "custom annotation", args: []string{"-exempt-comment", "license:skip"}, want: renderTestHeader(t, "apache2.0", 2015, "ACME") + ignored},
+		{name: "disabled", args: []string{"-exempt-comment", ""}, want: renderTestHeader(t, "apache2.0", 2015, "ACME") + ignored},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": ignored})
+		if out, ok := tr.run(append([]string{"-fix"}, tt.args...)...); !ok {
+			t.Fatalf("%s: main failed:\n%s", tt.name, out)
+		}
+		if got := tr.read("a.go"); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExemptCommentRewriteModes(t *testing.T) {
+	files := map[string]string{
+		"stamped.go":   "// conform:ignore\n//\n" + renderTestHeader(t, "apache2.0", 2012, "Someone Else") + testSource,
+		"truncated.go": "// conform:ignore\n//\n// Copyright 2012 Someone Else\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n\n" + testSource,
+	}
+	for _, args := range [][]string{
+		{"-restamp-holder"},
+		{"-force-rewrite-all"},
+		{"-update-license-body"},
+		{"-rewrite-copyright-year-range"},
+		{"-update-year"},
+		{"-notice-line", "add"},
+		nil,
+	} {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), files)
+		if out, ok := tr.run(append([]string{"-fix"}, args...)...); !ok {
+			t.Fatalf("%q: main failed:\n%s", args, out)
+		}
+		for relPath, want := range files {
+			if got := tr.read(relPath); got != want {
+				t.Errorf("%q: %s: got\n%s\nwant it left alone", args, relPath, got)
+			}
+		}
+	}
+}
+
+func TestSkipIfContains(t *testing.T) {
+	phrase := "Released into the public domain."
+	files := map[string]string{
+		"exempt.go": "// " + phrase + "\n\n" + testSource,
+		"body.go":   testSource + "\n// " + phrase + "\n",
+		"plain.go":  testSource,
+	}
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2015), files)
+	out, ok := tr.run("-fix", "-skip-if-contains", phrase)
+	if !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	header := renderTestHeader(t, "apache2.0", 2015, "ACME")
+	for rel, want := range map[string]string{"exempt.go": files["exempt.go"], "body.go": header + files["body.go"], "plain.go": header + testSource} {
+		if got := tr.read(rel); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", rel, got, want)
+		}
+	}
+}
+
+func TestYearFromCreation(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantYear int
+	}{
+		{wantYear: 2016},
+		{args: []string{"-year-from-creation"}, wantYear: 2012},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2012), map[string]string{"a.go": "package b\n"})
+		tr.commit("Bob", inYear(2016), map[string]string{"a.go": testSource})
+		if out, ok := tr.run(append([]string{"-fix"}, tt.args...)...); !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		if got, want := tr.read("a.go"), renderTestHeader(t, "apache2.0", tt.wantYear, "ACME")+testSource; got != want {
+			t.Errorf("%q: got\n%s\nwant\n%s", tt.args, got, want)
+		}
+	}
+}
+
+func TestHolderEnvExpand(t *testing.T) {
+	os.Setenv("APACHE2CONFORM_TEST_COMPANY", "Globex")
+	defer os.Unsetenv("APACHE2CONFORM_TEST_COMPANY")
+	tests := []struct {
+		args       []string
+		wantHolder string
+	}{
+		{args: []string{"-holder-env-expand", "-copyright-holder", "$APACHE2CONFORM_TEST_COMPANY Corp"}, wantHolder: "Globex Corp"},
+		{args: []string{"-holder-env-expand", "-copyright-holder", "${APACHE2CONFORM_TEST_COMPANY}"}, wantHolder: "Globex"},
+		{args: []string{"-copyright-holder", "$APACHE2CONFORM_TEST_COMPANY"}, wantHolder: "$APACHE2CONFORM_TEST_COMPANY"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource})
+		if out, ok := tr.run(append([]string{"-fix"}, tt.args...)...); !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		if got, want := tr.read("a.go"), renderTestHeader(t, "apache2.0", 2015, tt.wantHolder)+testSource; got != want {
+			t.Errorf("%q: got\n%s\nwant\n%s", tt.args, got, want)
+		}
+	}
+}
+
+func TestHolderTrimWhitespace(t *testing.T) {
+	tests := []struct {
+		args       []string
+		wantHolder string
+	}{
+		{args: []string{"-copyright-holder", " ACME  Corp "}, wantHolder: " ACME  Corp "},
+		{args: []string{"-holder-trim-whitespace", "-copyright-holder", " ACME  Corp \n"}, wantHolder: "ACME  Corp"},
+		{args: []string{"-holder-trim-whitespace", "-holder-collapse-spaces", "-copyright-holder", " ACME \t Corp "}, wantHolder: "ACME Corp"},
+		// Collapsing only applies along with trimming.
+		{args: []string{"-holder-collapse-spaces", "-copyright-holder", "ACME  Corp"}, wantHolder: "ACME  Corp"},
+		{args: []string{"-holder-trim-whitespace", "-holder-sanitize", "-copyright-holder", " ACME inc. "}, wantHolder: "ACME Inc"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource})
+		if out, ok := tr.run(append([]string{"-fix"}, tt.args...)...); !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		if got, want := tr.read("a.go"), renderTestHeader(t, "apache2.0", 2015, tt.wantHolder)+testSource; got != want {
+			t.Errorf("%q: got\n%s\nwant\n%s", tt.args, got, want)
+		}
+	}
+}
+
+func TestFlagPlaceholderHolders(t *testing.T) {
+	tests := []struct {
+		holder    string
+		args      []string
+		wantError bool
+	}{
+		{holder: "ACME", args: []string{"-flag-placeholder-holders"}, wantError: true},
+		{holder: "your company", args: []string{"-flag-placeholder-holders"}, wantError: true},
+		{holder: "Globex Corp", args: []string{"-flag-placeholder-holders"}},
+		{holder: "ACME"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": renderTestHeader(t, "apache2.0", 2014, tt.holder) + testSource})
+		out, ok := tr.run(tt.args...)
+		if !ok {
+			t.Fatalf("%s %q: main failed:\n%s", tt.holder, tt.args, out)
+		}
+		if got := strings.Contains(out, "placeholder copyright holder"); got != tt.wantError {
+			t.Errorf("%s %q: got error %v, want %v, output:\n%s", tt.holder, tt.args, got, tt.wantError, out)
+		}
+	}
+}
+
+func TestClampConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency uint
+		limit       uint64
+		want        uint
+	}{
+		{name: "high limit", concurrency: 32, limit: 1 << 20, want: 32},
+		{name: "low limit", concurrency: 32, limit: 64, want: 16},
+		{name: "limit at the edge", concurrency: 16, limit: 64, want: 16},
+		{name: "limit below one worker", concurrency: 8, limit: 3, want: 1},
+		{name: "no files at all", concurrency: 8, limit: 0, want: 1},
+	}
+	for _, tt := range tests {
+		if got := clampConcurrency(tt.concurrency, tt.limit); got != tt.want {
+			t.Errorf("%s: clampConcurrency(%d, %d) = %d, want %d", tt.name, tt.concurrency, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestReposAtOnce(t *testing.T) {
+	tests := []struct {
+		maxParallel uint
+		repos       int
+		want        uint64
+	}{
+		{maxParallel: 1, repos: 3, want: 1},
+		{maxParallel: 2, repos: 3, want: 2},
+		// Only as many repos as there are can run at once.
+		{maxParallel: 4, repos: 2, want: 2},
+		{maxParallel: 4, repos: 1, want: 1},
+	}
+	for _, tt := range tests {
+		got := reposAtOnce(tt.maxParallel, tt.repos)
+		if got != tt.want {
+			t.Errorf("reposAtOnce(%d, %d) = %d, want %d", tt.maxParallel, tt.repos, got, tt.want)
+		}
+		// The limit of 64 open files is split between those repos.
+		if clamped, want := clampConcurrency(32, 64/got), uint(16/tt.want); clamped != want {
+			t.Errorf("%d repos at once: got concurrency %d, want %d", got, clamped, want)
+		}
+	}
+}
+
+func TestWalkSkipHidden(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantStamped bool
+	}{
+		{wantStamped: false},
+		{args: []string{"-walk-skip-hidden=false"}, wantStamped: true},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{
+			"a.go":             testSource,
+			".cache/b.go":      testSource,
+			"sub/.deep/c/d.go": testSource,
+		})
+		if out, ok := tr.run(append([]string{"-fix"}, tt.args...)...); !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		header := renderTestHeader(t, "apache2.0", 2015, "ACME")
+		if got := tr.read("a.go"); got != header+testSource {
+			t.Errorf("%q: a.go was not stamped", tt.args)
+		}
+		for _, relPath := range []string{".cache/b.go", "sub/.deep/c/d.go"} {
+			if got := tr.read(relPath) != testSource; got != tt.wantStamped {
+				t.Errorf("%q: %s stamped %v, want %v", tt.args, relPath, got, tt.wantStamped)
+			}
+		}
+	}
+}
+
+func TestWalkRespectGitignore(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantStamped bool
+	}{
+		{wantStamped: false},
+		{args: []string{"-walk-respect-gitignore=false"}, wantStamped: true},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{
+			".gitignore":     "build/\n*.gen.go\n",
+			"sub/.gitignore": "local.go\n",
+			"a.go":           testSource,
+			"sub/local.go":   testSource,
+			"build/b.go":     testSource,
+			"sub/c.gen.go":   testSource,
+			"local.go":       testSource,
+			"excluded.go":    testSource,
+		})
+		// .git/info/exclude is never committed.
+		writeFiles(t, filepath.Join(tr.dir, ".git", "info"), map[string]string{"exclude": "# local\nexcluded.go\n"})
+		if out, ok := tr.run(append([]string{"-fix"}, tt.args...)...); !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		header := renderTestHeader(t, "apache2.0", 2015, "ACME")
+		for _, relPath := range []string{"a.go", "local.go"} {
+			if got := tr.read(relPath); got != header+testSource {
+				t.Errorf("%q: %s was not stamped", tt.args, relPath)
+			}
+		}
+		for _, relPath := range []string{"sub/local.go", "build/b.go", "sub/c.gen.go", "excluded.go"} {
+			if got := tr.read(relPath) != testSource; got != tt.wantStamped {
+				t.Errorf("%q: %s stamped %v, want %v", tt.args, relPath, got, tt.wantStamped)
+			}
+		}
+	}
+}
+
+func TestWalkSkipsVCSDirs(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource})
+	vcsFiles := map[string]string{
+		".git/hooks/x.go": testSource,
+		".hg/x.go":        testSource,
+		"sub/.svn/x.go":   testSource,
+		"_darcs/x.go":     testSource,
+		"CVS/x.go":        testSource,
+	}
+	writeFiles(t, tr.dir, vcsFiles)
+	out, ok := tr.run("-fix", "-walk-skip-hidden=false")
+	if !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	if !strings.Contains(out, "Total: 1::") || !strings.Contains(out, "Errors: 0") {
+		t.Errorf("want only a.go processed, got:\n%s", out)
+	}
+	for relPath := range vcsFiles {
+		if tr.read(relPath) != testSource {
+			t.Errorf("%s was stamped", relPath)
+		}
+	}
+}
+
+func TestReadHolderList(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "three holders", list: "ACME\nGlobex Corp\nInitech\n", want: []string{"ACME", "Globex Corp", "Initech"}},
+		{name: "comments and blank lines", list: "# Holders\n\n  ACME  \r\nGlobex Corp\n\n# Initech\nInitech", want: []string{"ACME", "Globex Corp", "Initech"}},
+		{name: "empty", list: "# nobody\n\n", wantErr: true},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("holders%d", i))
+		if err := ioutil.WriteFile(path, []byte(tt.list), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readHolderList(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want one: %v", tt.name, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadHolderMap(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		list    string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "aliases",
+			list: "# Aliases\n\n ACME Inc. = ACME Corp \r\nAcme=ACME Corp\n",
+			want: map[string]string{"ACME Inc.": "ACME Corp", "Acme": "ACME Corp"},
+		},
+		{name: "empty", list: "", want: map[string]string{}},
+		{name: "no equals sign", list: "ACME Inc.=ACME Corp\nAcme\n", wantErr: `holders2:2: "Acme" is not of the form alias=canonical`},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("holders%d", i))
+		if err := ioutil.WriteFile(path, []byte(tt.list), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readHolderMap(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHolderCanonicalizationMap(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	mapFile := filepath.Join(dir, "holders")
+	if err := ioutil.WriteFile(mapFile, []byte("ACME Inc = ACME Corp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	alias := renderTestHeader(t, "apache2.0", 2014, "acme inc") + testSource
+	tr.commit("Alice", inYear(2015), map[string]string{"alias.go": alias, "new.go": testSource})
+	if out, ok := tr.run("-fix", "-restamp-holder", "-copyright-holder", "ACME Inc", "-holder-canonicalization-map", mapFile); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	if got := tr.read("alias.go"); got != alias {
+		t.Errorf("alias.go: got\n%s\nwant it untouched", got)
+	}
+	if got, want := tr.read("new.go"), renderTestHeader(t, "apache2.0", 2015, "ACME Corp")+testSource; got != want {
+		t.Errorf("new.go: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHolderListFile(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource})
+	holders := filepath.Join(tr.gopath, "holders")
+	if err := ioutil.WriteFile(holders, []byte("ACME\nGlobex Corp\nInitech\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, ok := tr.run("-fix", "-tmpl", "BSD", "-holder-list-file", holders); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	want := `// Copyright 2015 ACME. All rights reserved.
+// Copyright 2015 Globex Corp. All rights reserved.
+// Copyright 2015 Initech. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+` + testSource
+	if got := tr.read("a.go"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStampProto(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	proto := "syntax = \"proto3\";\n\npackage a;\n\n" + strings.Repeat("message M {\n  string name = 1;\n}\n\n", 30)
+	tr.commit("Alice", inYear(2015), map[string]string{
+		"api/a.proto": proto,
+		"a.pb":        proto,
+	})
+	if out, ok := tr.run("-fix", "-lang", "proto"); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	if got, want := tr.read("api/a.proto"), renderTestHeader(t, "apache2.0", 2015, "ACME")+proto; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := tr.read("a.pb"); got != proto {
+		t.Errorf("a.pb was stamped:\n%s", got)
+	}
+}
+
+func TestVerifyGitClean(t *testing.T) {
+	tests := []struct {
+		name   string
+		dirty  map[string]string
+		args   []string
+		wantOK bool
+	}{
+		{name: "clean", wantOK: true},
+		{name: "modified", dirty: map[string]string{"a.go": testSource + "\nvar y = 2\n"}},
+		{name: "modified with -force", dirty: map[string]string{"a.go": testSource + "\nvar y = 2\n"}, args: []string{"-force"}, wantOK: true},
+		{name: "modified without the check", dirty: map[string]string{"a.go": testSource + "\nvar y = 2\n"}, args: []string{"-verify-git-clean=false"}, wantOK: true},
+		{name: "untracked", dirty: map[string]string{"notes.txt": "todo\n"}, wantOK: true},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource, "b.go": testSource})
+		writeFiles(t, tr.dir, tt.dirty)
+		out, ok := tr.run(append([]string{"-fix"}, tt.args...)...)
+		if ok != tt.wantOK {
+			t.Fatalf("%s: got success %v, want %v, output:\n%s", tt.name, ok, tt.wantOK, out)
+		}
+		if !ok {
+			if !strings.Contains(out, `uncommitted changes e.g. "a.go"`) || !strings.Contains(out, "-force") {
+				t.Errorf("%s: want the dirty file and -force named, got:\n%s", tt.name, out)
+			}
+			if got := tr.read("b.go"); got != testSource {
+				t.Errorf("%s: b.go was written to despite the refusal", tt.name)
+			}
+		}
+	}
+}
+
+func TestParsePairs(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{spec: "", want: map[string]string{}},
+		{spec: ".go=apache2.0, proto=BSD,", want: map[string]string{".go": "apache2.0", "proto": "BSD"}},
+		{spec: ".go", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePairs(tt.spec, "template map", "ext=license")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error %v, want one: %v", tt.spec, err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateMap(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	proto := "syntax = \"proto3\";\n\npackage a;\n\n" + strings.Repeat("message M {\n  string name = 1;\n}\n\n", 30)
+	tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource, "a.proto": proto})
+	if out, ok := tr.run("-fix", "-lang", "go,proto", "-template-map", ".proto=BSD"); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	if got, want := tr.read("a.go"), renderTestHeader(t, "apache2.0", 2015, "ACME")+testSource; got != want {
+		t.Errorf("a.go: got\n%s\nwant\n%s", got, want)
+	}
+	if got, want := tr.read("a.proto"), renderTestHeader(t, "BSD", 2015, "ACME")+proto; got != want {
+		t.Errorf("a.proto: got\n%s\nwant\n%s", got, want)
+	}
+
+	for spec, want := range map[string]string{
+		".go":      "not of the form ext=license",
+		".png=BSD": `files with extension ".png" are not stamped`,
+		".go=GPL":  `unknown license "GPL"`,
+	} {
+		if out, ok := tr.run("-template-map", spec); ok || !strings.Contains(out, want) {
+			t.Errorf("%q: got success %v, output:\n%s\nwant it to mention %s", spec, ok, out, want)
+		}
+	}
+}
+
+func TestRestampHolder(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		oldHolder  string
+		wantHolder string
+	}{
+		{name: "other holder", args: []string{"-restamp-holder"}, oldHolder: "Old Corp", wantHolder: "ACME"},
+		{name: "same holder", args: []string{"-restamp-holder"}, oldHolder: "ACME", wantHolder: "ACME"},
+		{name: "not asked to", oldHolder: "Old Corp", wantHolder: "Old Corp"},
+		{name: "holder differing in case", args: []string{"-restamp-holder"}, oldHolder: "acme", wantHolder: "ACME"},
+		{name: "ignoring case", args: []string{"-restamp-holder", "-ignore-case-holder-match"}, oldHolder: "acme", wantHolder: "acme"},
+		{name: "holder differing once sanitized", args: []string{"-restamp-holder", "-holder-sanitize", "-copyright-holder", "ACME Inc"}, oldHolder: "ACME inc", wantHolder: "ACME inc"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": renderTestHeader(t, "apache2.0", 2014, tt.oldHolder) + testSource})
+		if out, ok := tr.run(append([]string{"-fix"}, tt.args...)...); !ok {
+			t.Fatalf("%s: main failed:\n%s", tt.name, out)
+		}
+		if got, want := tr.read("a.go"), renderTestHeader(t, "apache2.0", 2014, tt.wantHolder)+testSource; got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("a%02d.go", i)] = renderTestHeader(t, "apache2.0", 2014, "ACME") + testSource
+	}
+	tests := []struct {
+		args   []string
+		wantOK bool
+	}{
+		{args: []string{"-max-errors", "2"}},
+		{args: []string{"-max-errors", "0"}, wantOK: true},
+		{wantOK: true},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), files)
+		out, ok := tr.run(append([]string{"-flag-placeholder-holders", "-concurrency", "1"}, tt.args...)...)
+		if ok != tt.wantOK {
+			t.Errorf("%q: got success %v, want %v, output:\n%s", tt.args, ok, tt.wantOK, out)
+		}
+		if ok {
+			continue
+		}
+		// Jobs already queued when the limit is hit still run.
+		var nBad int
+		if i := strings.Index(out, "aborted after "); i < 0 {
+			t.Errorf("%q: want the run aborted, got:\n%s", tt.args, out)
+		} else if fmt.Sscanf(out[i:], "aborted after %d errors", &nBad); nBad < 2 || nBad >= len(files) {
+			t.Errorf("%q: aborted after %d errors, want at least 2 and fewer than %d", tt.args, nBad, len(files))
+		}
+	}
+}
+
+func TestListLicenses(t *testing.T) {
+	if got, want := conform.LicenseNames(), []string{"apache2.0", "BSD", "GPL3", "MIT", "MPL2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	out, ok := runMain(t, nil, "-list-licenses")
+	if want := "apache2.0\nBSD\nGPL3\nMIT\nMPL2\n"; !ok || out != want {
+		t.Errorf("got success %v and output %q, want %q", ok, out, want)
+	}
+
+	// A misspelled license is an error rather than Apache 2.0.
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	out, ok = tr.run("-fix", "-tmpl", "apache2")
+	if ok || !strings.Contains(out, `unknown license "apache2" for -tmpl`) || !strings.Contains(out, "-list-licenses") {
+		t.Errorf("got success %v, output:\n%s\nwant an unknown license pointing at -list-licenses", ok, out)
+	}
+	if got := tr.read("a.go"); got != testSource {
+		t.Errorf("got a.go\n%s\nwant it untouched", got)
+	}
+}
+
+func TestMITTemplate(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource, "logo.png": "\x89PNG\r\n\x1a\n"})
+	want := renderTestHeader(t, "MIT", 2018, "ACME") + testSource
+	if !strings.HasPrefix(want, "// Copyright (c) 2018 ACME\n//\n// Permission is hereby granted, free of charge,") {
+		t.Fatalf("got header\n%s\nwant the MIT notice", want)
+	}
+	for i := 0; i < 2; i++ {
+		if out, ok := tr.run("-fix", "-tmpl", "MIT", "-write-sidecar", "png"); !ok {
+			t.Fatalf("run %d: main failed:\n%s", i+1, out)
+		}
+		if got := tr.read("a.go"); got != want {
+			t.Errorf("run %d: got\n%s\nwant\n%s", i+1, got, want)
+		}
+		if got, wantID := tr.read("logo.png.license"), "SPDX-License-Identifier: MIT\n"; !strings.HasSuffix(got, wantID) {
+			t.Errorf("run %d: got sidecar\n%s\nwant it to end with %q", i+1, got, wantID)
+		}
+		tr.commit("Alice", inYear(2019), map[string]string{"a.go": want, "logo.png.license": tr.read("logo.png.license")})
+	}
+}
+
+func TestGPL3AndMPL2Templates(t *testing.T) {
+	tests := []struct {
+		tmpl       string
+		wantPrefix string
+		wantID     string
+	}{
+		{tmpl: "gpl3", wantPrefix: "// Copyright (C) 2018 ACME\n//\n// This program is free software:", wantID: "GPL-3.0-or-later"},
+		{tmpl: "mpl2", wantPrefix: "// Copyright 2018 ACME\n//\n// This Source Code Form is subject to the terms of the Mozilla Public\n", wantID: "MPL-2.0"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource, "logo.png": "\x89PNG\r\n\x1a\n"})
+		want := renderTestHeader(t, tt.tmpl, 2018, "ACME") + testSource
+		if !strings.HasPrefix(want, tt.wantPrefix) {
+			t.Fatalf("%s: got header\n%s\nwant it to start with\n%s", tt.tmpl, want, tt.wantPrefix)
+		}
+		for i, wantCounts := range []string{"AddedLicenses: 1 AlreadyHaveLicenses: 0", "AddedLicenses: 0 AlreadyHaveLicenses: 1"} {
+			out, ok := tr.run("-fix", "-tmpl", tt.tmpl, "-write-sidecar", "png")
+			if !ok || !strings.Contains(out, wantCounts) {
+				t.Fatalf("%s: run %d: got success %v, output:\n%s\nwant %q", tt.tmpl, i+1, ok, out, wantCounts)
+			}
+			if got := tr.read("a.go"); got != want {
+				t.Errorf("%s: run %d: got\n%s\nwant\n%s", tt.tmpl, i+1, got, want)
+			}
+			if got := tr.read("logo.png.license"); !strings.HasSuffix(got, "SPDX-License-Identifier: "+tt.wantID+"\n") {
+				t.Errorf("%s: run %d: got sidecar\n%s\nwant it to name %s", tt.tmpl, i+1, got, tt.wantID)
+			}
+			tr.commit("Alice", inYear(2019), map[string]string{"a.go": want, "logo.png.license": tr.read("logo.png.license")})
+		}
+	}
+}
+
+func TestLang(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	if out, ok := tr.run("-lang", "go,cobol"); ok || !strings.Contains(out, `unknown language "cobol"`) {
+		t.Errorf("got success %v, output:\n%s", ok, out)
+	}
+	// Without -lang only Go files are stamped.
+	others := map[string]string{"c.sh": "echo c\n", "d.xml": "<d/>\n"}
+	tr.commit("Alice", inYear(2018), others)
+	if out, ok := tr.run("-fix"); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	if got := tr.read("a.go"); !strings.HasPrefix(got, "// Copyright 2018 ACME") {
+		t.Errorf("got a.go\n%s\nwant it stamped", got)
+	}
+	for relPath, want := range others {
+		if got := tr.read(relPath); got != want {
+			t.Errorf("got %s\n%s\nwant it left alone", relPath, got)
+		}
+	}
+	// Only the languages named are stamped, not those listed after them.
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": tr.read("a.go"), "b.py": "print(1)\n"})
+	if out, ok := tr.run("-fix", "-lang", "Go, python"); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	if got := tr.read("b.py"); !strings.HasPrefix(got, "# Copyright 2018 ACME") {
+		t.Errorf("got b.py\n%s\nwant it stamped", got)
+	}
+	if got := tr.read("c.sh"); got != "echo c\n" {
+		t.Errorf("got c.sh\n%s\nwant it left alone", got)
+	}
+	out, ok := runMain(t, nil, "-list-languages")
+	if !ok || !strings.HasPrefix(out, "go\t.go\nproto\t.proto\n") || !strings.Contains(out, "\ncpp\t.cc .cpp .cxx .hh .hpp\n") {
+		t.Errorf("got success %v, output:\n%s", ok, out)
+	}
+}
+
+func TestValidateTemplatesFlag(t *testing.T) {
+	exts := 0
+	var names []string
+	for _, lang := range conform.Languages() {
+		exts += len(lang.Extensions)
+		names = append(names, lang.Name)
+	}
+	out, ok := runMain(t, nil, "-validate-templates", "-lang", strings.Join(names, ","), "-template-map", ".proto=BSD")
+	if want := fmt.Sprintf("templates render valid headers for %d file extensions", exts); !ok || !strings.Contains(out, want) {
+		t.Errorf("got success %v, output:\n%s", ok, out)
+	}
+}
+
+func TestDryRunFailIfWouldChange(t *testing.T) {
+	stamped := renderTestHeader(t, "apache2.0", 2014, "ACME") + testSource
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantOK    bool
+		wantPaths []string
+	}{
+		{
+			name:      "would change",
+			files:     map[string]string{"a.go": testSource, "sub/b.go": testSource, "c.go": stamped},
+			wantPaths: []string{"a.go", "sub/b.go"},
+		},
+		{
+			name:   "nothing to change",
+			files:  map[string]string{"c.go": stamped},
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), tt.files)
+		out, ok := tr.run("-dry-run-fail-if-would-change")
+		if ok != tt.wantOK {
+			t.Errorf("%s: got success %v, want %v\n%s", tt.name, ok, tt.wantOK, out)
+		}
+		for _, rel := range tt.wantPaths {
+			if want := "\n" + rel + "\n"; !strings.Contains(out, want) {
+				t.Errorf("%s: got output %q, want it to list %s", tt.name, out, rel)
+			}
+		}
+		if strings.Contains(out, tr.dir) {
+			t.Errorf("%s: got output %q, want paths relative to the repo", tt.name, out)
+		}
+		if want := fmt.Sprintf("%d files would change", len(tt.wantPaths)); strings.Contains(out, want) != !tt.wantOK {
+			t.Errorf("%s: got output %q", tt.name, out)
+		}
+		for rel, body := range tt.files {
+			if got := tr.read(rel); got != body {
+				t.Errorf("%s: got %s changed to\n%s", tt.name, rel, got)
+			}
+		}
+	}
+}
+
+func TestForceRewriteAll(t *testing.T) {
+	files := map[string]string{
+		"canonical.go": renderTestHeader(t, "apache2.0", 2015, "ACME") + testSource,
+		"rewrapped.go": "// Copyright 2016 ACME\n//\n// Licensed under the Apache License, Version 2.0\n// (the \"License\"); you may not use this file except in compliance with\n// the License. You may obtain a copy of the License at\n//\n// http://www.apache.org/licenses/LICENSE-2.0\n//\n// Unless required by applicable law or agreed to in writing, software\n// distributed under the License is distributed on an \"AS IS\" BASIS, WITHOUT\n// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the\n// License for the specific language governing permissions and limitations\n// under the License.\n\n" + testSource,
+		"holder.go":    "// Copyright 2017 Someone Else. All Rights Reserved.\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n\n" + testSource,
+		// The blank lines above the notice go with it.
+		"spaced.go": "\n\n" + renderTestHeader(t, "apache2.0", 2018, "ACME") + testSource,
+	}
+	years := map[string]int{"canonical.go": 2015, "rewrapped.go": 2016, "holder.go": 2017, "spaced.go": 2018}
+	for _, rewriteAll := range []bool{true, false} {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2020), files)
+		args := []string{"-fix", "-normalize-whitespace"}
+		if rewriteAll {
+			args = append(args, "-force-rewrite-all")
+		}
+		if out, ok := tr.run(args...); !ok {
+			t.Fatalf("main failed:\n%s", out)
+		}
+		for rel, year := range years {
+			want := files[rel]
+			if rewriteAll {
+				want = renderTestHeader(t, "apache2.0", year, "ACME") + testSource
+			}
+			if got := tr.read(rel); got != want {
+				t.Errorf("%s: -force-rewrite-all=%v: got\n%s\nwant\n%s", rel, rewriteAll, got, want)
+			}
+		}
+	}
+}
+
+func TestUpdateLicenseBody(t *testing.T) {
+	body := strings.SplitN(renderTestHeader(t, "apache2.0", 2020, "ACME"), "\n", 2)[1]
+	truncated := "//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n\n"
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{
+			name:     "canonical",
+			contents: renderTestHeader(t, "apache2.0", 2015, "ACME") + testSource,
+			want:     renderTestHeader(t, "apache2.0", 2015, "ACME") + testSource,
+		},
+		{
+			name:     "truncated",
+			contents: "// Copyright 2016-2018 Someone Else, Inc.  All Rights Reserved.\n" + truncated + testSource,
+			want:     "// Copyright 2016-2018 Someone Else, Inc.  All Rights Reserved.\n" + body + testSource,
+		},
+		{
+			name:     "several copyright lines",
+			contents: "// Copyright 2016 Alice. All Rights Reserved.\n// Copyright 2017 Bob. All Rights Reserved.\n" + truncated + testSource,
+			want:     "// Copyright 2016 Alice. All Rights Reserved.\n// Copyright 2017 Bob. All Rights Reserved.\n" + body + testSource,
+		},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2020), map[string]string{"a.go": tt.contents})
+		for i := 0; i < 2; i++ {
+			if out, ok := tr.run("-fix", "-update-license-body"); !ok {
+				t.Fatalf("%s: run %d: main failed:\n%s", tt.name, i+1, out)
+			}
+			got := tr.read("a.go")
+			if got != tt.want {
+				t.Errorf("%s: run %d: got\n%s\nwant\n%s", tt.name, i+1, got, tt.want)
+			}
+			tr.commit("Alice", inYear(2021), map[string]string{"a.go": got})
+		}
+	}
+}
+
+func TestDedupeBlankLinesAfterHeader(t *testing.T) {
+	header := renderTestHeader(t, "apache2.0", 2018, "ACME")
+	tests := []struct {
+		name     string
+		contents string
+		args     []string
+		want     string
+	}{
+		{name: "two leading blank lines", contents: "\n\n" + testSource, args: []string{"-dedupe-blank-lines-after-header"}, want: header + testSource},
+		{name: "blank lines with spaces", contents: " \n\t\n\n" + testSource, args: []string{"-dedupe-blank-lines-after-header"}, want: header + testSource},
+		{name: "no leading blank lines", contents: testSource, args: []string{"-dedupe-blank-lines-after-header"}, want: header + testSource},
+		{name: "blank lines kept", contents: "\n\n" + testSource, want: header + "\n\n" + testSource},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2018), map[string]string{"a.go": tt.contents})
+		if out, ok := tr.run(append([]string{"-fix"}, tt.args...)...); !ok {
+			t.Fatalf("%s: main failed:\n%s", tt.name, out)
+		}
+		if got := tr.read("a.go"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProgressEvery(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		files[fmt.Sprintf("f%d.go", i)] = testSource
+	}
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), files)
+
+	tests := []struct {
+		every string
+		want  []int
+	}{
+		{every: "1", want: []int{1, 2, 3, 4, 5}},
+		{every: "2", want: []int{2, 4, 5}},
+		{every: "5", want: []int{5}},
+		{every: "0", want: []int{5}},
+	}
+	for _, tt := range tests {
+		out, ok := tr.run("-progress-every", tt.every)
+		if !ok {
+			t.Fatalf("-progress-every %s failed:\n%s", tt.every, out)
+		}
+		var got []int
+		for _, line := range strings.Split(out, "\r") {
+			var total int
+			if i := strings.Index(line, "Total: "); i >= 0 {
+				if _, err := fmt.Sscanf(line[i:], "Total: %d::", &total); err != nil {
+					t.Fatalf("-progress-every %s: %q: %v", tt.every, line, err)
+				}
+				got = append(got, total)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("-progress-every %s: got progress at %v, want %v", tt.every, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateValidate(t *testing.T) {
+	tests := []struct {
+		path   string
+		wantOK bool
+		want   string
+	}{
+		{path: filepath.Join("testdata", "header.tmpl"), wantOK: true, want: "templates render valid headers"},
+		{path: filepath.Join("testdata", "broken.tmpl"), want: "can't evaluate field Owner"},
+		{path: filepath.Join("testdata", "missing.tmpl"), want: "template: open "},
+	}
+	for _, tt := range tests {
+		// No repo is needed, the default one need not exist.
+		out, ok := runMain(t, nil, "-template-validate", tt.path)
+		if ok != tt.wantOK || !strings.Contains(out, tt.want) {
+			t.Errorf("%s: got success %v, output:\n%s\nwant success %v and %q", tt.path, ok, out, tt.wantOK, tt.want)
+		}
+	}
+}
+
+func TestTmplFile(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path   string
+		wantOK bool
+		want   string
+	}{
+		{path: "broken.tmpl", want: "can't evaluate field Owner"},
+		{path: "missing.tmpl", want: "template: open "},
+		{path: "header.tmpl", wantOK: true},
+	} {
+		out, ok := tr.run("-fix", "-tmpl", "BSD", "-tmpl-file", filepath.Join(wd, "testdata", tt.path))
+		if ok != tt.wantOK || !strings.Contains(out, tt.want) {
+			t.Errorf("%s: got success %v, output:\n%s\nwant success %v and %q", tt.path, ok, out, tt.wantOK, tt.want)
+		}
+	}
+	want := "// Copyright 2018 ACME. Proprietary and confidential.\n//\n// All Rights Reserved. Unauthorized copying of this file, via any\n// medium, is strictly prohibited.\n\n" + testSource
+	if got := tr.read("a.go"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMaxRuntimePerFile(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Jane Doe", inYear(2018), map[string]string{"a.go": testSource})
+
+	tests := []struct {
+		name        string
+		args        []string
+		wantStamped bool
+		wantWarning bool
+	}{
+		// Far too short for any blame to finish in.
+		{name: "slow blame", args: []string{"-max-runtime-per-file", "1ns", "-report"}, wantWarning: true},
+		{name: "no timeout", args: []string{"-report"}, wantStamped: true},
+	}
+	for _, tt := range tests {
+		out, ok := tr.run(append([]string{"-fix"}, tt.args...)...)
+		if !ok {
+			t.Fatalf("%s: main failed:\n%s", tt.name, out)
+		}
+		if got := strings.Contains(out, `skipping "a.go": blame took longer than 1ns`); got != tt.wantWarning {
+			t.Errorf("%s: got output\n%s\nwant the file skipped: %v", tt.name, out, tt.wantWarning)
+		}
+		wantStatus := conform.StatusSkipped
+		if tt.wantStamped {
+			wantStatus = conform.StatusAdded
+		}
+		if got := reportStatuses(out)["a.go"]; got != wantStatus {
+			t.Errorf("%s: got status %q, want %q", tt.name, got, wantStatus)
+		}
+		if got := strings.Contains(tr.read("a.go"), "Copyright 2018 ACME"); got != tt.wantStamped {
+			t.Errorf("%s: got stamped %v, want %v", tt.name, got, tt.wantStamped)
+		}
+	}
+}
+
+func TestFixOnlyIfAllFilesValid(t *testing.T) {
+	schema := strings.Repeat("CREATE TABLE a (id INT PRIMARY KEY, name TEXT NOT NULL);\n", 12)
+	files := map[string]string{"a.go": testSource, "sub/b.go": testSource, "c.sql": schema}
+	tests := []struct {
+		name        string
+		broken      string
+		wantWritten bool
+	}{
+		{name: "all valid", wantWritten: true},
+		{name: "one invalid", broken: testSource + "\nfunc {\n"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2018), files)
+		if tt.broken != "" {
+			tr.commit("Alice", inYear(2019), map[string]string{"broken.go": tt.broken})
+		}
+		out, ok := tr.run("-fix", "-fix-only-if-all-files-valid", "-lang", "go,sql")
+		if ok != tt.wantWritten {
+			t.Errorf("%s: got success %v, output:\n%s", tt.name, ok, out)
+		}
+		if got := strings.Contains(out, "1 files would no longer parse, no files were changed"); got == tt.wantWritten {
+			t.Errorf("%s: got output\n%s", tt.name, out)
+		}
+		if !tt.wantWritten && !strings.Contains(out, "broken.go:") {
+			t.Errorf("%s: got output\n%s\nwant the problem with broken.go", tt.name, out)
+		}
+		for rel, contents := range files {
+			if written := tr.read(rel) != contents; written != tt.wantWritten {
+				t.Errorf("%s: %s written: %v, want %v", tt.name, rel, written, tt.wantWritten)
+			}
+		}
+	}
+}
+
+func TestDiffFlag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	files := map[string]string{"b.go": testSource, "a.go": testSource}
+	tr.commit("Alice", inYear(2018), files)
+
+	got, stderr, ok := tr.runStdout("-diff")
+	if !ok {
+		t.Fatalf("main failed:\n%s", stderr)
+	}
+	if !strings.HasPrefix(got, "diff --git a/a.go b/a.go\n") || !strings.Contains(got, "diff --git a/b.go b/b.go\n") {
+		t.Errorf("got stdout\n%s\nwant the diffs of a.go then b.go", got)
+	}
+	if strings.Contains(got, "TimeSpent") || !strings.Contains(stderr, "TimeSpent") {
+		t.Errorf("got stdout\n%s\nand stderr\n%s\nwant the timing on stderr only", got, stderr)
+	}
+	for rel, contents := range files {
+		if tr.read(rel) != contents {
+			t.Errorf("%s: changed on disk, want it untouched", rel)
+		}
+	}
+}
+
+func TestDryRunPerFileStatus(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	files := map[string]string{
+		"a.go":     testSource,
+		"sub/b.go": renderTestHeader(t, "apache2.0", 2015, "ACME") + testSource,
+	}
+	tr.commit("Alice", inYear(2018), files)
+
+	stdout, stderr, ok := tr.runStdout("-dry-run-per-file-status")
+	if !ok {
+		t.Fatalf("main failed:\n%s", stderr)
+	}
+	got := make(map[string]statusLine)
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		var sl statusLine
+		if err := json.Unmarshal([]byte(line), &sl); err != nil {
+			t.Fatalf("%q: %v, stdout:\n%s", line, err, stdout)
+		}
+		got[sl.Path] = sl
+	}
+	want := map[string]statusLine{
+		"a.go":     {Path: "a.go", Status: conform.StatusAdded, Year: 2018},
+		"sub/b.go": {Path: "sub/b.go", Status: conform.StatusConforming, Year: 2015},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	for rel, contents := range files {
+		if tr.read(rel) != contents {
+			t.Errorf("%s: changed on disk, want it untouched", rel)
+		}
+	}
+
+	if _, stderr, ok := tr.runStdout("-dry-run-per-file-status", "-diff"); ok || !strings.Contains(stderr, "cannot be combined") {
+		t.Errorf("got success %v, stderr:\n%s\nwant a failure saying they cannot be combined", ok, stderr)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	header := renderTestHeader(t, "apache2.0", 2015, "ACME")
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantOK      bool
+		wantListed  []string
+		wantSummary string
+	}{
+		{name: "all licensed", files: map[string]string{"a.go": header + testSource}, wantOK: true},
+		{
+			name:        "some missing",
+			files:       map[string]string{"a.go": header + testSource, "sub/b.go": testSource, "c.go": testSource},
+			wantListed:  []string{"c.go", "sub/b.go"},
+			wantSummary: "2 files lack a license, 0 could not be checked",
+		},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2018), tt.files)
+		out, ok := tr.run("-check")
+		if ok != tt.wantOK || !strings.Contains(out, tt.wantSummary) {
+			t.Errorf("%s: got success %v, output:\n%s\nwant success %v and %q", tt.name, ok, out, tt.wantOK, tt.wantSummary)
+		}
+		if tt.wantListed != nil && !strings.Contains(out, "\n"+strings.Join(tt.wantListed, "\n")+"\n") {
+			t.Errorf("%s: got output\n%s\nwant it to list %q", tt.name, out, tt.wantListed)
+		}
+		for rel, contents := range tt.files {
+			if tr.read(rel) != contents {
+				t.Errorf("%s: %s changed on disk, want it untouched", tt.name, rel)
+			}
+		}
+	}
+
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	if out, ok := tr.run("-check", "-fix"); ok || !strings.Contains(out, "-check and -fix cannot be combined") {
+		t.Errorf("got success %v, output:\n%s\nwant a failure saying they cannot be combined", ok, out)
+	}
+}
+
+func TestSPDXFlag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource, "b.proto": "syntax = \"proto3\";\n"})
+	if out, ok := tr.run("-fix", "-spdx", "-tmpl", "MIT", "-lang", "go,proto", "-template-map", ".proto=BSD"); !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	for rel, want := range map[string]string{
+		"a.go":    "// SPDX-FileCopyrightText: 2018 ACME\n// SPDX-License-Identifier: MIT\n\n",
+		"b.proto": "// SPDX-FileCopyrightText: 2018 ACME\n// SPDX-License-Identifier: BSD-3-Clause\n\n",
+	} {
+		if got := tr.read(rel); !strings.HasPrefix(got, want) {
+			t.Errorf("%s: got\n%s\nwant it to start with\n%s", rel, got, want)
+		}
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	tmplFile := filepath.Join(dir, "header.tmpl")
+	if err := ioutil.WriteFile(tmplFile, []byte("// Copyright {{.Year}} {{.Holder}}. All Rights Reserved.\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, ok := tr.run("-spdx", "-tmpl-file", tmplFile); ok || !strings.Contains(out, "-spdx needs a built-in license") {
+		t.Errorf("got success %v, output:\n%s\nwant a failure about -tmpl-file", ok, out)
+	}
+}
+
+func TestHolderFromGit(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{want: "The Jane Doe Authors"},
+		{args: []string{"-copyright-holder", "Globex"}, want: "Globex"},
+	}
+	for _, tt := range tests {
+		tr, cleanup := newTestRepo(t)
+		defer cleanup()
+		tr.commit("Alice", inYear(2015), map[string]string{"a.go": testSource, "AUTHORS": "The Jane Doe Authors\n"})
+		if out, ok := tr.run(append([]string{"-fix", "-holder-from-git"}, tt.args...)...); !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		if got, want := tr.read("a.go"), renderTestHeader(t, "apache2.0", 2015, tt.want)+testSource; got != want {
+			t.Errorf("%q: got\n%s\nwant\n%s", tt.args, got, want)
+		}
+	}
+}
+
+func TestSeveralRepos(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	dir := filepath.Join(tr.gopath, "src", "example.com", "other")
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &testRepo{t: t, gopath: tr.gopath, dir: dir, repo: repo}
+	other.commit("Bob", inYear(2019), map[string]string{"b.go": testSource})
+
+	if out, ok := tr.run("-fix", "-patch", filepath.Join(tr.gopath, "p.diff"), "example.com/other"); ok || !strings.Contains(out, "cannot be combined with several repos") {
+		t.Fatalf("-patch: got success %v, output:\n%s", ok, out)
+	}
+	// With several repos paths name the repo they came from.
+	stdout, stderr, ok := tr.runStdout("-dry-run-per-file-status", "example.com/other")
+	if !ok {
+		t.Fatalf("dry run failed:\n%s", stderr)
+	}
+	for _, path := range []string{testImportPath + "/a.go", "example.com/other/b.go"} {
+		if !strings.Contains(stdout, `"`+path+`"`) {
+			t.Errorf("got stdout:\n%s\nwant it to name %s", stdout, path)
+		}
+	}
+	out, ok := tr.run("-fix", "-copyright-holder", "ACME", "example.com/other")
+	if !ok || !strings.Contains(out, "AddedLicenses: 2 AlreadyHaveLicenses: 0") {
+		t.Fatalf("got success %v, output:\n%s", ok, out)
+	}
+	if got, want := tr.read("a.go"), renderTestHeader(t, "apache2.0", 2018, "ACME")+testSource; got != want {
+		t.Errorf("a.go: got\n%s\nwant\n%s", got, want)
+	}
+	if got, want := other.read("b.go"), renderTestHeader(t, "apache2.0", 2019, "ACME")+testSource; got != want {
+		t.Errorf("b.go: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPathFlag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	// The repo is found through -path alone, not GOPATH.
+	env := []string{"GOPATH=" + filepath.Join(tr.gopath, "nowhere")}
+	out, ok := runMain(t, env, "-path", tr.dir, "-fix", "-copyright-holder", "ACME")
+	if !ok || !strings.Contains(out, "AddedLicenses: 1 AlreadyHaveLicenses: 0") {
+		t.Fatalf("got success %v, output:\n%s", ok, out)
+	}
+	if got, want := tr.read("a.go"), renderTestHeader(t, "apache2.0", 2018, "ACME")+testSource; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if out, ok := runMain(t, env, "-path", tr.dir, "example.com/other"); ok || !strings.Contains(out, "-path cannot be combined") {
+		t.Errorf("got success %v, output:\n%s", ok, out)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/orijtech/apache2conform/conform"
+)
+
+// The orders that -report-sort-by accepts.
+const (
+	reportSortPath   = "path"
+	reportSortStatus = "status"
+	reportSortYear   = "year"
+)
+
+func validReportSort(sortBy string) bool {
+	switch sortBy {
+	case reportSortPath, reportSortStatus, reportSortYear:
+		return true
+	default:
+		return false
+	}
+}
+
+type reportEntry struct {
+	// path is relative to the repo's root.
+	path   string
+	status string
+	year   int
+	err    error
+}
+
+func newReportEntry(dirPath string, fr *conform.FileResult) *reportEntry {
+	entry := &reportEntry{path: fr.Path, status: fr.Status, year: fr.Year, err: fr.Err}
+	if relPath, rerr := filepath.Rel(dirPath, fr.Path); rerr == nil {
+		entry.path = filepath.ToSlash(relPath)
+	}
+	return entry
+}
+
+// statusLine is the JSON form of a file's
+// outcome that -dry-run-per-file-status prints.
+type statusLine struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Year   int    `json:"year,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newStatusLine(dirPath string, fr *conform.FileResult) *statusLine {
+	entry := newReportEntry(dirPath, fr)
+	line := &statusLine{Path: entry.path, Status: entry.status, Year: entry.year}
+	if entry.err != nil {
+		line.Error = entry.err.Error()
+	}
+	return line
+}
+
+// conforming reports whether the file needed no changes, either since
+// it already carried a license or since it is not to be stamped.
+func (entry *reportEntry) conforming() bool {
+	return entry.status == conform.StatusConforming || entry.status == conform.StatusSkipped
+}
+
+// sortReport orders entries by sortBy, breaking ties by path. Entries
+// with no known year sort after all others when ordering by year.
+func sortReport(entries []*reportEntry, sortBy string) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		ei, ej := entries[i], entries[j]
+		switch sortBy {
+		case reportSortStatus:
+			if ei.status != ej.status {
+				return ei.status < ej.status
+			}
+		case reportSortYear:
+			if ei.year != ej.year {
+				return ej.year == 0 || (ei.year != 0 && ei.year < ej.year)
+			}
+		}
+		return ei.path < ej.path
+	})
+}
+
+func writeReport(w io.Writer, entries []*reportEntry, sortBy string) error {
+	sortReport(entries, sortBy)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tYEAR\tPATH")
+	for _, entry := range entries {
+		year := "-"
+		if entry.year > 0 {
+			year = fmt.Sprint(entry.year)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s", entry.status, year, entry.path)
+		if entry.err != nil {
+			fmt.Fprintf(tw, "\t%v", entry.err)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// treeNode is a directory of the -dry-run-tree-summary, counting
+// the files below it, those of its subdirectories included.
+type treeNode struct {
+	name       string
+	total      int
+	conforming int
+	children   map[string]*treeNode
+}
+
+func (tn *treeNode) child(name string) *treeNode {
+	if tn.children == nil {
+		tn.children = make(map[string]*treeNode)
+	}
+	c, ok := tn.children[name]
+	if !ok {
+		c = &treeNode{name: name}
+		tn.children[name] = c
+	}
+	return c
+}
+
+// writeTreeSummary prints the directories of entries as a tree, each
+// with how many of the files below it conform, so that those that
+// still need work stand out.
+func writeTreeSummary(w io.Writer, entries []*reportEntry) {
+	root := &treeNode{name: "."}
+	for _, entry := range entries {
+		dirs := strings.Split(path.Dir(entry.path), "/")
+		if dirs[0] == "." {
+			dirs = nil
+		}
+		node := root
+		for i := 0; ; i++ {
+			node.total++
+			if entry.conforming() {
+				node.conforming++
+			}
+			if i == len(dirs) {
+				break
+			}
+			node = node.child(dirs[i])
+		}
+	}
+	root.write(w, "", "")
+}
+
+func (tn *treeNode) write(w io.Writer, branch, indent string) {
+	mark := "ok"
+	if tn.conforming < tn.total {
+		mark = fmt.Sprintf("%d to fix", tn.total-tn.conforming)
+	}
+	fmt.Fprintf(w, "%s%s/ %d/%d conforming, %s\n", branch, tn.name, tn.conforming, tn.total, mark)
+
+	names := make([]string, 0, len(tn.children))
+	for name := range tn.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i == len(names)-1 {
+			tn.children[name].write(w, indent+"└── ", indent+"    ")
+		} else {
+			tn.children[name].write(w, indent+"├── ", indent+"│   ")
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/orijtech/apache2conform/conform"
+)
+
+// reportStatuses parses the statuses of files out of -report output.
+func reportStatuses(out string) map[string]string {
+	statuses := make(map[string]string)
+	i := strings.Index(out, "\nSTATUS ")
+	if i < 0 {
+		return statuses
+	}
+	for _, line := range strings.Split(out[i+1:], "\n")[1:] {
+		if fields := strings.Fields(line); len(fields) >= 3 {
+			statuses[fields[2]] = fields[0]
+		}
+	}
+	return statuses
+}
+
+func TestSortReport(t *testing.T) {
+	newEntries := func() []*reportEntry {
+		return []*reportEntry{
+			{path: "c.go", status: conform.StatusAdded, year: 2018},
+			{path: "a.go", status: conform.StatusConforming, year: 2020},
+			{path: "d.go", status: conform.StatusSkipped},
+			{path: "b.go", status: conform.StatusAdded, year: 2016},
+			{path: "e.go", status: conform.StatusConforming, year: 2016},
+		}
+	}
+	tests := []struct {
+		sortBy string
+		want   []string
+	}{
+		{sortBy: reportSortPath, want: []string{"a.go", "b.go", "c.go", "d.go", "e.go"}},
+		{sortBy: reportSortStatus, want: []string{"b.go", "c.go", "a.go", "e.go", "d.go"}},
+		// Ties break by path and files of no known year go last.
+		{sortBy: reportSortYear, want: []string{"b.go", "e.go", "c.go", "a.go", "d.go"}},
+	}
+	for _, tt := range tests {
+		entries := newEntries()
+		sortReport(entries, tt.sortBy)
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.path)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.sortBy, got, tt.want)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := writeReport(buf, newEntries(), tt.sortBy); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != len(tt.want)+1 || !strings.HasPrefix(lines[0], "STATUS") {
+			t.Fatalf("%s: got report\n%s", tt.sortBy, buf)
+		}
+		for i, path := range tt.want {
+			if !strings.HasSuffix(lines[i+1], " "+path) {
+				t.Errorf("%s: got line %d %q, want it to be of %s", tt.sortBy, i+1, lines[i+1], path)
+			}
+		}
+	}
+}
+
+func TestReportFlag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2016), map[string]string{
+		"a.go":     renderTestHeader(t, "apache2.0", 2014, "ACME") + testSource,
+		"sub/b.go": testSource,
+	})
+	out, ok := tr.run("-report", "-report-sort-by", "year")
+	if !ok {
+		t.Fatalf("main failed:\n%s", out)
+	}
+	for _, want := range []string{"\nSTATUS  ", "\nconforming  2014  a.go\n", "\nmissing     2016  sub/b.go\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got output\n%s\nwant it to contain %q", out, want)
+		}
+	}
+
+	if out, ok := tr.run("-report-sort-by", "size"); ok || !strings.Contains(out, `unknown -report-sort-by "size"`) {
+		t.Errorf("got success %v, output:\n%s", ok, out)
+	}
+}
+
+func TestReportOnlyNonConforming(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2016), map[string]string{
+		"a.go":     renderTestHeader(t, "apache2.0", 2014, "ACME") + testSource,
+		"b.go":     testSource,
+		"gen.go":   "// DO NOT EDIT!\n\n" + testSource,
+		"sub/c.go": testSource,
+	})
+	tests := []struct {
+		args []string
+		want map[string]string
+	}{
+		{
+			args: []string{"-report"},
+			want: map[string]string{"a.go": conform.StatusConforming, "b.go": conform.StatusMissing, "gen.go": conform.StatusSkipped, "sub/c.go": conform.StatusMissing},
+		},
+		{
+			args: []string{"-report", "-report-only-non-conforming"},
+			want: map[string]string{"b.go": conform.StatusMissing, "sub/c.go": conform.StatusMissing},
+		},
+		{
+			args: []string{"-report", "-report-only-non-conforming", "-fix"},
+			want: map[string]string{"b.go": conform.StatusAdded, "sub/c.go": conform.StatusAdded},
+		},
+	}
+	for _, tt := range tests {
+		out, ok := tr.run(tt.args...)
+		if !ok {
+			t.Fatalf("%q: main failed:\n%s", tt.args, out)
+		}
+		if got := reportStatuses(out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got statuses %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestWriteTreeSummary(t *testing.T) {
+	entries := []*reportEntry{
+		{path: "a.go", status: conform.StatusConforming},
+		{path: "sub/b.go", status: conform.StatusAdded},
+		{path: "sub/deep/c.go", status: conform.StatusConforming},
+		{path: "sub/deep/d.go", status: conform.StatusMissing},
+		{path: "vendor/e.go", status: conform.StatusConforming},
+	}
+	buf := new(bytes.Buffer)
+	writeTreeSummary(buf, entries)
+	want := "./ 3/5 conforming, 2 to fix\n" +
+		"├── sub/ 1/3 conforming, 2 to fix\n" +
+		"│   └── deep/ 1/2 conforming, 1 to fix\n" +
+		"└── vendor/ 1/1 conforming, ok\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTreeSummaryFlag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2016), map[string]string{
+		"a.go":     renderTestHeader(t, "apache2.0", 2014, "ACME") + testSource,
+		"sub/b.go": testSource,
+	})
+	out, ok := tr.run("-fix", "-dry-run-tree-summary")
+	if !ok || !strings.Contains(out, "\n./ 1/2 conforming, 1 to fix\n└── sub/ 0/1 conforming, 1 to fix\n") {
+		t.Fatalf("got success %v, output:\n%s", ok, out)
+	}
+	if got := tr.read("sub/b.go"); got != testSource {
+		t.Errorf("wrote to sub/b.go in a dry run:\n%s", got)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/odeke-em/semalim"
+	"github.com/orijtech/apache2conform/conform"
+)
+
+// repoConformer is the job that runs conform.Conform over one of
+// several repos, unless an earlier one has already come to a stop.
+type repoConformer struct {
+	opts conform.Options
+	stop *repoStop
+}
+
+// repoResult is what Conform returned for a repo, the report
+// can come with an error as well as instead of one.
+type repoResult struct {
+	rep *conform.Report
+	err error
+}
+
+// repoStop is set once a repo fails, would not parse or stopped
+// at -max-errors so that no repo after it is changed.
+type repoStop struct {
+	mu      sync.Mutex
+	stopped bool
+}
+
+func (rs *repoStop) set() {
+	rs.mu.Lock()
+	rs.stopped = true
+	rs.mu.Unlock()
+}
+
+func (rs *repoStop) isSet() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.stopped
+}
+
+var _ semalim.Job = (*repoConformer)(nil)
+
+func (rc *repoConformer) Id() interface{} {
+	return rc.opts.RepoPath
+}
+
+// Do returns a nil *repoResult for a repo that was not looked at.
+func (rc *repoConformer) Do() (interface{}, error) {
+	if rc.stop.isSet() {
+		return (*repoResult)(nil), nil
+	}
+	rep, err := conform.Conform(rc.opts)
+	if rep == nil || rep.Aborted || len(rep.Unparsable) > 0 {
+		// Set before this job's slot frees up, so that with
+		// a single slot the next repo never gets started.
+		rc.stop.set()
+	}
+	return &repoResult{rep: rep, err: err}, nil
+}
+
+// conformRepos runs opts over each of dirPaths, up to maxParallel of
+// them at once, returning the results keyed by repo path. With several
+// repos, diffs name files by their path under root.
+func conformRepos(opts conform.Options, root string, dirPaths []string, maxParallel uint) map[string]*repoResult {
+	stop := new(repoStop)
+	jobsChan := make(chan semalim.Job)
+	go func() {
+		defer close(jobsChan)
+		for _, repoPath := range dirPaths {
+			repoOpts := opts
+			repoOpts.RepoPath = repoPath
+			if rel, err := filepath.Rel(root, repoPath); err == nil && len(dirPaths) > 1 {
+				repoOpts.DiffPrefix = filepath.ToSlash(rel) + "/"
+			}
+			jobsChan <- &repoConformer{opts: repoOpts, stop: stop}
+		}
+	}()
+
+	results := make(map[string]*repoResult)
+	for res := range semalim.Run(jobsChan, uint64(maxParallel)) {
+		if rr, _ := res.Value().(*repoResult); rr != nil {
+			results[res.Id().(string)] = rr
+		}
+	}
+	return results
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/orijtech/apache2conform/conform"
+	"gopkg.in/src-d/go-git.v4"
+)
+
+func TestConformRepos(t *testing.T) {
+	root, cleanup := tempDir(t)
+	defer cleanup()
+	var dirPaths []string
+	for i := 0; i < 6; i++ {
+		dirPath := filepath.Join(root, fmt.Sprintf("svc%d", i))
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dirPath, "a.go"), []byte("package a\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		dirPaths = append(dirPaths, dirPath)
+	}
+
+	for _, maxParallel := range []uint{1, 2, 4} {
+		// Every repo has a single file, so the results being
+		// reported at once are those of repos open at once.
+		var mu sync.Mutex
+		active, most := 0, 0
+		opts := conform.Options{NoGit: true, Concurrency: 1}
+		opts.OnResult = func(*conform.FileResult) {
+			mu.Lock()
+			active++
+			if active > most {
+				most = active
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}
+		results := conformRepos(opts, root, dirPaths, maxParallel)
+		if len(results) != len(dirPaths) {
+			t.Errorf("max %d: got results for %d repos, want %d", maxParallel, len(results), len(dirPaths))
+		}
+		for _, dirPath := range dirPaths {
+			if rr := results[dirPath]; rr == nil || rr.err != nil || len(rr.rep.Files) != 1 {
+				t.Errorf("max %d: %s: got %+v", maxParallel, dirPath, rr)
+			}
+		}
+		if most > int(maxParallel) {
+			t.Errorf("max %d: got %d repos at once", maxParallel, most)
+		}
+	}
+
+	// No repo is started once one would not parse.
+	if err := ioutil.WriteFile(filepath.Join(dirPaths[1], "b.go"), []byte("package a\nfunc {\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results := conformRepos(conform.Options{NoGit: true, Fix: true, FixOnlyIfValid: true}, root, dirPaths[:3], 1)
+	if rr := results[dirPaths[1]]; rr == nil || len(rr.rep.Unparsable) != 1 {
+		t.Errorf("got %+v for the repo that would not parse", rr)
+	}
+	if rr := results[dirPaths[2]]; rr != nil {
+		t.Errorf("got %+v for a repo after the one that would not parse, want none", rr)
+	}
+}
+
+func TestMaxParallelReposFlag(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	if out, ok := tr.run("-max-parallel-repos", "0"); ok || !strings.Contains(out, "must be at least 1") {
+		t.Errorf("got success %v, output:\n%s", ok, out)
+	}
+	cacheFile := filepath.Join(tr.gopath, "blame.json")
+	if out, ok := tr.run("-max-parallel-repos", "2", "-blame-cache-file", cacheFile, "example.com/other"); ok || !strings.Contains(out, "cannot be shared") {
+		t.Errorf("got success %v, output:\n%s", ok, out)
+	}
+}
+
+func TestSeveralReposDiff(t *testing.T) {
+	tr, cleanup := newTestRepo(t)
+	defer cleanup()
+	tr.commit("Alice", inYear(2018), map[string]string{"a.go": testSource})
+	dir := filepath.Join(tr.gopath, "src", "example.com", "other")
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &testRepo{t: t, gopath: tr.gopath, dir: dir, repo: repo}
+	other.commit("Bob", inYear(2019), map[string]string{"a.go": testSource})
+
+	// Each repo's a.go is named by its path under $GOPATH/src.
+	stdout, stderr, ok := tr.runStdout("-diff", "example.com/other")
+	if !ok {
+		t.Fatalf("main failed:\n%s", stderr)
+	}
+	for _, want := range []string{"--- a/" + testImportPath + "/a.go\n", "+++ b/example.com/other/a.go\n"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("got diff\n%s\nwant it to contain %q", stdout, want)
+		}
+	}
+	if strings.Contains(stdout, "--- a/a.go\n") {
+		t.Errorf("got diff\n%s\nwith a bare a/a.go", stdout)
+	}
+
+	// A single repo's diff keeps repo relative paths.
+	stdout, stderr, ok = tr.runStdout("-diff")
+	if !ok || !strings.Contains(stdout, "--- a/a.go\n") {
+		t.Errorf("got success %v, diff:\n%s\nstderr:\n%s", ok, stdout, stderr)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 orijtech Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestFileDescriptorLimitLowered(t *testing.T) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		t.Skipf("cannot read the open files limit: %v", err)
+	}
+	if rlim.Cur < 64 {
+		t.Skipf("open files limit of %d is already too low", rlim.Cur)
+	}
+	lowered := rlim
+	lowered.Cur = 64
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skipf("cannot lower the open files limit: %v", err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlim)
+
+	limit, ok := fileDescriptorLimit()
+	if !ok || limit != 64 {
+		t.Fatalf("got a limit of %d, %v, want 64", limit, ok)
+	}
+	if got := clampConcurrency(32, limit); got != 16 {
+		t.Errorf("got concurrency %d, want 16", got)
+	}
+}